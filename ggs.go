@@ -26,6 +26,8 @@ import (
 	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/config"
 	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/loadbalancer"
 	"github.com/leon-yc/ggs/internal/core/registry"
 
 	//router
@@ -35,9 +37,13 @@ import (
 	// registry
 	"github.com/leon-yc/ggs/internal/core/metadata"
 	_ "github.com/leon-yc/ggs/internal/core/registry/consul"
+	_ "github.com/leon-yc/ggs/internal/core/registry/dns"
+	_ "github.com/leon-yc/ggs/internal/core/registry/etcd"
 	_ "github.com/leon-yc/ggs/internal/core/registry/file"
+	_ "github.com/leon-yc/ggs/internal/core/registry/kubernetes"
 	_ "github.com/leon-yc/ggs/internal/core/registry/servicecenter"
 	"github.com/leon-yc/ggs/internal/core/server"
+	"github.com/leon-yc/ggs/internal/healthz/checker"
 
 	//trace
 	_ "github.com/leon-yc/ggs/internal/core/tracing/jaeger"
@@ -78,6 +84,137 @@ func Gin(opts ...server.RegisterOption) (*gin.Engine, error) {
 	return egn.gin(opts...)
 }
 
+//GinGroup return a *gin.RouterGroup, the routes of the group use the provider chain set by WithChain
+func GinGroup(relativePath string, opts ...server.RegisterOption) (*gin.RouterGroup, error) {
+	return egn.ginGroup(relativePath, opts...)
+}
+
+//RegisterOption is the option of RegisterSchema, Gin and GinGroup
+type RegisterOption = server.RegisterOption
+
+//WithChain sets the provider chain of the routes of a gin group, e.g. ggs.GinGroup("/public", ggs.WithChain("public"))
+func WithChain(name string) RegisterOption {
+	return server.WithChain(name)
+}
+
+//OutlierEvent is reported when an instance is ejected or readmitted by the outlier detection
+type OutlierEvent = loadbalancer.OutlierEvent
+
+//OnOutlierEvent adds a listener of the outlier events, it should be called before Init
+func OnOutlierEvent(l func(e *OutlierEvent)) {
+	loadbalancer.AddOutlierListener(l)
+}
+
+//the types of RegistryEvent
+const (
+	InstanceAdded   = registry.EventAdded
+	InstanceRemoved = registry.EventRemoved
+	InstanceUpdated = registry.EventUpdated
+)
+
+//RegistryEvent is a change of an instance of a service
+type RegistryEvent = registry.Event
+
+//WatchService calls f when the instances of the service are added, removed or updated,
+//f is called in the order of the changes by a goroutine of the watch, it returns the function to stop the watch
+func WatchService(service string, f func(e RegistryEvent)) func() {
+	return registry.Watch(service, f)
+}
+
+//the chain types of the handlers
+const (
+	ConsumerChain = common.Consumer
+	ProviderChain = common.Provider
+)
+
+//Handler is a handler of the handler chains
+type Handler = handler.Handler
+
+//HandlerChain calls the handlers in order, a handler calls HandlerChain.Next to go on
+type HandlerChain = handler.Chain
+
+//Invocation is the request passed through the handler chains
+type Invocation = invocation.Invocation
+
+//Response is the response of an invocation
+type Response = invocation.Response
+
+//ResponseCallBack receives the response of the rest of the chain
+type ResponseCallBack = invocation.ResponseCallBack
+
+//HandlerOption decides where RegisterOrderedHandler inserts the handler
+type HandlerOption = handler.RegisterOption
+
+//HandlerBefore inserts the handler right before the named handler
+func HandlerBefore(name string) HandlerOption {
+	return handler.Before(name)
+}
+
+//HandlerAfter inserts the handler right after the named handler
+func HandlerAfter(name string) HandlerOption {
+	return handler.After(name)
+}
+
+//HandlerOfChain inserts the handler into the chains of the type only, ConsumerChain or ProviderChain
+func HandlerOfChain(chainType string) HandlerOption {
+	return handler.WithChainType(chainType)
+}
+
+//RegisterOrderedHandler registers a custom handler, it is inserted into the chains at the position
+//declared by HandlerBefore or HandlerAfter, it should be called before Init
+func RegisterOrderedHandler(name string, f func() Handler, opts ...HandlerOption) error {
+	return handler.RegisterOrderedHandler(name, f, opts...)
+}
+
+//InsertOrderedHandlers returns the comma separated handler names of the chain type with the ordered handlers inserted
+func InsertOrderedHandlers(chainType, handlerNames string) string {
+	return handler.InsertOrderedHandlers(chainType, handlerNames)
+}
+
+//ChainInfo describes a handler chain
+type ChainInfo = handler.ChainInfo
+
+//ListChains returns all handler chains, sorted by type and name
+func ListChains() []ChainInfo {
+	return handler.ListChains()
+}
+
+//DescribeChain returns the handler names of the chain in order
+func DescribeChain(chainType, name string) ([]string, error) {
+	return handler.DescribeChain(chainType, name)
+}
+
+//Invoker is the synchronous form of the rest of a chain
+type Invoker = handler.Invoker
+
+//Middleware wraps the next invoker, it is the middleware style of Handler
+type Middleware = handler.Middleware
+
+//Compose composes the middlewares, the first one is the outermost
+func Compose(ms ...Middleware) Middleware {
+	return handler.Compose(ms...)
+}
+
+//FromHandler adapts a callback style handler to a middleware
+func FromHandler(h Handler) Middleware {
+	return handler.FromHandler(h)
+}
+
+//ToHandler adapts a middleware to a callback style handler
+func ToHandler(name string, m Middleware) Handler {
+	return handler.ToHandler(name, m)
+}
+
+//RegisterMiddleware registers a middleware as a custom handler, it can be used in the chains by the name
+func RegisterMiddleware(name string, m Middleware) error {
+	return handler.RegisterMiddleware(name, m)
+}
+
+//Recover converts the panics of the next invoker to errors
+func Recover() Middleware {
+	return handler.Recover()
+}
+
 //setDefaultConsumerChains your custom chain map for Consumer,if there is no config, this default chain will take affect
 func setDefaultConsumerChains(c map[string]string) {
 	egn.DefaultConsumerChainNames = c
@@ -147,6 +284,7 @@ func waitingSignal(opts RunOptions) {
 		}
 		qlog.Info(name + " server stop success")
 	}
+	checker.Close()
 
 	if archaius.GetBool("ggs.metrics.autometrics.enabled", false) && !isGraceRestart {
 		metrics.DeAutoRegistryMetrics()
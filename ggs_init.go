@@ -189,11 +189,33 @@ func (c *engine) gin(opts ...server.RegisterOption) (*gin.Engine, error) {
 		return nil, err
 	}
 	if ginServer, ok := s.(server.GinServer); ok {
+		if regOpts.ChainName != "" {
+			ginServer.Group("/", opts...)
+		}
 		return ginServer.Engine().(*gin.Engine), nil
 	}
 	return nil, fmt.Errorf("server(%s) is not implemented with gin", serverName)
 }
 
+func (c *engine) ginGroup(relativePath string, opts ...server.RegisterOption) (*gin.RouterGroup, error) {
+	if !c.Initialized {
+		return nil, fmt.Errorf("the ggs do not init. please run ggs.Init() first")
+	}
+	regOpts := server.NewRegisterOptions(opts...)
+	serverName := regOpts.ServerName
+	if serverName == "" {
+		serverName = "rest"
+	}
+	s, err := server.GetServer(serverName)
+	if err != nil {
+		return nil, err
+	}
+	if ginServer, ok := s.(server.GinServer); ok {
+		return ginServer.Group(relativePath, opts...).(*gin.RouterGroup), nil
+	}
+	return nil, fmt.Errorf("server(%s) is not implemented with gin", serverName)
+}
+
 func (c *engine) start() error {
 	if !c.Initialized {
 		return fmt.Errorf("the ggs do not init. please run ggs.Init() first")
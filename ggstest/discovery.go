@@ -0,0 +1,85 @@
+package ggstest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+// StaticDiscovery is a registry.ServiceDiscovery which returns the instances given by the test
+type StaticDiscovery struct {
+	mu        sync.RWMutex
+	instances map[string][]*registry.MicroServiceInstance
+}
+
+// NewStaticDiscovery creates a static discovery with the instances keyed by service name
+func NewStaticDiscovery(instances map[string][]*registry.MicroServiceInstance) *StaticDiscovery {
+	d := &StaticDiscovery{
+		instances: make(map[string][]*registry.MicroServiceInstance),
+	}
+	for service, ins := range instances {
+		d.SetInstances(service, ins...)
+	}
+	return d
+}
+
+// SetInstances replaces the instances of the service
+func (d *StaticDiscovery) SetInstances(service string, instances ...*registry.MicroServiceInstance) {
+	d.mu.Lock()
+	d.instances[service] = instances
+	d.mu.Unlock()
+}
+
+// GetMicroServiceID returns the service name as its id
+func (d *StaticDiscovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
+	return microServiceName, nil
+}
+
+// GetAllMicroServices returns all services which have instances
+func (d *StaticDiscovery) GetAllMicroServices() ([]*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make([]*registry.MicroService, 0, len(d.instances))
+	for name := range d.instances {
+		services = append(services, &registry.MicroService{ServiceID: name, ServiceName: name})
+	}
+	return services, nil
+}
+
+// GetMicroService returns the service of the id
+func (d *StaticDiscovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, ok := d.instances[microServiceID]; !ok {
+		return nil, fmt.Errorf("service %s not found", microServiceID)
+	}
+	return &registry.MicroService{ServiceID: microServiceID, ServiceName: microServiceID}, nil
+}
+
+// GetMicroServiceInstances returns the instances of the provider
+func (d *StaticDiscovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.instances[providerID], nil
+}
+
+// FindMicroServiceInstances returns the instances of the service, the tags are ignored
+func (d *StaticDiscovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	instances, ok := d.instances[microServiceName]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", microServiceName)
+	}
+	return instances, nil
+}
+
+// AutoSync is noop
+func (d *StaticDiscovery) AutoSync() {}
+
+// Close is noop
+func (d *StaticDiscovery) Close() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package ggstest builds handler chains, servers and service discovery in process,
+// so the services built on ggs can be tested without conf files and real registries.
+package ggstest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/control"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/loadbalancer"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/core/server"
+	"github.com/leon-yc/ggs/internal/pkg/circuit"
+	"github.com/leon-yc/ggs/pkg/metrics"
+
+	// import the plugins the harness relies on
+	_ "github.com/leon-yc/ggs/internal/client/grpc"
+	_ "github.com/leon-yc/ggs/internal/client/rest"
+	_ "github.com/leon-yc/ggs/internal/control/archaius"
+	_ "github.com/leon-yc/ggs/internal/server/ginhttp"
+	_ "github.com/leon-yc/ggs/internal/server/grpc"
+)
+
+// aliases of the internal types, so they can be used out of ggs
+type (
+	// Instance is an instance of the service returned by the fake discovery
+	Instance = registry.MicroServiceInstance
+	// Invocation is the invocation passed to the chains
+	Invocation = invocation.Invocation
+	// Response is the response of the chains
+	Response = invocation.Response
+	// Invoker takes the place of the transport or the business handler at the end of the chain
+	Invoker = handler.Invoker
+)
+
+// Options struct having information about the harness
+type Options struct {
+	instances map[string][]*registry.MicroServiceInstance
+}
+
+// Option used by New
+type Option func(*Options)
+
+// WithInstances is option to add static instances of the service to the fake discovery.
+func WithInstances(service string, instances ...*registry.MicroServiceInstance) Option {
+	return func(o *Options) {
+		o.instances[service] = append(o.instances[service], instances...)
+	}
+}
+
+// Harness holds the in process environment of a test
+type Harness struct {
+	// Discovery is the fake service discovery used by the loadbalance handler
+	Discovery *StaticDiscovery
+	// Recorder stores the invocations passed the recorder handler
+	Recorder *Recorder
+
+	oldDiscovery registry.ServiceDiscovery
+	mu           sync.Mutex
+	servers      []server.ProtocolServer
+}
+
+var metricsOnce sync.Once
+
+// New initializes ggs from the configs in memory, e.g. {"service.name": "test",
+// "ggs.handler.chain.Provider.default": "ratelimiter-provider,ggstest-recorder"},
+// and creates the chains and the fake discovery
+func New(configs map[string]interface{}, options ...Option) (*Harness, error) {
+	opts := Options{
+		instances: make(map[string][]*registry.MicroServiceInstance),
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	if err := config.InitWithConfigs(configs); err != nil {
+		return nil, err
+	}
+	if err := control.Init(control.Options{Infra: config.GlobalDefinition.Panel.Infra}); err != nil {
+		return nil, err
+	}
+	circuit.Init()
+	if config.GlobalDefinition.Ggs.Metrics.Enabled {
+		var err error
+		metricsOnce.Do(func() {
+			err = metrics.Init()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := loadbalancer.Enable(""); err != nil {
+		return nil, err
+	}
+
+	h := &Harness{
+		Discovery:    NewStaticDiscovery(opts.instances),
+		Recorder:     &Recorder{},
+		oldDiscovery: registry.DefaultServiceDiscoveryService,
+	}
+	registry.DefaultServiceDiscoveryService = h.Discovery
+	setRecorder(h.Recorder)
+
+	chains := config.GlobalDefinition.Ggs.Handler.Chain
+	if err := createChains(common.Provider, chains.Provider); err != nil {
+		return nil, err
+	}
+	if err := createChains(common.Consumer, chains.Consumer); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// createChains creates the chains of the type, and an empty default chain if it is missing
+func createChains(chainType string, handlerNameMap map[string]string) error {
+	m := map[string]string{common.DefaultChainName: ""}
+	for k, v := range handlerNameMap {
+		m[k] = v
+	}
+	return handler.CreateChains(chainType, m)
+}
+
+// Chain returns the chain of the type and name
+func (h *Harness) Chain(chainType, name string) (*handler.Chain, error) {
+	return handler.GetChain(chainType, name)
+}
+
+// Invoke runs the invocation through the chain, and the terminal invoker takes the place of
+// the transport or the business handler, so the chain is exercised without network
+func (h *Harness) Invoke(ctx context.Context, chainType, name string, i *invocation.Invocation, terminal handler.Invoker) (*invocation.Response, error) {
+	c, err := h.Chain(chainType, name)
+	if err != nil {
+		return nil, err
+	}
+	handlers := make([]handler.Handler, 0, len(c.Handlers)+1)
+	handlers = append(handlers, c.Handlers...)
+	handlers = append(handlers, handler.ToHandler("ggstest-terminal", func(handler.Invoker) handler.Invoker {
+		return terminal
+	}))
+	tc := &handler.Chain{
+		ServiceType: c.ServiceType,
+		Name:        c.Name,
+		Handlers:    handlers,
+	}
+
+	if i.Ctx == nil {
+		i.Ctx = ctx
+	}
+	i.HandlerIndex = 0
+	return tc.Invoke(i)
+}
+
+// Close stops the servers and restores the service discovery
+func (h *Harness) Close() error {
+	h.mu.Lock()
+	servers := h.servers
+	h.servers = nil
+	h.mu.Unlock()
+
+	var err error
+	for _, s := range servers {
+		if e := s.Stop(); e != nil {
+			err = e
+		}
+	}
+	registry.DefaultServiceDiscoveryService = h.oldDiscovery
+	setRecorder(nil)
+	return err
+}
+
+func (h *Harness) addServer(s server.ProtocolServer) {
+	h.mu.Lock()
+	h.servers = append(h.servers, s)
+	h.mu.Unlock()
+}
@@ -0,0 +1,148 @@
+package ggstest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/leon-yc/ggs/ggstest"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// h is shared by the tests, the config can be initialized once in a process
+var h *ggstest.Harness
+
+func TestMain(m *testing.M) {
+	var err error
+	h, err = ggstest.New(map[string]interface{}{
+		"service.name":                       "hello",
+		"ggs.handler.chain.Consumer.default": "loadbalance," + ggstest.RecorderHandler,
+		"ggs.handler.chain.Provider.gin":     ggstest.RecorderHandler,
+		"ggs.handler.chain.Provider.grpc":    ggstest.RecorderHandler,
+	}, ggstest.WithInstances("world", &ggstest.Instance{
+		InstanceID: "1", ServiceID: "world", EndpointsMap: map[string]string{common.ProtocolRest: "127.0.0.1:8080"},
+	}))
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	h.Close()
+	os.Exit(code)
+}
+
+func TestInvokeConsumerChain(t *testing.T) {
+	h.Recorder.Reset()
+
+	c, err := h.Chain(common.Consumer, common.DefaultChainName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, handler := range c.Handlers {
+		names = append(names, handler.Name())
+	}
+	if len(names) != 2 || names[0] != "loadbalancer" || names[1] != ggstest.RecorderHandler {
+		t.Fatalf("unexpected handlers of the chain %v", names)
+	}
+
+	inv := &ggstest.Invocation{MicroServiceName: "world", Protocol: common.ProtocolRest}
+	resp, err := h.Invoke(context.Background(), common.Consumer, common.DefaultChainName, inv,
+		func(ctx context.Context, i *ggstest.Invocation) (*ggstest.Response, error) {
+			if i.Endpoint != "127.0.0.1:8080" {
+				t.Errorf("the instance of the fake discovery is not picked, endpoint %q", i.Endpoint)
+			}
+			return &ggstest.Response{Status: http.StatusOK}, nil
+		})
+	if err != nil || resp.Status != http.StatusOK {
+		t.Fatalf("unexpected response %+v, %v", resp, err)
+	}
+
+	records := h.Recorder.Records()
+	if len(records) != 1 {
+		t.Fatalf("want 1 record, got %d", len(records))
+	}
+	r := records[0]
+	if r.ChainType != common.Consumer || r.Invocation.MicroServiceName != "world" || r.Response.Status != http.StatusOK {
+		t.Errorf("unexpected record %+v", r)
+	}
+	h.Recorder.Reset()
+	if len(h.Recorder.Records()) != 0 {
+		t.Error("the records are kept after reset")
+	}
+}
+
+func TestGinServer(t *testing.T) {
+	h.Recorder.Reset()
+	gs, err := h.GinServer("gin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.Engine().GET("/hello", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	if err := gs.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(gs.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, body)
+	}
+
+	records := h.Recorder.Records()
+	if len(records) != 1 {
+		t.Fatalf("want 1 record, got %d", len(records))
+	}
+	if r := records[0]; r.ChainType != common.Provider || r.ChainName != "gin" {
+		t.Errorf("unexpected record of chain %s/%s", r.ChainType, r.ChainName)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	h.Recorder.Reset()
+	rs, err := h.GRPCServer("grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Register(health.NewServer(), ggstest.WithRPCServiceDesc(&healthpb.Health_ServiceDesc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := rs.Dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected status %s", resp.Status)
+	}
+
+	records := h.Recorder.Records()
+	if len(records) != 1 {
+		t.Fatalf("want 1 record, got %d", len(records))
+	}
+	if r := records[0]; r.ChainName != "grpc" || r.Invocation.OperationID != "/grpc.health.v1.Health/Check" {
+		t.Errorf("unexpected record of %s %s", r.ChainName, r.Invocation.OperationID)
+	}
+}
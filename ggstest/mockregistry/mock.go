@@ -0,0 +1,326 @@
+// Package mockregistry is a registry plugin whose instances are managed by code, it is used to
+// test loadbalance, health check and router without consul or the service center.
+// It is installed by importing the package in the tests:
+//
+//	import _ "github.com/leon-yc/ggs/ggstest/mockregistry"
+package mockregistry
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+// Name is the name of the plugin, set ggs.service.registry.serviceDiscovery.type to mock to enable it
+const Name = "mock"
+
+// constants of the event actions
+const (
+	//EventCreate means an instance is added
+	EventCreate = "CREATE"
+	//EventUpdate means an instance is changed, e.g. healthy or unhealthy
+	EventUpdate = "UPDATE"
+	//EventDelete means an instance is removed
+	EventDelete = "DELETE"
+)
+
+// constants of the instance status
+const (
+	StatusUp   = common.DefaultStatus
+	StatusDown = "DOWN"
+)
+
+// Event is the change of an instance
+type Event struct {
+	Action      string
+	ServiceName string
+	Instance    *registry.MicroServiceInstance
+}
+
+// Discovery is a registry.ServiceDiscovery which keeps instances in memory
+type Discovery struct {
+	mu        sync.RWMutex
+	instances map[string][]*registry.MicroServiceInstance
+	watchers  []func(Event)
+}
+
+// New creates an empty mock discovery
+func New() *Discovery {
+	return &Discovery{
+		instances: make(map[string][]*registry.MicroServiceInstance),
+	}
+}
+
+// defaultDiscovery is shared by the plugin and the package functions
+var defaultDiscovery = New()
+
+// Default returns the discovery which the plugin installs
+func Default() *Discovery {
+	return defaultDiscovery
+}
+
+// AddInstance adds an instance to the service of the default discovery
+func AddInstance(service string, instance *registry.MicroServiceInstance) {
+	defaultDiscovery.AddInstance(service, instance)
+}
+
+// RemoveInstance removes an instance from the service of the default discovery
+func RemoveInstance(service, instanceID string) error {
+	return defaultDiscovery.RemoveInstance(service, instanceID)
+}
+
+// SetHealthy marks an instance of the default discovery healthy or not
+func SetHealthy(service, instanceID string, healthy bool) error {
+	return defaultDiscovery.SetHealthy(service, instanceID, healthy)
+}
+
+// Watch registers a function receiving the events of the default discovery
+func Watch(f func(Event)) {
+	defaultDiscovery.Watch(f)
+}
+
+// Reset removes all instances and watchers of the default discovery
+func Reset() {
+	defaultDiscovery.Reset()
+}
+
+// AddInstance adds a copy of the instance to the service, an instance with the same id is replaced
+func (d *Discovery) AddInstance(service string, instance *registry.MicroServiceInstance) {
+	// the caller may change the instance later, so keep a copy
+	instance = copyInstance(instance)
+	if instance.Status == "" {
+		instance.Status = StatusUp
+	}
+	if instance.Metadata == nil {
+		instance.Metadata = make(map[string]string)
+	}
+	if instance.ServiceID == "" {
+		instance.ServiceID = service
+	}
+
+	action := EventCreate
+	d.mu.Lock()
+	instances := d.instances[service]
+	for _, ins := range instances {
+		if ins.InstanceID == instance.InstanceID {
+			action = EventUpdate
+			break
+		}
+	}
+	if action == EventUpdate {
+		d.replace(service, instance)
+	} else {
+		d.instances[service] = append(instances[:len(instances):len(instances)], instance)
+	}
+	d.notifyRegistry(service)
+	d.mu.Unlock()
+
+	d.notify(Event{Action: action, ServiceName: service, Instance: copyInstance(instance)})
+}
+
+// RemoveInstance removes an instance from the service
+func (d *Discovery) RemoveInstance(service, instanceID string) error {
+	d.mu.Lock()
+	instances := d.instances[service]
+	var removed *registry.MicroServiceInstance
+	for k, ins := range instances {
+		if ins.InstanceID == instanceID {
+			removed = ins
+			d.instances[service] = append(instances[:k:k], instances[k+1:]...)
+			d.notifyRegistry(service)
+			break
+		}
+	}
+	d.mu.Unlock()
+	if removed == nil {
+		return fmt.Errorf("instance %s of service %s not found", instanceID, service)
+	}
+
+	d.notify(Event{Action: EventDelete, ServiceName: service, Instance: copyInstance(removed)})
+	return nil
+}
+
+// SetHealthy marks an instance healthy or not, unhealthy instances are not returned by FindMicroServiceInstances
+func (d *Discovery) SetHealthy(service, instanceID string, healthy bool) error {
+	status := StatusDown
+	if healthy {
+		status = StatusUp
+	}
+
+	d.mu.Lock()
+	var changed *registry.MicroServiceInstance
+	found := false
+	for _, ins := range d.instances[service] {
+		if ins.InstanceID != instanceID {
+			continue
+		}
+		found = true
+		if ins.Status != status {
+			// the instances returned before keep the old status, so change a copy
+			changed = copyInstance(ins)
+			changed.Status = status
+			d.replace(service, changed)
+			d.notifyRegistry(service)
+		}
+		break
+	}
+	d.mu.Unlock()
+	if !found {
+		return fmt.Errorf("instance %s of service %s not found", instanceID, service)
+	}
+
+	if changed != nil {
+		d.notify(Event{Action: EventUpdate, ServiceName: service, Instance: copyInstance(changed)})
+	}
+	return nil
+}
+
+// replace must be called with the lock held
+func (d *Discovery) replace(service string, instance *registry.MicroServiceInstance) {
+	old := d.instances[service]
+	instances := make([]*registry.MicroServiceInstance, len(old))
+	for k, ins := range old {
+		if ins.InstanceID == instance.InstanceID {
+			ins = instance
+		}
+		instances[k] = ins
+	}
+	d.instances[service] = instances
+}
+
+// Watch registers a function receiving the events, it is called synchronously in the order of changes
+func (d *Discovery) Watch(f func(Event)) {
+	d.mu.Lock()
+	d.watchers = append(d.watchers, f)
+	d.mu.Unlock()
+}
+
+// Reset removes all instances and watchers
+func (d *Discovery) Reset() {
+	d.mu.Lock()
+	for service := range d.instances {
+		registry.NotifyInstances(service, nil)
+	}
+	d.instances = make(map[string][]*registry.MicroServiceInstance)
+	d.watchers = nil
+	d.mu.Unlock()
+}
+
+// notifyRegistry feeds the healthy instances to registry.Watch, it must be called with the lock held,
+// so the changes are notified in order
+func (d *Discovery) notifyRegistry(service string) {
+	instances := make([]*registry.MicroServiceInstance, 0, len(d.instances[service]))
+	for _, ins := range d.instances[service] {
+		if ins.Status == StatusUp {
+			instances = append(instances, copyInstance(ins))
+		}
+	}
+	registry.NotifyInstances(service, instances)
+}
+
+// copyInstance copies the instance with its maps, so the kept instances are not shared with the callers
+func copyInstance(ins *registry.MicroServiceInstance) *registry.MicroServiceInstance {
+	c := *ins
+	c.EndpointsMap = copyMap(ins.EndpointsMap)
+	c.Metadata = copyMap(ins.Metadata)
+	if ins.DataCenterInfo != nil {
+		dc := *ins.DataCenterInfo
+		c.DataCenterInfo = &dc
+	}
+	return &c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (d *Discovery) notify(e Event) {
+	d.mu.RLock()
+	watchers := d.watchers
+	d.mu.RUnlock()
+	for _, f := range watchers {
+		f(e)
+	}
+}
+
+// GetMicroServiceID returns the service name as its id
+func (d *Discovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
+	return microServiceName, nil
+}
+
+// GetAllMicroServices returns all services which have instances
+func (d *Discovery) GetAllMicroServices() ([]*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make([]*registry.MicroService, 0, len(d.instances))
+	for name := range d.instances {
+		services = append(services, &registry.MicroService{ServiceID: name, ServiceName: name, Status: StatusUp})
+	}
+	return services, nil
+}
+
+// GetMicroService returns the service of the id
+func (d *Discovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, ok := d.instances[microServiceID]; !ok {
+		return nil, fmt.Errorf("service %s not found", microServiceID)
+	}
+	return &registry.MicroService{ServiceID: microServiceID, ServiceName: microServiceID, Status: StatusUp}, nil
+}
+
+// GetMicroServiceInstances returns all instances of the provider, including the unhealthy ones
+func (d *Discovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	instances := make([]*registry.MicroServiceInstance, 0, len(d.instances[providerID]))
+	for _, ins := range d.instances[providerID] {
+		instances = append(instances, copyInstance(ins))
+	}
+	return instances, nil
+}
+
+// FindMicroServiceInstances returns the healthy instances of the service which have the tags
+func (d *Discovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	all, ok := d.instances[microServiceName]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", microServiceName)
+	}
+	instances := make([]*registry.MicroServiceInstance, 0, len(all))
+	for _, ins := range all {
+		if ins.Status != StatusUp || !ins.Has(tags.KV) {
+			continue
+		}
+		instances = append(instances, copyInstance(ins))
+	}
+	return instances, nil
+}
+
+// AutoSync is noop
+func (d *Discovery) AutoSync() {}
+
+// Close is noop
+func (d *Discovery) Close() error {
+	return nil
+}
+
+func newDiscovery(opts registry.Options) registry.ServiceDiscovery {
+	return defaultDiscovery
+}
+
+func init() {
+	registry.InstallRegistrator(Name, newRegistrator)
+	registry.InstallServiceDiscovery(Name, newDiscovery)
+}
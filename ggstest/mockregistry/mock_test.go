@@ -0,0 +1,78 @@
+package mockregistry
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestInstancesAreCopied(t *testing.T) {
+	d := New()
+	ins := &registry.MicroServiceInstance{InstanceID: "a", EndpointsMap: map[string]string{"rest": "127.0.0.1:80"}}
+	d.AddInstance("copied", ins)
+	if ins.Status != "" || ins.ServiceID != "" || ins.Metadata != nil {
+		t.Errorf("the instance of the caller is changed: %+v", ins)
+	}
+
+	// changing the added instance or the found instances does not change the discovery
+	ins.EndpointsMap["rest"] = "127.0.0.1:81"
+	found, err := d.FindMicroServiceInstances("", "copied", utiltags.Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found[0].Metadata["changed"] = "true"
+	found[0].Status = StatusDown
+
+	again, _ := d.FindMicroServiceInstances("", "copied", utiltags.Tags{})
+	if len(again) != 1 {
+		t.Fatalf("want 1 instance, got %d", len(again))
+	}
+	if got := again[0]; got.EndpointsMap["rest"] != "127.0.0.1:80" || got.Metadata["changed"] != "" || got.Status != StatusUp {
+		t.Errorf("the kept instance is changed: %+v", got)
+	}
+}
+
+func TestChangesAreWatched(t *testing.T) {
+	d := New()
+	events := make(chan registry.Event, 10)
+	stop := registry.Watch("watched", func(e registry.Event) { events <- e })
+	defer stop()
+
+	d.AddInstance("watched", &registry.MicroServiceInstance{InstanceID: "a"})
+	expect(t, events, registry.EventAdded, "a")
+	if err := d.SetHealthy("watched", "a", false); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, events, registry.EventRemoved, "a")
+	if err := d.SetHealthy("watched", "a", true); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, events, registry.EventAdded, "a")
+	if err := d.RemoveInstance("watched", "a"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, events, registry.EventRemoved, "a")
+}
+
+func expect(t *testing.T, events chan registry.Event, typ, instanceID string) {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.Type != typ || e.Instance.InstanceID != instanceID {
+			t.Fatalf("want %s of %s, got %s of %s", typ, instanceID, e.Type, e.Instance.InstanceID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s event of %s", typ, instanceID)
+	}
+}
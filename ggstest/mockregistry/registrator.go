@@ -0,0 +1,89 @@
+package mockregistry
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+// Registrator registers the instances into the default discovery, so a service can discover itself
+type Registrator struct {
+	d *Discovery
+}
+
+var instanceSeq int64
+
+// Close is noop
+func (r *Registrator) Close() error {
+	return nil
+}
+
+// RegisterService returns the service name as its id
+func (r *Registrator) RegisterService(microService *registry.MicroService) (string, error) {
+	return microService.ServiceName, nil
+}
+
+// RegisterServiceInstance adds the instance to the service, an instance without id gets a generated one
+func (r *Registrator) RegisterServiceInstance(sid string, instance *registry.MicroServiceInstance) (string, error) {
+	id := instance.InstanceID
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", sid, atomic.AddInt64(&instanceSeq, 1))
+		instance = copyInstance(instance)
+		instance.InstanceID = id
+	}
+	r.d.AddInstance(sid, instance)
+	return id, nil
+}
+
+// RegisterServiceAndInstance registers the service and adds the instance
+func (r *Registrator) RegisterServiceAndInstance(microService *registry.MicroService, instance *registry.MicroServiceInstance) (string, string, error) {
+	sid, err := r.RegisterService(microService)
+	if err != nil {
+		return "", "", err
+	}
+	iid, err := r.RegisterServiceInstance(sid, instance)
+	if err != nil {
+		return "", "", err
+	}
+	return sid, iid, nil
+}
+
+// Heartbeat is always ok
+func (r *Registrator) Heartbeat(microServiceID, microServiceInstanceID string) (bool, error) {
+	return true, nil
+}
+
+// AddDependencies is noop
+func (r *Registrator) AddDependencies(dep *registry.MicroServiceDependency) error {
+	return nil
+}
+
+// UnRegisterMicroServiceInstance removes the instance
+func (r *Registrator) UnRegisterMicroServiceInstance(microServiceID, microServiceInstanceID string) error {
+	return r.d.RemoveInstance(microServiceID, microServiceInstanceID)
+}
+
+// UpdateMicroServiceInstanceStatus marks the instance healthy if the status is UP
+func (r *Registrator) UpdateMicroServiceInstanceStatus(microServiceID, microServiceInstanceID, status string) error {
+	return r.d.SetHealthy(microServiceID, microServiceInstanceID, status == StatusUp)
+}
+
+// UpdateMicroServiceProperties is noop
+func (r *Registrator) UpdateMicroServiceProperties(microServiceID string, properties map[string]string) error {
+	return nil
+}
+
+// UpdateMicroServiceInstanceProperties is noop
+func (r *Registrator) UpdateMicroServiceInstanceProperties(microServiceID, microServiceInstanceID string, properties map[string]string) error {
+	return nil
+}
+
+// AddSchemas is noop
+func (r *Registrator) AddSchemas(microServiceID, schemaName, schemaInfo string) error {
+	return nil
+}
+
+func newRegistrator(opts registry.Options) registry.Registrator {
+	return &Registrator{d: defaultDiscovery}
+}
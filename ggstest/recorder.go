@@ -0,0 +1,92 @@
+package ggstest
+
+import (
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+)
+
+// RecorderHandler is the name of the handler which records invocations,
+// put it into the chains in config, e.g. "ggs.handler.chain.Provider.default": "ratelimiter-provider,ggstest-recorder"
+const RecorderHandler = "ggstest-recorder"
+
+// Record is a recorded invocation and its response
+type Record struct {
+	ChainType  string
+	ChainName  string
+	Invocation invocation.Invocation
+	Response   *invocation.Response
+}
+
+// Recorder stores the records of the recorder handler
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// Records returns the records in order
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := make([]Record, len(r.records))
+	copy(records, r.records)
+	return records
+}
+
+// Reset drops all records
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.records = nil
+	r.mu.Unlock()
+}
+
+func (r *Recorder) add(rec Record) {
+	r.mu.Lock()
+	r.records = append(r.records, rec)
+	r.mu.Unlock()
+}
+
+// currentRecorder is the recorder of the latest harness
+var (
+	currentRecorder *Recorder
+	recorderMu      sync.RWMutex
+	registerOnce    sync.Once
+)
+
+func setRecorder(r *Recorder) {
+	recorderMu.Lock()
+	currentRecorder = r
+	recorderMu.Unlock()
+	registerOnce.Do(func() {
+		handler.RegisterHandler(RecorderHandler, func() handler.Handler {
+			return &recorderHandler{}
+		})
+	})
+}
+
+// recorderHandler records the invocation when the rest of the chain responds
+type recorderHandler struct{}
+
+// Handle implements handler.Handler
+func (rh *recorderHandler) Handle(chain *handler.Chain, i *invocation.Invocation, cb invocation.ResponseCallBack) {
+	chain.Next(i, func(resp *invocation.Response) error {
+		recorderMu.RLock()
+		r := currentRecorder
+		recorderMu.RUnlock()
+		if r != nil {
+			r.add(Record{
+				ChainType:  chain.ServiceType,
+				ChainName:  chain.Name,
+				Invocation: *i,
+				Response:   resp,
+			})
+		}
+		return cb(resp)
+	})
+}
+
+// Name implements handler.Handler
+func (rh *recorderHandler) Name() string {
+	return RecorderHandler
+}
@@ -0,0 +1,106 @@
+package ggstest
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/gin-gonic/gin"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1024 * 1024
+
+// RegisterOption is the option of registering the routes and services to the servers
+type RegisterOption = server.RegisterOption
+
+// WithChain sets the provider chain of a gin route group
+var WithChain = server.WithChain
+
+// WithRPCServiceDesc sets the *grpc.ServiceDesc of the service registered to GRPCServer
+var WithRPCServiceDesc = server.WithRPCServiceDesc
+
+// GinServer is a gin server listening on a loopback port
+type GinServer struct {
+	server.ProtocolServer
+	// URL is the base url of the server, e.g. http://127.0.0.1:38080
+	URL string
+}
+
+// Engine returns the gin engine to add routes
+func (s *GinServer) Engine() *gin.Engine {
+	return s.ProtocolServer.(server.GinServer).Engine().(*gin.Engine)
+}
+
+// Group creates a route group, with WithChain the group runs the named provider chain
+func (s *GinServer) Group(relativePath string, opts ...RegisterOption) *gin.RouterGroup {
+	return s.ProtocolServer.(server.GinServer).Group(relativePath, opts...).(*gin.RouterGroup)
+}
+
+// GinServer creates a gin server which runs the provider chain of the name,
+// add the routes to Engine, then Start it
+func (h *Harness) GinServer(chainName string) (*GinServer, error) {
+	f, err := server.GetServerFunc("rest")
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if chainName == "" {
+		chainName = common.DefaultChainName
+	}
+	s := f(server.Options{
+		Address:            l.Addr().String(),
+		Listen:             l,
+		ProtocolServerName: "rest",
+		ChainName:          chainName,
+	})
+	h.addServer(s)
+	return &GinServer{
+		ProtocolServer: s,
+		URL:            fmt.Sprintf("http://%s", l.Addr().String()),
+	}, nil
+}
+
+// GRPCServer is a grpc server listening on an in memory connection
+type GRPCServer struct {
+	server.ProtocolServer
+	lis *bufconn.Listener
+}
+
+// Dial connects to the server, the connection is not closed by the harness
+func (s *GRPCServer) Dial(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	}, opts...)
+	return grpc.DialContext(ctx, "bufnet", opts...)
+}
+
+// GRPCServer creates a grpc server which runs the provider chain of the name,
+// register the services with WithRPCServiceDesc, then Start it
+func (h *Harness) GRPCServer(chainName string) (*GRPCServer, error) {
+	f, err := server.GetServerFunc("grpc")
+	if err != nil {
+		return nil, err
+	}
+	if chainName == "" {
+		chainName = common.DefaultChainName
+	}
+	lis := bufconn.Listen(bufSize)
+	s := f(server.Options{
+		Address:            "bufnet",
+		Listen:             lis,
+		ProtocolServerName: "grpc",
+		ChainName:          chainName,
+	})
+	h.addServer(s)
+	return &GRPCServer{ProtocolServer: s, lis: lis}, nil
+}
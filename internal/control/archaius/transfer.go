@@ -37,6 +37,7 @@ func SaveToLBCache(raw *model.LoadBalancing) {
 func saveDefaultLB(raw *model.LoadBalancing) string { // return updated key
 	c := control.LoadBalancingConfig{
 		Strategy:                raw.Strategy["name"],
+		Filters:                 splitFilters(raw.Filters),
 		RetryEnabled:            raw.RetryEnabled,
 		RetryOnSame:             raw.RetryOnSame,
 		RetryOnNext:             raw.RetryOnNext,
@@ -54,6 +55,7 @@ func saveDefaultLB(raw *model.LoadBalancing) string { // return updated key
 func saveEachLB(k string, raw model.LoadBalancingSpec) string { // return updated key
 	c := control.LoadBalancingConfig{
 		Strategy:                raw.Strategy["name"],
+		Filters:                 splitFilters(raw.Filters),
 		RetryEnabled:            raw.RetryEnabled,
 		RetryOnSame:             raw.RetryOnSame,
 		RetryOnNext:             raw.RetryOnNext,
@@ -68,6 +70,17 @@ func saveEachLB(k string, raw model.LoadBalancingSpec) string { // return update
 	return k
 }
 
+// splitFilters splits the comma separated filters and criteria, the empty ones are dropped
+func splitFilters(s string) []string {
+	var filters []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
 func setDefaultLBValue(c *control.LoadBalancingConfig) {
 	if c.Strategy == "" {
 		c.Strategy = loadbalancer.StrategyRoundRobin
@@ -149,6 +162,10 @@ func reloadLBCache(src *model.LoadBalancing) map[string]bool { //return updated
 		return keys
 	}
 	for name, conf := range src.AnyService {
+		// the service without filters uses the global filters
+		if strings.TrimSpace(conf.Filters) == "" {
+			conf.Filters = src.Filters
+		}
 		k = saveEachLB(name, conf)
 		keys[k] = true
 	}
@@ -84,6 +84,9 @@ const (
 // SessionNameSpaceKey metadata session namespace key
 const SessionNameSpaceKey = "_Session_Namespace"
 
+// InstanceKey metadata key of the instance picked by the load balancer
+const InstanceKey = "_Instance"
+
 // SessionNameSpaceDefaultValue default session namespace value
 const SessionNameSpaceDefaultValue = "default"
 
@@ -168,6 +168,15 @@ func ReadGlobalConfigFromArchaius() error {
 	return nil
 }
 
+// ReadHandlerChainFromArchaius reads the latest handler chains from archaius
+func ReadHandlerChainFromArchaius() (model.ChainStruct, error) {
+	global := &model.GlobalCfg{}
+	if err := archaius.UnmarshalConfig(global); err != nil {
+		return model.ChainStruct{}, err
+	}
+	return global.Ggs.Handler.Chain, nil
+}
+
 // ReadLBFromArchaius for to unmarshal the global config file(chassis.yaml) information
 func ReadLBFromArchaius() error {
 	lbMutex.Lock()
@@ -329,7 +338,39 @@ func Init() error {
 	if err != nil {
 		return err
 	}
+	return initRuntime()
+}
+
+// memoryInited is true if archaius is initialized by InitWithConfigs
+var memoryInited bool
+
+// InitWithConfigs initializes the configuration from the key values in memory instead of the conf dir,
+// it is used by tests, and the previous configs are cleaned
+func InitWithConfigs(configs map[string]interface{}) error {
+	if memoryInited {
+		if err := archaius.Clean(); err != nil {
+			return err
+		}
+	}
+	if err := archaius.Init(archaius.WithMemorySource()); err != nil {
+		return err
+	}
+	memoryInited = true
+	for k, v := range configs {
+		if err := archaius.Set(k, v); err != nil {
+			return err
+		}
+	}
+
+	if err := readFromArchaius(); err != nil {
+		return err
+	}
+	return initRuntime()
+}
 
+// initRuntime sets the runtime info of the service from the configuration
+func initRuntime() error {
+	var err error
 	runtime.ServiceName = MicroserviceDefinition.ServiceDescription.Name
 	runtime.Version = MicroserviceDefinition.ServiceDescription.Version
 	runtime.Environment = MicroserviceDefinition.ServiceDescription.Environment
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+)
+
+const (
+	hcPrefix                     = "ggs.healthCheck"
+	propertyHCEnabled            = "enabled"
+	propertyHCProbe              = "probe"
+	propertyHCPath               = "path"
+	propertyHCGrpcService        = "grpcService"
+	propertyHCIntervalMs         = "intervalMs"
+	propertyHCTimeoutMs          = "timeoutMs"
+	propertyHCUnhealthyThreshold = "unhealthyThreshold"
+	propertyHCHealthyThreshold   = "healthyThreshold"
+
+	//DefaultHealthCheckPath is the default path of the http probe
+	DefaultHealthCheckPath = "/ping"
+	//DefaultHealthCheckIntervalMs is the default interval of the health check
+	DefaultHealthCheckIntervalMs = 10000
+	//DefaultHealthCheckTimeoutMs is the default timeout of a probe
+	DefaultHealthCheckTimeoutMs = 2000
+	//DefaultUnhealthyThreshold is the default number of the successive failures to eject an instance
+	DefaultUnhealthyThreshold = 3
+	//DefaultHealthyThreshold is the default number of the successive successes to readmit an instance
+	DefaultHealthyThreshold = 2
+)
+
+// the value of <prefix>.<service>.<key> overrides <prefix>.<key>
+func serviceString(prefix, service, key, def string) string {
+	return archaius.GetString(genKey(prefix, service, key), archaius.GetString(genKey(prefix, key), def))
+}
+
+func serviceBool(prefix, service, key string, def bool) bool {
+	return archaius.GetBool(genKey(prefix, service, key), archaius.GetBool(genKey(prefix, key), def))
+}
+
+// serviceInt returns the default if the value is not positive
+func serviceInt(prefix, service, key string, def int) int {
+	v := archaius.GetInt(genKey(prefix, service, key), archaius.GetInt(genKey(prefix, key), def))
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+//HealthCheckEnabled returns true if the active health check of the service is enabled
+func HealthCheckEnabled(service string) bool {
+	return serviceBool(hcPrefix, service, propertyHCEnabled, false)
+}
+
+//HealthCheckProbe returns the probe name of the service, empty means the probe of the protocol
+func HealthCheckProbe(service string) string {
+	return serviceString(hcPrefix, service, propertyHCProbe, "")
+}
+
+//HealthCheckPath returns the path of the http probe
+func HealthCheckPath(service string) string {
+	return serviceString(hcPrefix, service, propertyHCPath, DefaultHealthCheckPath)
+}
+
+//HealthCheckGrpcService returns the service name of the grpc health check
+func HealthCheckGrpcService(service string) string {
+	return serviceString(hcPrefix, service, propertyHCGrpcService, "")
+}
+
+//HealthCheckInterval returns the interval of the health check
+func HealthCheckInterval(service string) time.Duration {
+	return time.Duration(serviceInt(hcPrefix, service, propertyHCIntervalMs, DefaultHealthCheckIntervalMs)) * time.Millisecond
+}
+
+//HealthCheckTimeout returns the timeout of a probe
+func HealthCheckTimeout(service string) time.Duration {
+	return time.Duration(serviceInt(hcPrefix, service, propertyHCTimeoutMs, DefaultHealthCheckTimeoutMs)) * time.Millisecond
+}
+
+//UnhealthyThreshold returns the number of the successive failures to eject an instance
+func UnhealthyThreshold(service string) int {
+	return serviceInt(hcPrefix, service, propertyHCUnhealthyThreshold, DefaultUnhealthyThreshold)
+}
+
+//HealthyThreshold returns the number of the successive successes to readmit an instance
+func HealthyThreshold(service string) int {
+	return serviceInt(hcPrefix, service, propertyHCHealthyThreshold, DefaultHealthyThreshold)
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/leon-yc/ggs/internal/pkg/backoff"
 	"github.com/go-chassis/go-archaius"
@@ -11,6 +13,13 @@ import (
 const (
 	lbPrefix                                 = "ggs.loadbalance"
 	propertyStrategyName                     = "strategy.name"
+	propertyStrategyHashKey                  = "strategy.hashKey"
+	propertyStrategyVirtualNodes             = "strategy.virtualNodes"
+	propertyWeights                          = "weights"
+	propertySlowStartWindowMs                = "slowStart.windowMs"
+	propertySlowStartMinWeightPercent        = "slowStart.minWeightPercent"
+	propertyLocalityMinHealthyInstances      = "locality.minHealthyInstances"
+	propertyLocalityFailover                 = "locality.failover"
 	propertySessionStickinessRuleTimeout     = "SessionStickinessRule.sessionTimeoutInSeconds"
 	propertySessionStickinessRuleFailedTimes = "SessionStickinessRule.successiveFailedTimes"
 	propertyRetryEnabled                     = "retryEnabled"
@@ -26,6 +35,14 @@ const (
 	DefaultSessionTimeout = 30
 	//DefaultFailedTimes is default value for failed times
 	DefaultFailedTimes = 5
+	//DefaultVirtualNodes is default number of the points of an instance on the hash ring
+	DefaultVirtualNodes = 160
+	//DefaultWeight is default weight of the instances without weight
+	DefaultWeight = 100
+	//DefaultSlowStartMinWeightPercent is default weight percent of an instance when the slow start begins
+	DefaultSlowStartMinWeightPercent = 10
+	//DefaultLocalityMinHealthyInstances is default number of the healthy instances a locality needs to take all traffic
+	DefaultLocalityMinHealthyInstances = 1
 )
 
 var lbMutex = sync.RWMutex{}
@@ -132,3 +149,78 @@ func BackOffMaxMs(source, service string) int {
 	ms := archaius.GetInt(genKey(lbPrefix, service, propertyBackoffMaxMs), global)
 	return ms
 }
+
+//GetHashKey return the source of the hash key of the consistent hash strategy, like header:X-User-Id
+func GetHashKey(source, service string) string {
+	return serviceString(lbPrefix, service, propertyStrategyHashKey, "")
+}
+
+//GetVirtualNodes return the number of the points of an instance on the hash ring
+func GetVirtualNodes(source, service string) int {
+	return serviceInt(lbPrefix, service, propertyStrategyVirtualNodes, DefaultVirtualNodes)
+}
+
+//GetInstanceWeights return the weights which override the weights of the instances, the config is like
+//"foo-1=50,10.0.0.1:8080=20", the key is the instance id or an endpoint of the instance
+func GetInstanceWeights(source, service string) map[string]int {
+	v := archaius.GetString(genKey(lbPrefix, service, propertyWeights), "")
+	if v == "" {
+		return nil
+	}
+	weights := make(map[string]int)
+	for _, kv := range strings.Split(v, ",") {
+		pair := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if err != nil || w < 0 {
+			continue
+		}
+		weights[strings.TrimSpace(pair[0])] = w
+	}
+	return weights
+}
+
+//SlowStartWindow return the time to ramp a new instance to its full weight, 0 means no slow start
+func SlowStartWindow(source, service string) time.Duration {
+	return time.Duration(serviceInt(lbPrefix, service, propertySlowStartWindowMs, 0)) * time.Millisecond
+}
+
+//SlowStartMinWeightPercent return the weight percent of a new instance when the slow start begins
+func SlowStartMinWeightPercent(source, service string) int {
+	p := serviceInt(lbPrefix, service, propertySlowStartMinWeightPercent, DefaultSlowStartMinWeightPercent)
+	if p > 100 {
+		return 100
+	}
+	return p
+}
+
+//LocalityMinHealthyInstances return the number of the healthy instances a locality needs to take all its traffic,
+//the locality with fewer instances takes a proportional part and spills the rest over to the next locality
+func LocalityMinHealthyInstances(source, service string) int {
+	lbMutex.RLock()
+	global := GetLoadBalancing().Locality.MinHealthyInstances
+	n := archaius.GetInt(genKey(lbPrefix, service, propertyLocalityMinHealthyInstances), global)
+	lbMutex.RUnlock()
+	if n <= 0 {
+		return DefaultLocalityMinHealthyInstances
+	}
+	return n
+}
+
+//LocalityFailover return the failover order after the local zone, the locality is like region/zone or region,
+//the config is like "cn-east/az2,cn-north", empty means the other zones of the local region
+func LocalityFailover(source, service string) []string {
+	lbMutex.RLock()
+	global := GetLoadBalancing().Locality.Failover
+	v := archaius.GetString(genKey(lbPrefix, service, propertyLocalityFailover), global)
+	lbMutex.RUnlock()
+	var localities []string
+	for _, l := range strings.Split(v, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			localities = append(localities, l)
+		}
+	}
+	return localities
+}
@@ -20,6 +20,8 @@ type LoadBalancing struct {
 	Filters               string                       `yaml:"serverListFilters"`
 	Backoff               BackoffStrategy              `yaml:"backoff"`
 	SessionStickinessRule SessionStickinessRule        `yaml:"SessionStickinessRule"`
+	Locality              Locality                     `yaml:"locality"`
+	SlowStart             SlowStart                    `yaml:"slowStart"`
 	AnyService            map[string]LoadBalancingSpec `yaml:",inline"`
 }
 
@@ -30,7 +32,10 @@ type LoadBalancingSpec struct {
 	RetryEnabled          bool                  `yaml:"retryEnabled"`
 	RetryOnNext           int                   `yaml:"retryOnNext"`
 	RetryOnSame           int                   `yaml:"retryOnSame"`
+	Filters               string                `yaml:"serverListFilters"`
 	Backoff               BackoffStrategy       `yaml:"backoff"`
+	Locality              Locality              `yaml:"locality"`
+	SlowStart             SlowStart             `yaml:"slowStart"`
 }
 
 // SessionStickinessRule loadbalancing structure
@@ -45,3 +50,15 @@ type BackoffStrategy struct {
 	MinMs int    `yaml:"minMs"`
 	MaxMs int    `yaml:"maxMs"`
 }
+
+// Locality locality aware loadbalancing structure
+type Locality struct {
+	MinHealthyInstances int    `yaml:"minHealthyInstances"`
+	Failover            string `yaml:"failover"`
+}
+
+// SlowStart slow start of the new instances structure
+type SlowStart struct {
+	WindowMs         int `yaml:"windowMs"`
+	MinWeightPercent int `yaml:"minWeightPercent"`
+}
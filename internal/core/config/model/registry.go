@@ -28,6 +28,7 @@ type RegistryStruct struct {
 	ContractDiscovery ContractDiscoveryStruct `yaml:"contractDiscovery"`
 	HealthCheck       bool                    `yaml:"healthCheck"`
 	CacheIndex        bool                    `yaml:"cacheIndex"`
+	KeyPrefix         string                  `yaml:"keyPrefix"`
 }
 
 //RegistratorStruct service registry config struct
@@ -40,6 +41,7 @@ type RegistratorStruct struct {
 	Tenant          string                   `yaml:"tenant"`
 	AutoRegister    string                   `yaml:"register"`
 	APIVersion      RegistryAPIVersionStruct `yaml:"api"`
+	NameTemplate    string                   `yaml:"nameTemplate"`
 }
 
 //ServiceDiscoveryStruct service discovery config struct
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"time"
+)
+
+const (
+	odPrefix                     = "ggs.outlierDetection"
+	propertyODEnabled            = "enabled"
+	propertyODConsecutiveErrors  = "consecutiveErrors"
+	propertyODLatencyThresholdMs = "latencyThresholdMs"
+	propertyODBaseEjectionTimeMs = "baseEjectionTimeMs"
+	propertyODMaxEjectionTimeMs  = "maxEjectionTimeMs"
+	propertyODMaxEjectionPercent = "maxEjectionPercent"
+
+	//DefaultConsecutiveErrors is the default number of the successive errors to eject an instance
+	DefaultConsecutiveErrors = 5
+	//DefaultBaseEjectionTimeMs is the default ejection time of the first ejection
+	DefaultBaseEjectionTimeMs = 30000
+	//DefaultMaxEjectionTimeMs is the default max ejection time
+	DefaultMaxEjectionTimeMs = 300000
+	//DefaultMaxEjectionPercent is the default max percent of the ejected instances of a service
+	DefaultMaxEjectionPercent = 10
+)
+
+//OutlierDetectionEnabled returns true if the outlier detection of the service is enabled
+func OutlierDetectionEnabled(service string) bool {
+	return serviceBool(odPrefix, service, propertyODEnabled, false)
+}
+
+//ConsecutiveErrors returns the number of the successive 5xx or transport errors to eject an instance
+func ConsecutiveErrors(service string) int {
+	return serviceInt(odPrefix, service, propertyODConsecutiveErrors, DefaultConsecutiveErrors)
+}
+
+//LatencyThreshold returns the average latency to eject an instance, 0 means the latency is not checked
+func LatencyThreshold(service string) time.Duration {
+	return time.Duration(serviceInt(odPrefix, service, propertyODLatencyThresholdMs, 0)) * time.Millisecond
+}
+
+//BaseEjectionTime returns the ejection time of the first ejection, it doubles for each ejection
+func BaseEjectionTime(service string) time.Duration {
+	return time.Duration(serviceInt(odPrefix, service, propertyODBaseEjectionTimeMs, DefaultBaseEjectionTimeMs)) * time.Millisecond
+}
+
+//MaxEjectionTime returns the max ejection time
+func MaxEjectionTime(service string) time.Duration {
+	return time.Duration(serviceInt(odPrefix, service, propertyODMaxEjectionTimeMs, DefaultMaxEjectionTimeMs)) * time.Millisecond
+}
+
+//MaxEjectionPercent returns the max percent of the ejected instances of the service
+func MaxEjectionPercent(service string) int {
+	p := serviceInt(odPrefix, service, propertyODMaxEjectionPercent, DefaultMaxEjectionPercent)
+	if p > 100 {
+		return 100
+	}
+	return p
+}
@@ -84,3 +84,18 @@ func GetServiceDiscoveryConfigPath() string {
 	}
 	return DefaultConfigPath
 }
+
+// GetReferenceDiscovery returns the discovery plugin of the referenced service, empty means the default one
+func GetReferenceDiscovery(service string) string {
+	return archaius.GetString("ggs.references."+service+".discovery", "")
+}
+
+// GetReferenceDiscoveryAddress returns the registry address of the discovery plugin of the referenced service
+func GetReferenceDiscoveryAddress(service string) string {
+	return archaius.GetString("ggs.references."+service+".discoveryAddress", "")
+}
+
+// GetReferenceDiscoveryTenant returns the tenant of the discovery plugin of the referenced service
+func GetReferenceDiscoveryTenant(service string) string {
+	return archaius.GetString("ggs.references."+service+".discoveryTenant", "")
+}
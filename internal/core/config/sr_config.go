@@ -69,6 +69,16 @@ func GetRegistratorAPIVersion() string {
 	return GlobalDefinition.Ggs.Service.Registry.APIVersion.Version
 }
 
+// GetRegistratorNameTemplate returns the template of the registered service name of a protocol, e.g. {service}-{protocol}
+func GetRegistratorNameTemplate() string {
+	return GlobalDefinition.Ggs.Service.Registry.Registrator.NameTemplate
+}
+
+// GetRegistryKeyPrefix returns the key prefix of the instances in the kv registries like etcd
+func GetRegistryKeyPrefix() string {
+	return GlobalDefinition.Ggs.Service.Registry.KeyPrefix
+}
+
 // GetRegistratorDisable returns the Disable of service registry
 func GetRegistratorDisable() bool {
 	if b := archaius.GetBool("ggs.service.registry.registrator.disabled", false); b {
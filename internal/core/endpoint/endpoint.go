@@ -17,12 +17,17 @@ func GetEndpointFromServiceCenter(appID, microService, version string) (string,
 	var endPoint string
 
 	tags := utiltags.NewDefaultTag(version, appID)
-	instances, err := registry.DefaultServiceDiscoveryService.FindMicroServiceInstances(runtime.ServiceID, microService, tags)
+	sd, err := registry.GetServiceDiscovery(microService)
+	if err != nil {
+		return "", err
+	}
+	instances, err := sd.FindMicroServiceInstances(runtime.ServiceID, microService, tags)
 	if err != nil {
 		qlog.Warnf("Get service instance failed, for key: %s:%s:%s",
 			appID, microService, version)
 		return "", err
 	}
+	instances = registry.FilterEjected(microService, instances)
 
 	if len(instances) == 0 {
 		instanceError := fmt.Sprintf("No available instance, key: %s:%s:%s",
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/leon-yc/ggs/internal/control"
 	"github.com/leon-yc/ggs/internal/core/common"
@@ -31,6 +32,11 @@ func (bk *BizKeeperConsumerHandler) Handle(chain *Chain, i *invocation.Invocatio
 	cmdConfig.MetricsConsumerNum = archaius.GetInt("ggs.metrics.circuitMetricsConsumerNum", hystrix.DefaultMetricsConsumerNum)
 	hystrix.ConfigureCommand(command, cmdConfig)
 
+	if i.IsStream {
+		bk.handleStream(chain, i, cb, command)
+		return
+	}
+
 	finish := make(chan *invocation.Response, 1)
 	f, err := GetFallbackFun(command, common.Consumer, i, finish, cmdConfig.ForceFallback)
 	if err != nil {
@@ -62,6 +68,38 @@ func (bk *BizKeeperConsumerHandler) Handle(chain *Chain, i *invocation.Invocatio
 	cb(<-finish)
 }
 
+// handleStream checks the circuit before opening the stream, and reports
+// the result to the circuit when the stream ends instead of when it is opened
+func (bk *BizKeeperConsumerHandler) handleStream(chain *Chain, i *invocation.Invocation, cb invocation.ResponseCallBack, command string) {
+	circuit, _, err := hystrix.GetCircuit(command)
+	if err != nil {
+		writeErr(err, cb)
+		return
+	}
+	start := time.Now()
+	if !circuit.AllowRequest() {
+		circuit.ReportEvent([]string{"short-circuit"}, start, 0)
+		writeErr(hystrix.ErrCircuitOpen, cb)
+		return
+	}
+
+	chain.Next(i, func(resp *invocation.Response) error {
+		if resp.Err != nil {
+			circuit.ReportEvent([]string{"failure"}, start, time.Since(start))
+			return cb(resp)
+		}
+		i.OnStreamDone(func(r *invocation.Response) error {
+			if r.Err != nil {
+				circuit.ReportEvent([]string{"failure"}, start, time.Since(start))
+			} else {
+				circuit.ReportEvent([]string{"success"}, start, time.Since(start))
+			}
+			return nil
+		})
+		return cb(resp)
+	})
+}
+
 // GetFallbackFun get fallback function
 func GetFallbackFun(cmd, t string, i *invocation.Invocation, finish chan *invocation.Response, isForce bool) (func(error) error, error) {
 	enabled := config.GetFallbackEnabled(cmd, t)
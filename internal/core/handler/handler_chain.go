@@ -3,7 +3,9 @@ package handler
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/invocation"
@@ -12,17 +14,24 @@ import (
 
 var errEmptyChain = errors.New("chain can not be empty")
 
-// ChainMap just concurrent read
-var ChainMap = make(map[string]*Chain)
+// chainMap stores the chains by type and name, a chain is never modified after it is stored,
+// so it can be replaced at runtime while requests are still using the old one
+var (
+	chainMap        = make(map[string]*Chain)
+	chainMu         sync.RWMutex
+	reloadListeners []func(chainType string, handlerNameMap map[string]string)
+)
 
 // Chain struct for service and handlers
 type Chain struct {
 	ServiceType string
 	Name        string
 	Handlers    []Handler
+	// names are the registered names of the handlers
+	names []string
 }
 
-// AddHandler chain can add a handler
+// AddHandler chain can add a handler, do not call it on a chain which is in use
 func (c *Chain) AddHandler(h Handler) {
 	c.Handlers = append(c.Handlers, h)
 }
@@ -70,20 +79,54 @@ func parseHandlers(handlerStr string) []string {
 	return s
 }
 
-//CreateChains create the chains based on type and handler map
+//CreateChains create the chains based on type and handler map, the ordered handlers are inserted into every chain,
+//the chains are stored only if all of them are created
 func CreateChains(chainType string, handlerNameMap map[string]string) error {
+	chains := make([]*Chain, 0, len(handlerNameMap))
 	for chainName := range handlerNameMap {
-		handlerNames := parseHandlers(handlerNameMap[chainName])
+		handlerNames := parseHandlers(InsertOrderedHandlers(chainType, handlerNameMap[chainName]))
 		c, err := CreateChain(chainType, chainName, handlerNames...)
 		if err != nil {
 			return fmt.Errorf("err create chain %s.%s:%s %s", chainType, chainName, handlerNames, err.Error())
 		}
-		ChainMap[chainType+chainName] = c
+		chains = append(chains, c)
+	}
 
+	chainMu.Lock()
+	for _, c := range chains {
+		chainMap[chainType+c.Name] = c
 	}
+	chainMu.Unlock()
 	return nil
 }
 
+//ReloadChains rebuilds the chains of the type at runtime, the chains are replaced atomically,
+//chains not in the handler map are kept, so the running servers can always find their chains
+func ReloadChains(chainType string, handlerNameMap map[string]string) error {
+	if err := CreateChains(chainType, handlerNameMap); err != nil {
+		return err
+	}
+	for name := range handlerNameMap {
+		names, _ := DescribeChain(chainType, name)
+		qlog.Infof("%s chain [%s] is reloaded: %s", chainType, name, strings.Join(names, ","))
+	}
+	chainMu.RLock()
+	listeners := reloadListeners
+	chainMu.RUnlock()
+	for _, l := range listeners {
+		l(chainType, handlerNameMap)
+	}
+	return nil
+}
+
+//AddReloadListener adds a listener which is called with the handler map after the chains of the type are reloaded,
+//e.g. the servers rebind the paths to the reloaded chains
+func AddReloadListener(l func(chainType string, handlerNameMap map[string]string)) {
+	chainMu.Lock()
+	reloadListeners = append(reloadListeners, l)
+	chainMu.Unlock()
+}
+
 //CreateChain create consumer or provider's chain,the handlers is different
 func CreateChain(serviceType string, chainName string, handlerNames ...string) (*Chain, error) {
 	c := &Chain{
@@ -113,6 +156,7 @@ func addHandler(c *Chain, name string) error {
 		return err
 	}
 	c.AddHandler(handler)
+	c.names = append(c.names, name)
 	return nil
 }
 
@@ -121,9 +165,62 @@ func GetChain(serviceType string, name string) (*Chain, error) {
 	if name == "" {
 		name = common.DefaultChainName
 	}
-	origin, ok := ChainMap[serviceType+name]
+	chainMu.RLock()
+	origin, ok := chainMap[serviceType+name]
+	chainMu.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("get chain [%s] failed", serviceType+name)
 	}
 	return origin, nil
 }
+
+// HandlerNames returns the registered names of the handlers in order
+func (c *Chain) HandlerNames() []string {
+	names := make([]string, 0, len(c.Handlers))
+	for i, h := range c.Handlers {
+		if i < len(c.names) {
+			names = append(names, c.names[i])
+		} else {
+			names = append(names, h.Name())
+		}
+	}
+	return names
+}
+
+// ChainInfo describes a chain
+type ChainInfo struct {
+	ServiceType string   `json:"type"`
+	Name        string   `json:"name"`
+	Handlers    []string `json:"handlers"`
+}
+
+// ListChains returns all chains, sorted by type and name
+func ListChains() []ChainInfo {
+	chainMu.RLock()
+	infos := make([]ChainInfo, 0, len(chainMap))
+	for _, c := range chainMap {
+		infos = append(infos, ChainInfo{
+			ServiceType: c.ServiceType,
+			Name:        c.Name,
+			Handlers:    c.HandlerNames(),
+		})
+	}
+	chainMu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ServiceType != infos[j].ServiceType {
+			return infos[i].ServiceType < infos[j].ServiceType
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// DescribeChain returns the handler names of the chain in order
+func DescribeChain(serviceType, name string) ([]string, error) {
+	c, err := GetChain(serviceType, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.HandlerNames(), nil
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// RegisterOptions decides where a custom handler is inserted into the chains
+type RegisterOptions struct {
+	// ChainType is common.Consumer or common.Provider, empty means both
+	ChainType string
+	before    string
+	after     string
+}
+
+// RegisterOption is option of RegisterOrderedHandler
+type RegisterOption func(*RegisterOptions)
+
+// Before inserts the handler right before the named handler
+func Before(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.before = name
+		o.after = ""
+	}
+}
+
+// After inserts the handler right after the named handler
+func After(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.after = name
+		o.before = ""
+	}
+}
+
+// WithChainType inserts the handler into the chains of the type only, common.Consumer or common.Provider
+func WithChainType(t string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.ChainType = t
+	}
+}
+
+// orderedHandler is a registered handler with its position
+type orderedHandler struct {
+	name string
+	opts RegisterOptions
+}
+
+var (
+	orderedHandlers []orderedHandler
+	orderedMu       sync.Mutex
+)
+
+// RegisterOrderedHandler registers a custom handler, and inserts it into the chains created later,
+// both the default chains and the chains of the config, at the position declared by Before or After, e.g.
+// RegisterOrderedHandler("auth", newAuth, Before(Loadbalance), WithChainType(common.Consumer))
+func RegisterOrderedHandler(name string, f func() Handler, options ...RegisterOption) error {
+	var opts RegisterOptions
+	for _, o := range options {
+		o(&opts)
+	}
+	if opts.before == "" && opts.after == "" {
+		return fmt.Errorf("handler [%s] must be registered with Before or After", name)
+	}
+	if err := RegisterHandler(name, f); err != nil {
+		return err
+	}
+
+	orderedMu.Lock()
+	orderedHandlers = append(orderedHandlers, orderedHandler{name: name, opts: opts})
+	orderedMu.Unlock()
+	return nil
+}
+
+// InsertOrderedHandlers inserts the ordered handlers into the comma separated handler names of the chain type,
+// a handler already in the names is left as it is, and a handler whose anchor is missing is skipped
+func InsertOrderedHandlers(chainType string, handlerStr string) string {
+	names := parseHandlers(handlerStr)
+
+	orderedMu.Lock()
+	pending := make([]orderedHandler, 0, len(orderedHandlers))
+	for _, h := range orderedHandlers {
+		if h.opts.ChainType != "" && h.opts.ChainType != chainType {
+			continue
+		}
+		if indexOf(names, h.name) >= 0 {
+			continue
+		}
+		pending = append(pending, h)
+	}
+	orderedMu.Unlock()
+
+	// an anchor may be another ordered handler, so repeat until nothing can be inserted
+	for inserted := true; inserted && len(pending) > 0; {
+		inserted = false
+		rest := pending[:0]
+		for _, h := range pending {
+			pos := -1
+			if h.opts.before != "" {
+				pos = indexOf(names, h.opts.before)
+			} else if idx := indexOf(names, h.opts.after); idx >= 0 {
+				pos = idx + 1
+			}
+			if pos < 0 {
+				rest = append(rest, h)
+				continue
+			}
+			names = append(names[:pos], append([]string{h.name}, names[pos:]...)...)
+			inserted = true
+		}
+		pending = rest
+	}
+	for _, h := range pending {
+		qlog.Warnf("handler [%s] is not inserted into %s chain, the anchor handler [%s%s] is missing",
+			h.name, chainType, h.opts.before, h.opts.after)
+	}
+	return strings.Join(names, ",")
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
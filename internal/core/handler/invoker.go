@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	rt "runtime"
+
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// Invoker is the synchronous form of the rest of a chain
+type Invoker func(ctx context.Context, i *invocation.Invocation) (*invocation.Response, error)
+
+// Middleware wraps the next invoker, it is the middleware style of Handler
+type Middleware func(next Invoker) Invoker
+
+// Compose composes the middlewares, the first one is the outermost
+func Compose(ms ...Middleware) Middleware {
+	return func(next Invoker) Invoker {
+		for k := len(ms) - 1; k >= 0; k-- {
+			next = ms[k](next)
+		}
+		return next
+	}
+}
+
+// FromHandler adapts a callback style handler to a middleware, so it can be
+// composed or tested with a fake next invoker
+func FromHandler(h Handler) Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, i *invocation.Invocation) (*invocation.Response, error) {
+			c := &Chain{
+				Handlers: []Handler{h, &invokerHandler{next: next}},
+			}
+			i.Ctx = ctx
+			index := i.HandlerIndex
+			i.HandlerIndex = 0
+			r, err := c.Invoke(i)
+			i.HandlerIndex = index
+			return r, err
+		}
+	}
+}
+
+// ToHandler adapts a middleware to a callback style handler, so it can be used in chains
+func ToHandler(name string, m Middleware) Handler {
+	return &middlewareHandler{name: name, m: m}
+}
+
+// RegisterMiddleware registers a middleware as a custom handler
+func RegisterMiddleware(name string, m Middleware) error {
+	return RegisterHandler(name, func() Handler {
+		return ToHandler(name, m)
+	})
+}
+
+// Recover converts the panics of the next invoker to errors
+func Recover() Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, i *invocation.Invocation) (r *invocation.Response, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					var stacktrace string
+					for k := 1; ; k++ {
+						_, f, l, got := rt.Caller(k)
+						if !got {
+							break
+						}
+						stacktrace += fmt.Sprintf("%s:%d\n", f, l)
+					}
+					qlog.WithFields(qlog.Fields{
+						"panic": p,
+						"stack": stacktrace,
+					}).Error("handler chain panic.")
+					err = fmt.Errorf("handler chain panic: %v", p)
+					r = &invocation.Response{Err: err}
+				}
+			}()
+			return next(ctx, i)
+		}
+	}
+}
+
+// Invoke runs the chain from the current handler index, and returns the response synchronously
+func (c *Chain) Invoke(i *invocation.Invocation) (*invocation.Response, error) {
+	var r *invocation.Response
+	c.Next(i, func(ir *invocation.Response) error {
+		r = ir
+		if ir != nil {
+			return ir.Err
+		}
+		return nil
+	})
+	if r == nil {
+		r = &invocation.Response{}
+	}
+	return r, r.Err
+}
+
+// invokerHandler calls the next invoker as the last handler of a chain
+type invokerHandler struct {
+	next Invoker
+}
+
+// Handle implements Handler
+func (ih *invokerHandler) Handle(chain *Chain, i *invocation.Invocation, cb invocation.ResponseCallBack) {
+	r, err := ih.next(i.Ctx, i)
+	if r == nil {
+		r = &invocation.Response{}
+	}
+	if r.Err == nil {
+		r.Err = err
+	}
+	cb(r)
+}
+
+// Name implements Handler
+func (ih *invokerHandler) Name() string {
+	return "invoker"
+}
+
+// middlewareHandler runs a middleware in a chain
+type middlewareHandler struct {
+	name string
+	m    Middleware
+}
+
+// Handle implements Handler
+func (mh *middlewareHandler) Handle(chain *Chain, i *invocation.Invocation, cb invocation.ResponseCallBack) {
+	// next can be called more than once, e.g. retry, it always starts from the next handler
+	index := i.HandlerIndex
+	next := func(ctx context.Context, i *invocation.Invocation) (*invocation.Response, error) {
+		i.Ctx = ctx
+		i.HandlerIndex = index
+		return chain.Invoke(i)
+	}
+	r, err := mh.m(next)(i.Ctx, i)
+	if r == nil {
+		r = &invocation.Response{}
+	}
+	if r.Err == nil {
+		r.Err = err
+	}
+	cb(r)
+}
+
+// Name implements Handler
+func (mh *middlewareHandler) Name() string {
+	return mh.name
+}
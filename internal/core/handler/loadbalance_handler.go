@@ -13,6 +13,7 @@ import (
 	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/invocation"
 	"github.com/leon-yc/ggs/internal/core/loadbalancer"
+	"github.com/leon-yc/ggs/internal/core/registry"
 	backoffUtil "github.com/leon-yc/ggs/internal/pkg/backoff"
 	"github.com/leon-yc/ggs/internal/pkg/util"
 	"github.com/leon-yc/ggs/pkg/qlog"
@@ -26,9 +27,13 @@ type LBHandler struct {
 }
 
 func (lb *LBHandler) getEndpoint(i *invocation.Invocation, lbConfig control.LoadBalancingConfig) (string, error) {
-	if i.NoDiscovery {
+	// the services with ggs.references.<service>.discovery are balanced even if they are called directly
+	if i.NoDiscovery && (i.RouteType == common.RouteSidecar || !registry.HasReferenceDiscovery(i.MicroServiceName)) {
 		// do not using discovery, so skiping consul
 		ep := i.MicroServiceName
+		if i.Endpoint != "" {
+			ep = i.Endpoint
+		}
 		if i.RouteType == common.RouteSidecar && archaius.GetBool("ggs.sidecar.enabled", false) {
 			i.Ctx = common.WithContext(i.Ctx, common.HeaderXSidecar, strings.ReplaceAll(i.MicroServiceName, "_", "-"))
 			ep = common.SidecarAddress
@@ -87,6 +92,7 @@ func (lb *LBHandler) getEndpoint(i *invocation.Invocation, lbConfig control.Load
 		qlog.Errorf(lbErr.Error())
 		return "", lbErr
 	}
+	i.SetMetadata(common.InstanceKey, ins)
 	return ep, nil
 }
 
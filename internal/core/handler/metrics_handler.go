@@ -95,49 +95,78 @@ func (m *MetricsConsumerHandler) Handle(chain *Chain, i *invocation.Invocation,
 	uri := i.URLPathFormat
 
 	chain.Next(i, func(r *invocation.Response) (err error) {
-		defer func() {
-			if i.Protocol == ProtocolRest {
-				err := metrics.HistogramObserve(metrics.ClientReqDuration, time.Since(st).Seconds(),
-					map[string]string{metrics.RemoteLable: i.MicroServiceName,
-						metrics.ReqProtocolLable: i.Protocol,
-						metrics.RespUriLable:     uri,
-						metrics.RespCodeLable:    strconv.Itoa(r.Status)})
-				if err != nil {
-					qlog.Errorf("HistogramObserve, uri:%s status:%d err:%s", uri, r.Status, err.Error())
-				}
-
-				err = metrics.CounterAdd(metrics.ClientReqQPS, 1,
-					map[string]string{metrics.RemoteLable: i.MicroServiceName,
-						metrics.ReqProtocolLable: i.Protocol,
-						metrics.RespUriLable:     uri,
-						metrics.RespCodeLable:    strconv.Itoa(r.Status)})
-				if err != nil {
-					qlog.Errorf("CounterAdd, uri:%s  status:%d err:%s", uri, r.Status, err.Error())
-				}
-
-			} else if i.Protocol == ProtocolGrpc {
-				err := metrics.HistogramObserve(metrics.ClientGrpcReqDuration, time.Since(st).Seconds(),
-					map[string]string{metrics.RemoteLable: i.MicroServiceName,
-						metrics.ReqProtocolLable: i.Protocol,
-						metrics.RespHandlerLable: i.OperationID,
-						metrics.RespCodeLable:    strconv.Itoa(r.Status)})
-				if err != nil {
-					qlog.Errorf("HistogramObserve, RespHandler:%s  status:%d err:%s",
-						i.OperationID, r.Status, err.Error())
-				}
-
-				err = metrics.CounterAdd(metrics.ClientGrpcReqQPS, 1,
-					map[string]string{metrics.RemoteLable: i.MicroServiceName,
-						metrics.ReqProtocolLable: i.Protocol,
-						metrics.RespHandlerLable: i.OperationID,
-						metrics.RespCodeLable:    strconv.Itoa(r.Status)})
-				if err != nil {
-					qlog.Errorf("HistogramObserve, RespHandler:%s  status:%d err:%s",
-						i.OperationID, r.Status, err.Error())
-				}
-			}
-		}()
+		if i.IsStream && r.Err == nil {
+			// the stream is opened, observe it when the stream ends
+			i.OnStreamDone(func(r *invocation.Response) error {
+				m.observe(i, uri, st, r)
+				m.observeStreamMsgs(i, r)
+				return nil
+			})
+			return cb(r)
+		}
+		defer m.observe(i, uri, st, r)
 
 		return cb(r)
 	})
 }
+
+func (m *MetricsConsumerHandler) observe(i *invocation.Invocation, uri string, st time.Time, r *invocation.Response) {
+	if i.Protocol == ProtocolRest {
+		err := metrics.HistogramObserve(metrics.ClientReqDuration, time.Since(st).Seconds(),
+			map[string]string{metrics.RemoteLable: i.MicroServiceName,
+				metrics.ReqProtocolLable: i.Protocol,
+				metrics.RespUriLable:     uri,
+				metrics.RespCodeLable:    strconv.Itoa(r.Status)})
+		if err != nil {
+			qlog.Errorf("HistogramObserve, uri:%s status:%d err:%s", uri, r.Status, err.Error())
+		}
+
+		err = metrics.CounterAdd(metrics.ClientReqQPS, 1,
+			map[string]string{metrics.RemoteLable: i.MicroServiceName,
+				metrics.ReqProtocolLable: i.Protocol,
+				metrics.RespUriLable:     uri,
+				metrics.RespCodeLable:    strconv.Itoa(r.Status)})
+		if err != nil {
+			qlog.Errorf("CounterAdd, uri:%s  status:%d err:%s", uri, r.Status, err.Error())
+		}
+
+	} else if i.Protocol == ProtocolGrpc {
+		err := metrics.HistogramObserve(metrics.ClientGrpcReqDuration, time.Since(st).Seconds(),
+			map[string]string{metrics.RemoteLable: i.MicroServiceName,
+				metrics.ReqProtocolLable: i.Protocol,
+				metrics.RespHandlerLable: i.OperationID,
+				metrics.RespCodeLable:    strconv.Itoa(r.Status)})
+		if err != nil {
+			qlog.Errorf("HistogramObserve, RespHandler:%s  status:%d err:%s",
+				i.OperationID, r.Status, err.Error())
+		}
+
+		err = metrics.CounterAdd(metrics.ClientGrpcReqQPS, 1,
+			map[string]string{metrics.RemoteLable: i.MicroServiceName,
+				metrics.ReqProtocolLable: i.Protocol,
+				metrics.RespHandlerLable: i.OperationID,
+				metrics.RespCodeLable:    strconv.Itoa(r.Status)})
+		if err != nil {
+			qlog.Errorf("HistogramObserve, RespHandler:%s  status:%d err:%s",
+				i.OperationID, r.Status, err.Error())
+		}
+	}
+}
+
+func (m *MetricsConsumerHandler) observeStreamMsgs(i *invocation.Invocation, r *invocation.Response) {
+	sr, ok := r.Result.(invocation.StreamResult)
+	if !ok {
+		return
+	}
+	for direction, n := range map[string]int64{"sent": sr.SentMsgs, "recv": sr.RecvMsgs} {
+		err := metrics.CounterAdd(metrics.ClientGrpcStreamMsgs, float64(n),
+			map[string]string{metrics.RemoteLable: i.MicroServiceName,
+				metrics.ReqProtocolLable: i.Protocol,
+				metrics.RespHandlerLable: i.OperationID,
+				metrics.DirectionLable:   direction})
+		if err != nil {
+			qlog.Errorf("CounterAdd, RespHandler:%s direction:%s err:%s",
+				i.OperationID, direction, err.Error())
+		}
+	}
+}
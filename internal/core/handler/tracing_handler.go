@@ -222,15 +222,31 @@ func (t *TracingConsumerHandler) GrpcHandle(chain *Chain, i *invocation.Invocati
 	// But client may send req in the callback func too, that we have to remove
 	// span finishing from callback func's inside to outside.
 	chain.Next(i, func(r *invocation.Response) (err error) {
-		if r.Err != nil && r.Err != io.EOF {
-			ext.Error.Set(span, true)
-			span.LogKV("event", "error", "message", r.Err.Error())
+		if i.IsStream && r.Err == nil {
+			// the stream is opened, span finishes when the stream ends
+			i.OnStreamDone(func(r *invocation.Response) error {
+				if sr, ok := r.Result.(invocation.StreamResult); ok {
+					span.SetTag(tracing.GrpcSentMsgs, sr.SentMsgs)
+					span.SetTag(tracing.GrpcRecvMsgs, sr.RecvMsgs)
+				}
+				finishGrpcSpan(span, r)
+				return nil
+			})
+			return cb(r)
 		}
-		span.Finish()
+		finishGrpcSpan(span, r)
 		return cb(r)
 	})
 }
 
+func finishGrpcSpan(span opentracing.Span, r *invocation.Response) {
+	if r.Err != nil && r.Err != io.EOF {
+		ext.Error.Set(span, true)
+		span.LogKV("event", "error", "message", r.Err.Error())
+	}
+	span.Finish()
+}
+
 // Name returns tracing-consumer string
 func (t *TracingConsumerHandler) Name() string {
 	return TracingConsumer
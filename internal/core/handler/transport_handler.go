@@ -11,6 +11,7 @@ import (
 	"github.com/leon-yc/ggs/internal/core/loadbalancer"
 	"github.com/leon-yc/ggs/internal/session"
 	"github.com/leon-yc/ggs/pkg/qlog"
+	"google.golang.org/grpc/status"
 )
 
 // TransportHandler transport handler
@@ -42,9 +43,25 @@ func (th *TransportHandler) Handle(chain *Chain, i *invocation.Invocation, cb in
 
 	//taking the time elapsed to check for latency aware strategy
 	timeBefore := time.Now()
+	done := loadbalancer.BeginRequest(i)
 	err = c.Call(i.Ctx, i.Endpoint, i, i.Reply)
+	if i.IsStream && err == nil {
+		// the opened stream is in-flight until it ends, the latency is the time to open it
+		latency := time.Since(timeBefore)
+		i.OnStreamDone(func(*invocation.Response) error {
+			done(latency)
+			return nil
+		})
+	} else {
+		done(time.Since(timeBefore))
+	}
 	if resp, ok := i.Reply.(*http.Response); ok {
 		r.Status = resp.StatusCode
+	} else if i.Protocol == common.ProtocolGrpc {
+		r.Status = int(status.Code(err))
+	}
+	if err != client.ErrCanceled {
+		loadbalancer.ReportResult(i, r.Status, err, time.Since(timeBefore))
 	}
 	if err != nil {
 		r.Err = err
@@ -50,6 +50,14 @@ type Invocation struct {
 	StreamDesc         interface{}
 	RouteType          string
 	// grpc stream desc pointer
+
+	streamDone []ResponseCallBack
+}
+
+// StreamResult is the Result of the response reported when a stream ends
+type StreamResult struct {
+	SentMsgs int64
+	RecvMsgs int64
 }
 
 //Reset reset clear a invocation
@@ -70,6 +78,7 @@ func (inv *Invocation) Reset() {
 	inv.Filters = nil
 	inv.Strategy = ""
 	inv.NoDiscovery = false
+	inv.streamDone = nil
 }
 
 // New create invocation, context can not be nil
@@ -108,3 +117,18 @@ func (inv *Invocation) SetHeader(k, v string) {
 func (inv *Invocation) Headers() map[string]string {
 	return inv.Ctx.Value(common.ContextHeaderKey{}).(map[string]string)
 }
+
+//OnStreamDone registers a callback which is called when the stream of the invocation ends,
+//handlers use it to finish their work after the stream is opened
+func (inv *Invocation) OnStreamDone(f ResponseCallBack) {
+	inv.streamDone = append(inv.streamDone, f)
+}
+
+//StreamDone reports the end of the stream to the registered callbacks,
+//in the same order as handlers received the response of opening the stream
+func (inv *Invocation) StreamDone(r *Response) {
+	for _, f := range inv.streamDone {
+		f(r)
+	}
+	inv.streamDone = nil
+}
@@ -0,0 +1,254 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"google.golang.org/grpc/metadata"
+)
+
+// the sources of the hash key, the key is configured as <source>:<name>
+const (
+	HashKeyHeader     = "header"
+	HashKeyQuery      = "query"
+	HashKeyGrpc       = "grpc"
+	HashKeyInvocation = "invocation"
+)
+
+// triedInstancesKey is the metadata key of the instances picked for the invocation, the retry skips them
+const triedInstancesKey = "_ConsistentHashTried"
+
+// ConsistentHashStrategy picks the instance by the hash of a request attribute on a hash ring,
+// only the keys of the changed instances are remapped when the instances change
+type ConsistentHashStrategy struct {
+	instances []*registry.MicroServiceInstance
+	service   string
+	key       string
+	tried     map[string]bool
+	hashKey   string
+	nodes     int
+}
+
+func newConsistentHashStrategy() Strategy {
+	return &ConsistentHashStrategy{}
+}
+
+// ReceiveData receive data
+func (r *ConsistentHashStrategy) ReceiveData(inv *invocation.Invocation, instances []*registry.MicroServiceInstance, serviceKey string) {
+	r.instances = instances
+	r.service = inv.MicroServiceName
+	r.key = serviceKey
+	// the load balancer picks again for the retry on the next instance with the same invocation
+	tried, ok := inv.Metadata[triedInstancesKey].(map[string]bool)
+	if !ok {
+		tried = make(map[string]bool)
+		inv.SetMetadata(triedInstancesKey, tried)
+	}
+	r.tried = tried
+	r.hashKey = HashKey(inv, config.GetHashKey(inv.SourceMicroService, inv.MicroServiceName))
+	r.nodes = config.GetVirtualNodes(inv.SourceMicroService, inv.MicroServiceName)
+}
+
+// Pick return instance, a random instance is picked if the request has no hash key,
+// the retry walks clockwise past the instances already picked for the request
+func (r *ConsistentHashStrategy) Pick() (*registry.MicroServiceInstance, error) {
+	if len(r.instances) == 0 {
+		return nil, ErrNoneAvailableInstance
+	}
+	if r.hashKey == "" {
+		return r.instances[rand.Intn(len(r.instances))], nil
+	}
+	owner := getRing(r.service, r.key, r.instances, r.nodes).pick(hash64(r.hashKey), r.tried)
+	r.tried[owner.InstanceID] = true
+	// the ring is shared by the requests, return the latest data of the instance
+	for _, ins := range r.instances {
+		if ins.InstanceID == owner.InstanceID {
+			return ins, nil
+		}
+	}
+	return owner, nil
+}
+
+// HashKey returns the value of the request attribute, the source is like header:X-User-Id, query:uid,
+// grpc:x-user-id or invocation:uid
+func HashKey(i *invocation.Invocation, source string) string {
+	kind := strings.SplitN(source, ":", 2)
+	if len(kind) != 2 || kind[1] == "" {
+		return ""
+	}
+	name := kind[1]
+	switch kind[0] {
+	case HashKeyHeader:
+		if req, ok := i.Args.(*http.Request); ok && req != nil {
+			if v := req.Header.Get(name); v != "" {
+				return v
+			}
+		}
+		return common.FromContext(i.Ctx)[name]
+	case HashKeyQuery:
+		if req, ok := i.Args.(*http.Request); ok && req != nil && req.URL != nil {
+			return req.URL.Query().Get(name)
+		}
+	case HashKeyGrpc:
+		if i.Ctx != nil {
+			if md, ok := metadata.FromOutgoingContext(i.Ctx); ok {
+				if v := md.Get(name); len(v) > 0 {
+					return v[0]
+				}
+			}
+		}
+		// the headers of the context are sent as the grpc metadata
+		h := common.FromContext(i.Ctx)
+		if v, ok := h[name]; ok {
+			return v
+		}
+		return h[strings.ToLower(name)]
+	case HashKeyInvocation:
+		if v, ok := i.Metadata[name]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// hashRing is the sorted points of the instances
+type hashRing struct {
+	signature uint64
+	nodes     int
+	points    []uint64
+	owners    []*registry.MicroServiceInstance
+}
+
+// serviceRings is the rings of the service keys of a service
+type serviceRings struct {
+	rings map[string]*hashRing
+	stop  func()
+}
+
+var (
+	ringMu sync.RWMutex
+	// service name to the rings of the service
+	rings = make(map[string]*serviceRings)
+)
+
+// getRing returns the ring of the service key, it is rebuilt when the instances change,
+// the rings of a service are dropped when an instance is removed from the registry,
+// and the service is dropped when it has no instance
+func getRing(service, key string, instances []*registry.MicroServiceInstance, nodes int) *hashRing {
+	sig := signature(instances)
+	ringMu.RLock()
+	var r *hashRing
+	sr, ok := rings[service]
+	if ok {
+		r, ok = sr.rings[key]
+	}
+	ringMu.RUnlock()
+	if ok && r.signature == sig && r.nodes == nodes {
+		return r
+	}
+
+	r = newHashRing(instances, nodes)
+	r.signature = sig
+	ringMu.Lock()
+	defer ringMu.Unlock()
+	sr, ok = rings[service]
+	if !ok {
+		sr = &serviceRings{rings: make(map[string]*hashRing)}
+		rings[service] = sr
+		sr.stop = registry.Watch(service, func(e registry.Event) {
+			if e.Type != registry.EventRemoved {
+				return
+			}
+			ringMu.Lock()
+			defer ringMu.Unlock()
+			if rings[service] != sr {
+				return
+			}
+			if e.Count == 0 {
+				delete(rings, service)
+				sr.stop()
+				return
+			}
+			sr.rings = make(map[string]*hashRing)
+		})
+	}
+	sr.rings[key] = r
+	return r
+}
+
+func newHashRing(instances []*registry.MicroServiceInstance, nodes int) *hashRing {
+	r := &hashRing{
+		nodes:  nodes,
+		points: make([]uint64, 0, len(instances)*nodes),
+		owners: make([]*registry.MicroServiceInstance, 0, len(instances)*nodes),
+	}
+	type point struct {
+		hash  uint64
+		owner *registry.MicroServiceInstance
+	}
+	points := make([]point, 0, len(instances)*nodes)
+	for _, ins := range instances {
+		for n := 0; n < nodes; n++ {
+			points = append(points, point{hash: hash64(ins.InstanceID + "#" + strconv.Itoa(n)), owner: ins})
+		}
+	}
+	sort.Slice(points, func(a, b int) bool {
+		if points[a].hash == points[b].hash {
+			return points[a].owner.InstanceID < points[b].owner.InstanceID
+		}
+		return points[a].hash < points[b].hash
+	})
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// pick returns the owner of the first point clockwise from the hash which is not skipped,
+// it returns the owner of the first point if all owners are skipped
+func (r *hashRing) pick(h uint64, skip map[string]bool) *registry.MicroServiceInstance {
+	n := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if n == len(r.points) {
+		n = 0
+	}
+	for i := 0; i < len(r.points); i++ {
+		owner := r.owners[(n+i)%len(r.points)]
+		if !skip[owner.InstanceID] {
+			return owner
+		}
+	}
+	return r.owners[n]
+}
+
+// signature identifies the instances regardless of their order
+func signature(instances []*registry.MicroServiceInstance) uint64 {
+	var sig uint64
+	for _, ins := range instances {
+		sig += hash64(ins.InstanceID)
+	}
+	return sig ^ uint64(len(instances))
+}
+
+// hash64 is fnv-1a with a finalizer, the finalizer spreads the similar keys on the ring
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,130 @@
+package loadbalancer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+func TestHashRingRemapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  []string
+		after   []string
+		changed string
+		// the bounds of the share of the remapped keys
+		min, max float64
+	}{
+		{"add", []string{"a", "b", "c", "d", "e"}, []string{"a", "b", "c", "d", "e", "f"}, "f", 0.08, 0.3},
+		{"remove", []string{"a", "b", "c", "d", "e"}, []string{"a", "b", "c", "d"}, "e", 0.1, 0.35},
+	}
+	const keys = 5000
+	for _, tt := range tests {
+		before := newHashRing(newInstances(tt.before...), 100)
+		after := newHashRing(newInstances(tt.after...), 100)
+		moved := 0
+		for k := 0; k < keys; k++ {
+			h := hash64("user-" + strconv.Itoa(k))
+			x, y := before.pick(h, nil).InstanceID, after.pick(h, nil).InstanceID
+			if x == y {
+				continue
+			}
+			moved++
+			// only the keys of the changed instance move
+			if x != tt.changed && y != tt.changed {
+				t.Fatalf("%s: key %d moves from %s to %s", tt.name, k, x, y)
+			}
+		}
+		if share := float64(moved) / keys; share < tt.min || share > tt.max {
+			t.Errorf("%s: %.2f of the keys are remapped, want [%.2f, %.2f]", tt.name, share, tt.min, tt.max)
+		}
+	}
+}
+
+func TestHashRingSkipsTried(t *testing.T) {
+	r := newHashRing(newInstances("a", "b", "c"), 10)
+	for k := 0; k < 100; k++ {
+		h := hash64("key-" + strconv.Itoa(k))
+		first := r.pick(h, nil).InstanceID
+		tried := map[string]bool{first: true}
+		second := r.pick(h, tried).InstanceID
+		tried[second] = true
+		third := r.pick(h, tried).InstanceID
+		tried[third] = true
+		if second == first || third == first || third == second {
+			t.Fatalf("key %d: the retries pick %s, %s, %s", k, first, second, third)
+		}
+		// the first owner is picked again when all instances are tried
+		if got := r.pick(h, tried).InstanceID; got != first {
+			t.Fatalf("key %d: want %s when all are tried, got %s", k, first, got)
+		}
+	}
+}
+
+func TestConsistentHashPick(t *testing.T) {
+	const service = "hashed"
+	archaius.Set("ggs.loadbalance."+service+".strategy.hashKey", "invocation:uid")
+	instances := newInstances("a", "b", "c", "d")
+
+	pick := func(inv *invocation.Invocation) string {
+		s := newConsistentHashStrategy()
+		s.ReceiveData(inv, instances, service)
+		ins, err := s.Pick()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ins.InstanceID
+	}
+	for k := 0; k < 20; k++ {
+		inv := &invocation.Invocation{MicroServiceName: service}
+		inv.SetMetadata("uid", k)
+		first := pick(inv)
+		again := &invocation.Invocation{MicroServiceName: service}
+		again.SetMetadata("uid", k)
+		if got := pick(again); got != first {
+			t.Fatalf("uid %d: the same key picks %s and %s", k, first, got)
+		}
+		// the retry with the same invocation goes to another instance
+		if got := pick(inv); got == first {
+			t.Fatalf("uid %d: the retry picks %s again", k, got)
+		}
+	}
+}
+
+func TestRingsEvictedWithRemovedInstances(t *testing.T) {
+	const service = "ring-evicted"
+	instances := newInstances("a", "b")
+	registry.SetInstances(service, instances)
+	defer registry.DeleteInstances(service)
+	getRing(service, service, instances, 10)
+
+	ringCount := func() int {
+		ringMu.RLock()
+		defer ringMu.RUnlock()
+		sr, ok := rings[service]
+		if !ok {
+			return -1
+		}
+		return len(sr.rings)
+	}
+	waitRings := func(want int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for ringCount() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("want %d rings, got %d", want, ringCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// a removed instance drops the rings, the service is dropped with the last instance
+	registry.SetInstances(service, instances[:1])
+	waitRings(0)
+	getRing(service, service, instances[:1], 10)
+	registry.SetInstances(service, nil)
+	waitRings(-1)
+}
@@ -0,0 +1,71 @@
+package loadbalancer
+
+import (
+	"strings"
+)
+
+// FilterMetadata is the name of the filter which selects the instances by the criteria on their metadata
+const FilterMetadata = "metadata"
+
+// the operators of the criteria, the first operator in the criteria is used, and the longer one wins at the same position
+var operators = []struct {
+	token    string
+	operator string
+}{
+	{">=", OperatorGreaterOrEqual},
+	{"<=", OperatorSmallerOrEqual},
+	{"=", OperatorEqual},
+	{">", OperatorGreater},
+	{"<", OperatorSmaller},
+	{"~", OperatorPattern},
+}
+
+// ParseCriteria parses the criteria like env=gray, cluster>=2, version~1\..* or version Pattern 1\..*,
+// ok is false if s is the name of a filter
+func ParseCriteria(s string) (c *Criteria, ok bool) {
+	s = strings.TrimSpace(s)
+	if kv := strings.SplitN(s, " "+OperatorPattern+" ", 2); len(kv) == 2 {
+		return newCriteria(kv[0], OperatorPattern, kv[1])
+	}
+	n := strings.IndexAny(s, "=<>~")
+	if n < 0 {
+		return nil, false
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(s[n:], op.token) {
+			return newCriteria(s[:n], op.operator, s[n+len(op.token):])
+		}
+	}
+	return nil, false
+}
+
+func newCriteria(key, operator, value string) (*Criteria, bool) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, false
+	}
+	return &Criteria{Key: key, Operator: operator, Value: strings.TrimSpace(value)}, true
+}
+
+// ParseFilters splits the server list filters into the names of the filters and the criteria,
+// the metadata filter goes first if there are criteria, so the locality filters choose among the matched instances
+func ParseFilters(filters []string) (names []string, criteria []*Criteria) {
+	for _, f := range filters {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if c, ok := ParseCriteria(f); ok {
+			criteria = append(criteria, c)
+			continue
+		}
+		if f == FilterMetadata {
+			continue
+		}
+		names = append(names, f)
+	}
+	if len(criteria) > 0 {
+		names = append([]string{FilterMetadata}, names...)
+	}
+	return names, criteria
+}
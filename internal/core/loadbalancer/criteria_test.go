@@ -0,0 +1,58 @@
+package loadbalancer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCriteria(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Criteria
+	}{
+		{"env=gray", &Criteria{"env", OperatorEqual, "gray"}},
+		{" env = gray ", &Criteria{"env", OperatorEqual, "gray"}},
+		{"cluster>=2", &Criteria{"cluster", OperatorGreaterOrEqual, "2"}},
+		{"cluster<=2", &Criteria{"cluster", OperatorSmallerOrEqual, "2"}},
+		{"cluster>2", &Criteria{"cluster", OperatorGreater, "2"}},
+		{"cluster<2", &Criteria{"cluster", OperatorSmaller, "2"}},
+		// the first operator is used, the rest is the value
+		{"expr=a>=b", &Criteria{"expr", OperatorEqual, "a>=b"}},
+		{`version~1\..*`, &Criteria{"version", OperatorPattern, `1\..*`}},
+		{`version Pattern 1\..*`, &Criteria{"version", OperatorPattern, `1\..*`}},
+		{`version Pattern a=b`, &Criteria{"version", OperatorPattern, `a=b`}},
+		{"=gray", nil},
+		{"zoneaware", nil},
+		{"Pattern", nil},
+	}
+	for _, tt := range tests {
+		got, ok := ParseCriteria(tt.in)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCriteria(%q) = %+v, %v, want %+v", tt.in, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		filters  []string
+		names    []string
+		criteria []*Criteria
+	}{
+		{"filters only", []string{"zoneaware", " ", "custom"}, []string{"zoneaware", "custom"}, nil},
+		{"metadata goes first", []string{"zoneaware", "env=gray", "version Pattern 1\\..*"},
+			[]string{FilterMetadata, "zoneaware"},
+			[]*Criteria{{"env", OperatorEqual, "gray"}, {"version", OperatorPattern, "1\\..*"}}},
+		{"explicit metadata is moved", []string{"zoneaware", FilterMetadata, "cluster>=2"},
+			[]string{FilterMetadata, "zoneaware"},
+			[]*Criteria{{"cluster", OperatorGreaterOrEqual, "2"}}},
+		{"metadata without criteria", []string{FilterMetadata}, nil, nil},
+	}
+	for _, tt := range tests {
+		names, criteria := ParseFilters(tt.filters)
+		if !reflect.DeepEqual(names, tt.names) || !reflect.DeepEqual(criteria, tt.criteria) {
+			t.Errorf("%s: got %v, %+v, want %v, %+v", tt.name, names, criteria, tt.names, tt.criteria)
+		}
+	}
+}
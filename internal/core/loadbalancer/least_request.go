@@ -0,0 +1,90 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+// LeastRequestStrategy picks the instance with the least in-flight requests, the lower latency wins the tie
+type LeastRequestStrategy struct {
+	instances []*registry.MicroServiceInstance
+	service   string
+	mtx       sync.Mutex
+}
+
+func newLeastRequestStrategy() Strategy {
+	return &LeastRequestStrategy{}
+}
+
+// ReceiveData receive data
+func (r *LeastRequestStrategy) ReceiveData(inv *invocation.Invocation, instances []*registry.MicroServiceInstance, serviceKey string) {
+	r.instances = instances
+	r.service = inv.MicroServiceName
+}
+
+// Pick return instance
+func (r *LeastRequestStrategy) Pick() (*registry.MicroServiceInstance, error) {
+	if len(r.instances) == 0 {
+		return nil, ErrNoneAvailableInstance
+	}
+
+	// start from a random instance, so the instances without requests are picked evenly
+	r.mtx.Lock()
+	start := rand.Intn(len(r.instances))
+	r.mtx.Unlock()
+	var best *registry.MicroServiceInstance
+	var bestInflight int64
+	var bestLatency float64
+	for n := 0; n < len(r.instances); n++ {
+		ins := r.instances[(start+n)%len(r.instances)]
+		inflight, latency := getRequestStats(r.service, ins.InstanceID).load()
+		if best == nil || inflight < bestInflight || (inflight == bestInflight && latency < bestLatency) {
+			best, bestInflight, bestLatency = ins, inflight, latency
+		}
+	}
+	return best, nil
+}
+
+// P2CStrategy picks two instances randomly, and uses the one with the lower cost,
+// the cost is the in-flight requests multiplied by the average latency
+type P2CStrategy struct {
+	instances []*registry.MicroServiceInstance
+	service   string
+	mtx       sync.Mutex
+}
+
+func newP2CStrategy() Strategy {
+	return &P2CStrategy{}
+}
+
+// ReceiveData receive data
+func (r *P2CStrategy) ReceiveData(inv *invocation.Invocation, instances []*registry.MicroServiceInstance, serviceKey string) {
+	r.instances = instances
+	r.service = inv.MicroServiceName
+}
+
+// Pick return instance
+func (r *P2CStrategy) Pick() (*registry.MicroServiceInstance, error) {
+	switch len(r.instances) {
+	case 0:
+		return nil, ErrNoneAvailableInstance
+	case 1:
+		return r.instances[0], nil
+	}
+
+	r.mtx.Lock()
+	a := rand.Intn(len(r.instances))
+	b := rand.Intn(len(r.instances) - 1)
+	r.mtx.Unlock()
+	if b >= a {
+		b++
+	}
+	x, y := r.instances[a], r.instances[b]
+	if getRequestStats(r.service, y.InstanceID).cost() < getRequestStats(r.service, x.InstanceID).cost() {
+		return y, nil
+	}
+	return x, nil
+}
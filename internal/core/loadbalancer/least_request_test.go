@@ -0,0 +1,132 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+func newInstances(ids ...string) []*registry.MicroServiceInstance {
+	instances := make([]*registry.MicroServiceInstance, 0, len(ids))
+	for _, id := range ids {
+		instances = append(instances, &registry.MicroServiceInstance{InstanceID: id})
+	}
+	return instances
+}
+
+// setStats sets the in-flight requests and the average latency of the instances of the service
+func setStats(service string, inflight map[string]int64, latency map[string]time.Duration) {
+	for id, n := range inflight {
+		atomic.StoreInt64(&getRequestStats(service, id).inflight, n)
+	}
+	for id, l := range latency {
+		s := getRequestStats(service, id)
+		s.mu.Lock()
+		s.latency, s.updated = float64(l), time.Now()
+		s.mu.Unlock()
+	}
+}
+
+func TestLeastRequestPick(t *testing.T) {
+	tests := []struct {
+		name     string
+		inflight map[string]int64
+		latency  map[string]time.Duration
+		want     string
+	}{
+		{"least in-flight", map[string]int64{"a": 3, "b": 1, "c": 2}, nil, "b"},
+		{"lower latency wins the tie", map[string]int64{"a": 1, "b": 1, "c": 2},
+			map[string]time.Duration{"a": 20 * time.Millisecond, "b": 10 * time.Millisecond, "c": time.Millisecond}, "b"},
+		{"in-flight before latency", map[string]int64{"a": 0, "b": 1, "c": 1},
+			map[string]time.Duration{"a": time.Second, "b": time.Millisecond, "c": time.Millisecond}, "a"},
+	}
+	for i, tt := range tests {
+		service := "least-request-" + string(rune('a'+i))
+		setStats(service, tt.inflight, tt.latency)
+		s := newLeastRequestStrategy()
+		s.ReceiveData(&invocation.Invocation{MicroServiceName: service}, newInstances("a", "b", "c"), service)
+		// the start is random, the pick must not depend on it
+		for n := 0; n < 20; n++ {
+			ins, err := s.Pick()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ins.InstanceID != tt.want {
+				t.Errorf("%s: want %s, got %s", tt.name, tt.want, ins.InstanceID)
+				break
+			}
+		}
+	}
+}
+
+func TestP2CPick(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []string
+		inflight  map[string]int64
+		latency   map[string]time.Duration
+		never     string
+	}{
+		{"lower in-flight of two", []string{"a", "b"}, map[string]int64{"a": 5, "b": 1}, nil, "a"},
+		{"the busiest is never picked", []string{"a", "b", "c"}, map[string]int64{"a": 10, "b": 1, "c": 2}, nil, "a"},
+		{"cost is in-flight by latency", []string{"a", "b"}, map[string]int64{"a": 2, "b": 1},
+			map[string]time.Duration{"a": time.Millisecond, "b": 10 * time.Millisecond}, "b"},
+	}
+	for i, tt := range tests {
+		service := "p2c-" + string(rune('a'+i))
+		setStats(service, tt.inflight, tt.latency)
+		s := newP2CStrategy()
+		s.ReceiveData(&invocation.Invocation{MicroServiceName: service}, newInstances(tt.instances...), service)
+		picked := make(map[string]int)
+		for n := 0; n < 200; n++ {
+			ins, err := s.Pick()
+			if err != nil {
+				t.Fatal(err)
+			}
+			picked[ins.InstanceID]++
+		}
+		if picked[tt.never] > 0 {
+			t.Errorf("%s: %s is picked %d times", tt.name, tt.never, picked[tt.never])
+		}
+	}
+
+	s := newP2CStrategy()
+	s.ReceiveData(&invocation.Invocation{MicroServiceName: "p2c-empty"}, nil, "p2c-empty")
+	if _, err := s.Pick(); err != ErrNoneAvailableInstance {
+		t.Errorf("want ErrNoneAvailableInstance, got %v", err)
+	}
+}
+
+func TestBeginRequestCountsInflight(t *testing.T) {
+	const service = "inflight"
+	ins := &registry.MicroServiceInstance{InstanceID: "a"}
+	tests := []struct {
+		strategy string
+		counted  bool
+	}{
+		{StrategyLeastRequest, true},
+		{StrategyP2C, true},
+		{StrategyRoundRobin, false},
+	}
+	for _, tt := range tests {
+		inv := &invocation.Invocation{MicroServiceName: service, Strategy: tt.strategy}
+		inv.SetMetadata(common.InstanceKey, ins)
+		end := BeginRequest(inv)
+		inflight, _ := getRequestStats(service, "a").load()
+		if counted := inflight == 1; counted != tt.counted {
+			t.Errorf("%s: in-flight is %d, counted want %v", tt.strategy, inflight, tt.counted)
+		}
+		end(10 * time.Millisecond)
+		if inflight, _ := getRequestStats(service, "a").load(); inflight != 0 {
+			t.Errorf("%s: in-flight is %d after the request ends", tt.strategy, inflight)
+		}
+	}
+	if _, latency := getRequestStats(service, "a").load(); math.Abs(latency-float64(10*time.Millisecond)) > 1 {
+		t.Errorf("want the latency of the counted requests 10ms, got %s", time.Duration(latency))
+	}
+}
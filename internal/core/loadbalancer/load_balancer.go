@@ -9,6 +9,7 @@ import (
 
 	"github.com/leon-yc/ggs/internal/core/invocation"
 	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/healthz/checker"
 	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
 	"github.com/leon-yc/ggs/pkg/qlog"
 )
@@ -26,11 +27,16 @@ const (
 	StrategyRoundRobin        = "RoundRobin"
 	StrategyRandom            = "Random"
 	StrategySessionStickiness = "SessionStickiness"
-
-	OperatorEqual   = "="
-	OperatorGreater = ">"
-	OperatorSmaller = "<"
-	OperatorPattern = "Pattern"
+	StrategyLeastRequest      = "LeastRequest"
+	StrategyP2C               = "P2C"
+	StrategyConsistentHash    = "ConsistentHash"
+
+	OperatorEqual          = "="
+	OperatorGreater        = ">"
+	OperatorSmaller        = "<"
+	OperatorGreaterOrEqual = ">="
+	OperatorSmallerOrEqual = "<="
+	OperatorPattern        = "Pattern"
 )
 
 var (
@@ -56,33 +62,29 @@ func BuildStrategy(i *invocation.Invocation,
 		s = &RoundRobinStrategy{}
 	}
 
-	var isFilterExist = true
-	for _, filter := range i.Filters {
-		if filter == "" {
-			isFilterExist = false
-		}
+	names, criteria := ParseFilters(i.Filters)
 
+	sd, err := registry.GetServiceDiscovery(i.MicroServiceName)
+	if err != nil {
+		lbErr := LBError{err.Error()}
+		qlog.Errorf("Lb err: %s", err)
+		return nil, lbErr
 	}
-
-	instances, err := registry.DefaultServiceDiscoveryService.FindMicroServiceInstances(i.SourceServiceID, i.MicroServiceName, i.RouteTags)
+	instances, err := sd.FindMicroServiceInstances(i.SourceServiceID, i.MicroServiceName, i.RouteTags)
 	if err != nil {
 		lbErr := LBError{err.Error()}
 		qlog.Errorf("Lb err: %s", err)
 		return nil, lbErr
 	}
-
-	if isFilterExist {
-		filterFuncs := make([]Filter, 0)
-		//append filters in config
-		for _, fName := range i.Filters {
-			f := Filters[fName]
-			if f != nil {
-				filterFuncs = append(filterFuncs, f)
-				continue
-			}
-		}
-		for _, filter := range filterFuncs {
-			instances = filter(instances, nil)
+	checker.Watch(i.MicroServiceName)
+	instances = registry.FilterEjected(i.MicroServiceName, instances)
+
+	//apply filters in config, the criteria are given to every filter
+	for _, fName := range names {
+		if f := Filters[fName]; f != nil {
+			instances = f(instances, criteria)
+		} else if f := ServiceFilters[fName]; f != nil {
+			instances = f(i.MicroServiceName, instances, criteria)
 		}
 	}
 
@@ -113,12 +115,18 @@ type Criteria struct {
 // Filter receive instances and criteria, it will filter instances based on criteria you defined,criteria is optional, you can give nil for it
 type Filter func(instances []*registry.MicroServiceInstance, criteria []*Criteria) []*registry.MicroServiceInstance
 
+//ServiceFilter is a Filter which also receives the name of the called service, like the filter with per service config
+type ServiceFilter func(service string, instances []*registry.MicroServiceInstance, criteria []*Criteria) []*registry.MicroServiceInstance
+
 // Enable function is for to enable load balance strategy
 func Enable(strategyName string) error {
 	qlog.Trace("Enable LoadBalancing")
 	InstallStrategy(StrategyRandom, newRandomStrategy)
 	InstallStrategy(StrategyRoundRobin, newRoundRobinStrategy)
 	InstallStrategy(StrategySessionStickiness, newSessionStickinessStrategy)
+	InstallStrategy(StrategyLeastRequest, newLeastRequestStrategy)
+	InstallStrategy(StrategyP2C, newP2CStrategy)
+	InstallStrategy(StrategyConsistentHash, newConsistentHashStrategy)
 
 	if strategyName == "" {
 		qlog.Info("Empty strategy configuration, use RoundRobin as default")
@@ -137,6 +145,14 @@ func InstallFilter(name string, f Filter) {
 	Filters[name] = f
 }
 
+//ServiceFilters is a map of string and ServiceFilter
+var ServiceFilters = make(map[string]ServiceFilter)
+
+//InstallServiceFilter install service filter
+func InstallServiceFilter(name string, f ServiceFilter) {
+	ServiceFilters[name] = f
+}
+
 // variables for latency map, rest and highway requests count
 var (
 	//ProtocolStatsMap saves all stats for all service's protocol, one protocol has a lot of instances
@@ -0,0 +1,253 @@
+package loadbalancer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/pkg/metrics"
+	"github.com/leon-yc/ggs/pkg/qlog"
+	"google.golang.org/grpc/codes"
+)
+
+// the types of the outlier events
+const (
+	OutlierEject   = "eject"
+	OutlierReadmit = "readmit"
+)
+
+// the reasons of the ejections
+const (
+	ReasonConsecutiveErrors = "consecutive_errors"
+	ReasonLatency           = "latency"
+)
+
+// latencyAlpha is the weight of the latest latency in the average
+const latencyAlpha = 0.3
+
+// OutlierEvent is reported when an instance is ejected or readmitted by the outlier detection
+type OutlierEvent struct {
+	Type     string
+	Service  string
+	Instance *registry.MicroServiceInstance
+	//Reason is the reason of the ejection
+	Reason string
+	//Duration is the ejection time
+	Duration time.Duration
+}
+
+// OutlierListener receives the outlier events, it must not block
+type OutlierListener func(e *OutlierEvent)
+
+// outlierStats is the recent results of an instance
+type outlierStats struct {
+	instance   *registry.MicroServiceInstance
+	errors     int
+	samples    int
+	latency    float64
+	ejected    bool
+	ejections  int
+	readmitted time.Time
+}
+
+var (
+	outlierMu        sync.Mutex
+	outliers         = make(map[string]map[string]*outlierStats)
+	outlierListeners []OutlierListener
+)
+
+// AddOutlierListener adds a listener of the outlier events, it is not thread safe, add the listeners in init
+func AddOutlierListener(l OutlierListener) {
+	outlierListeners = append(outlierListeners, l)
+}
+
+// ReportResult records the result of the call to the instance picked by the load balancer,
+// the instance is ejected if it returns errors successively or its average latency is too high
+func ReportResult(i *invocation.Invocation, status int, err error, latency time.Duration) {
+	if !config.OutlierDetectionEnabled(i.MicroServiceName) {
+		return
+	}
+	ins, ok := i.Metadata[common.InstanceKey].(*registry.MicroServiceInstance)
+	if !ok || ins == nil {
+		return
+	}
+	failed := isOutlierError(i.Protocol, status, err)
+	if err != nil && !failed {
+		// canceled by the caller or a client error, it says nothing about the instance
+		return
+	}
+
+	service := i.MicroServiceName
+	outlierMu.Lock()
+	if outliers[service] == nil {
+		outliers[service] = make(map[string]*outlierStats)
+	}
+	st, ok := outliers[service][ins.InstanceID]
+	if !ok {
+		st = &outlierStats{}
+		outliers[service][ins.InstanceID] = st
+	}
+	st.instance = ins
+	// the calls to an ejected instance are picked before the ejection or by the panic mode
+	if st.ejected {
+		outlierMu.Unlock()
+		return
+	}
+	if st.ejections > 0 && time.Since(st.readmitted) > config.MaxEjectionTime(service) {
+		st.ejections = 0
+	}
+	if failed {
+		st.errors++
+	} else {
+		st.errors = 0
+	}
+	if st.samples == 0 {
+		st.latency = float64(latency)
+	} else {
+		st.latency = latencyAlpha*float64(latency) + (1-latencyAlpha)*st.latency
+	}
+	st.samples++
+
+	reason := ""
+	threshold := config.ConsecutiveErrors(service)
+	if st.errors >= threshold {
+		reason = ReasonConsecutiveErrors
+	} else if lt := config.LatencyThreshold(service); lt > 0 && st.samples >= threshold && st.latency > float64(lt) {
+		reason = ReasonLatency
+	}
+	outlierMu.Unlock()
+	if reason == "" {
+		return
+	}
+
+	// canEject may query the registry, so it runs without the lock, and the other calls may eject the instance meanwhile
+	allowed := canEject(service)
+	outlierMu.Lock()
+	if !allowed || st.ejected || outliers[service][ins.InstanceID] != st {
+		outlierMu.Unlock()
+		return
+	}
+	d := ejectionTime(service, st.ejections)
+	st.ejected = true
+	st.ejections++
+	outlierMu.Unlock()
+
+	// the instance ejected by the health check already is not picked anyway, only the readmission is scheduled
+	if registry.EjectInstance(service, ins, registry.EjectByOutlierDetection) {
+		qlog.Warnf("instance %s of service %s is an outlier (%s), eject it for %s", ins.InstanceID, service, reason, d)
+		reportOutlier(&OutlierEvent{Type: OutlierEject, Service: service, Instance: ins, Reason: reason, Duration: d})
+	}
+	time.AfterFunc(d, func() {
+		readmitOutlier(service, ins.InstanceID)
+	})
+}
+
+// isOutlierError returns true for the 5xx and the transport errors
+func isOutlierError(protocol string, status int, err error) bool {
+	if protocol == common.ProtocolGrpc {
+		switch codes.Code(status) {
+		case codes.Unavailable, codes.Unknown, codes.Internal, codes.DeadlineExceeded, codes.DataLoss:
+			return true
+		}
+		return false
+	}
+	if status >= http.StatusInternalServerError {
+		return true
+	}
+	return err != nil && status == 0
+}
+
+// canEject returns true if the ejected instances are less than the max percent of all instances, the instances
+// ejected by the health check are counted too, one instance can always be ejected
+func canEject(service string) bool {
+	ejected := registry.EjectedCount(service)
+	if ejected == 0 {
+		return true
+	}
+	instances, err := registry.FindAllInstances(service)
+	if err != nil {
+		return false
+	}
+	return (ejected+1)*100 <= config.MaxEjectionPercent(service)*len(instances)
+}
+
+// ejectionTime doubles for each ejection until the max ejection time
+func ejectionTime(service string, ejections int) time.Duration {
+	d := config.BaseEjectionTime(service)
+	max := config.MaxEjectionTime(service)
+	for n := 0; n < ejections && d < max; n++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func readmitOutlier(service, instanceID string) {
+	outlierMu.Lock()
+	st, ok := outliers[service][instanceID]
+	if !ok || !st.ejected {
+		outlierMu.Unlock()
+		return
+	}
+	st.ejected = false
+	st.errors, st.samples, st.latency = 0, 0, 0
+	st.readmitted = time.Now()
+	ins := st.instance
+	outlierMu.Unlock()
+
+	// the instance removed from the registry during the ejection is not added back
+	if !registered(service, instanceID) {
+		registry.ForgetEjectedInstance(service, instanceID, registry.EjectByOutlierDetection)
+		outlierMu.Lock()
+		delete(outliers[service], instanceID)
+		outlierMu.Unlock()
+		return
+	}
+	// the instance is still ejected if the health check ejects it too
+	if !registry.ReadmitInstance(service, ins, registry.EjectByOutlierDetection) {
+		return
+	}
+	qlog.Infof("instance %s of service %s is readmitted after the outlier ejection", instanceID, service)
+	reportOutlier(&OutlierEvent{Type: OutlierReadmit, Service: service, Instance: ins})
+}
+
+// registered returns true if the instance is still in the registry, the ejected instances are included
+func registered(service, instanceID string) bool {
+	instances, err := registry.FindAllInstances(service)
+	if err != nil {
+		// keep the instance when the registry is unreachable
+		return true
+	}
+	for _, ins := range instances {
+		if ins.InstanceID == instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+func reportOutlier(e *OutlierEvent) {
+	metrics.CounterAdd(metrics.OutlierEjections, 1, map[string]string{
+		metrics.OutlierService: e.Service,
+		metrics.OutlierAction:  e.Type,
+		metrics.OutlierReason:  e.Reason,
+	})
+	outlierMu.Lock()
+	n := 0
+	for _, st := range outliers[e.Service] {
+		if st.ejected {
+			n++
+		}
+	}
+	outlierMu.Unlock()
+	metrics.GaugeSet(metrics.OutlierEjected, float64(n), map[string]string{metrics.OutlierService: e.Service})
+	for _, l := range outlierListeners {
+		l(e)
+	}
+}
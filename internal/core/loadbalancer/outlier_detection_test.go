@@ -0,0 +1,274 @@
+package loadbalancer
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/ggstest/mockregistry"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/pkg/metrics"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(); err != nil {
+		panic(err)
+	}
+	registry.DefaultServiceDiscoveryService = mockregistry.Default()
+	AddOutlierListener(outlierEvents.add)
+	os.Exit(m.Run())
+}
+
+// eventRecorder keeps the outlier events of the tests
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []OutlierEvent
+}
+
+var outlierEvents = &eventRecorder{}
+
+func (r *eventRecorder) add(e *OutlierEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, *e)
+	r.mu.Unlock()
+}
+
+// of returns the events of the service
+func (r *eventRecorder) of(service string) []OutlierEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []OutlierEvent
+	for _, e := range r.events {
+		if e.Service == service {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// reset removes the events of the service
+func (r *eventRecorder) reset(service string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events[:0]
+	for _, e := range r.events {
+		if e.Service != service {
+			events = append(events, e)
+		}
+	}
+	r.events = events
+}
+
+// setupOutliers enables the outlier detection of the service and adds the instances to the mock registry
+func setupOutliers(t *testing.T, service string, props map[string]int, ids ...string) []*registry.MicroServiceInstance {
+	archaius.Set("ggs.outlierDetection."+service+".enabled", true)
+	for k, v := range props {
+		archaius.Set("ggs.outlierDetection."+service+"."+k, v)
+	}
+	instances := make([]*registry.MicroServiceInstance, 0, len(ids))
+	for i, id := range ids {
+		ins := &registry.MicroServiceInstance{
+			InstanceID:   id,
+			EndpointsMap: map[string]string{common.ProtocolRest: "127.0.0.1:" + strconv.Itoa(8000+i)},
+		}
+		mockregistry.AddInstance(service, ins)
+		instances = append(instances, ins)
+	}
+	outlierEvents.reset(service)
+	t.Cleanup(func() {
+		archaius.Set("ggs.outlierDetection."+service+".enabled", false)
+		for _, ins := range instances {
+			mockregistry.RemoveInstance(service, ins.InstanceID)
+			registry.ForgetEjectedInstance(service, ins.InstanceID, registry.EjectByOutlierDetection)
+			registry.ForgetEjectedInstance(service, ins.InstanceID, registry.EjectByHealthCheck)
+		}
+		outlierMu.Lock()
+		delete(outliers, service)
+		outlierMu.Unlock()
+	})
+	return instances
+}
+
+func report(service string, ins *registry.MicroServiceInstance, status int, latency time.Duration) {
+	inv := &invocation.Invocation{MicroServiceName: service, Protocol: common.ProtocolRest}
+	inv.SetMetadata(common.InstanceKey, ins)
+	var err error
+	if status >= http.StatusInternalServerError {
+		err = errors.New(http.StatusText(status))
+	}
+	ReportResult(inv, status, err, latency)
+}
+
+func TestConsecutiveErrorsEject(t *testing.T) {
+	const service = "od-errors"
+	instances := setupOutliers(t, service, map[string]int{"consecutiveErrors": 3, "baseEjectionTimeMs": 60000}, "a", "b")
+	a := instances[0]
+
+	statuses := []int{500, 502, 200, 503, 500}
+	for _, status := range statuses {
+		report(service, a, status, time.Millisecond)
+	}
+	if registry.IsEjected(service, "a") {
+		t.Fatal("a is ejected before the errors are consecutive")
+	}
+	report(service, a, 500, time.Millisecond)
+	if !registry.IsEjected(service, "a") {
+		t.Fatal("a is not ejected after 3 consecutive errors")
+	}
+	// the client errors are not counted
+	report(service, instances[1], 404, time.Millisecond)
+	if registry.IsEjected(service, "b") {
+		t.Error("b is ejected by a client error")
+	}
+
+	events := outlierEvents.of(service)
+	if len(events) != 1 || events[0].Type != OutlierEject || events[0].Reason != ReasonConsecutiveErrors ||
+		events[0].Duration != time.Minute || events[0].Instance.InstanceID != "a" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestLatencyEject(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		average   time.Duration
+		ejected   bool
+	}{
+		{"fast", []time.Duration{50, 50, 50}, 50, false},
+		{"too few samples", []time.Duration{400, 400}, 400, false},
+		{"slow", []time.Duration{50, 50, 400}, 155, true},
+		{"spike in the average", []time.Duration{50, 50, 50, 200}, 95, false},
+	}
+	for i, tt := range tests {
+		service := "od-latency-" + strconv.Itoa(i)
+		instances := setupOutliers(t, service, map[string]int{"consecutiveErrors": 3, "latencyThresholdMs": 100, "baseEjectionTimeMs": 60000}, "a", "b")
+		for _, l := range tt.latencies {
+			report(service, instances[0], http.StatusOK, l*time.Millisecond)
+		}
+		outlierMu.Lock()
+		average := time.Duration(outliers[service]["a"].latency)
+		outlierMu.Unlock()
+		if tt.ejected {
+			if events := outlierEvents.of(service); len(events) != 1 || events[0].Reason != ReasonLatency {
+				t.Errorf("%s: want a latency ejection, got %+v", tt.name, events)
+			}
+		} else if average != tt.average*time.Millisecond {
+			// the average is reset by the ejection, so it is checked when not ejected
+			t.Errorf("%s: want average %dms, got %s", tt.name, tt.average, average)
+		}
+		if got := registry.IsEjected(service, "a"); got != tt.ejected {
+			t.Errorf("%s: ejected is %v, want %v", tt.name, got, tt.ejected)
+		}
+	}
+}
+
+func TestEjectionTimeDoubles(t *testing.T) {
+	const service = "od-doubling"
+	instances := setupOutliers(t, service, map[string]int{"consecutiveErrors": 1, "baseEjectionTimeMs": 10000, "maxEjectionTimeMs": 60000}, "a", "b")
+
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for n, d := range want {
+		if got := ejectionTime(service, n); got != d {
+			t.Errorf("ejection %d: want %s, got %s", n, d, got)
+		}
+	}
+
+	// the successive ejections of the instance double the time
+	for n := 0; n < 3; n++ {
+		report(service, instances[0], http.StatusInternalServerError, time.Millisecond)
+		readmitOutlier(service, "a")
+	}
+	var got []time.Duration
+	for _, e := range outlierEvents.of(service) {
+		if e.Type == OutlierEject {
+			got = append(got, e.Duration)
+		}
+	}
+	if len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("want the ejection times %v, got %v", want[:3], got)
+	}
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	const service = "od-percent"
+	instances := setupOutliers(t, service, map[string]int{"consecutiveErrors": 1, "baseEjectionTimeMs": 60000, "maxEjectionPercent": 50},
+		"a", "b", "c", "d")
+
+	for _, ins := range instances {
+		report(service, ins, http.StatusInternalServerError, time.Millisecond)
+	}
+	if n := registry.EjectedCount(service); n != 2 {
+		t.Errorf("want 50%% of 4 instances ejected, got %d", n)
+	}
+	if len(outlierEvents.of(service)) != 2 {
+		t.Errorf("want 2 ejection events, got %+v", outlierEvents.of(service))
+	}
+
+	// the instances ejected by the health check are counted too
+	const other = "od-percent-health"
+	instances = setupOutliers(t, other, map[string]int{"consecutiveErrors": 1, "baseEjectionTimeMs": 60000, "maxEjectionPercent": 50},
+		"a", "b", "c", "d")
+	registry.EjectInstance(other, instances[0], registry.EjectByHealthCheck)
+	registry.EjectInstance(other, instances[1], registry.EjectByHealthCheck)
+	report(other, instances[2], http.StatusInternalServerError, time.Millisecond)
+	if registry.IsEjected(other, "c") {
+		t.Error("c is ejected beyond the max percent")
+	}
+}
+
+func TestReadmission(t *testing.T) {
+	const service = "od-readmit"
+	instances := setupOutliers(t, service, map[string]int{"consecutiveErrors": 1, "baseEjectionTimeMs": 50, "maxEjectionPercent": 100}, "a", "b", "c")
+	a, b, c := instances[0], instances[1], instances[2]
+
+	// readmitted after the ejection time
+	report(service, a, http.StatusInternalServerError, time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for registry.IsEjected(service, "a") {
+		if time.Now().After(deadline) {
+			t.Fatal("a is not readmitted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := outlierEvents.of(service)
+	if len(events) != 2 || events[0].Type != OutlierEject || events[1].Type != OutlierReadmit {
+		t.Errorf("want the ejection and the readmission of a, got %+v", events)
+	}
+
+	// the instance ejected by the health check too keeps ejected, and nothing is reported
+	archaius.Set("ggs.outlierDetection."+service+".baseEjectionTimeMs", 60000)
+	registry.EjectInstance(service, b, registry.EjectByHealthCheck)
+	report(service, b, http.StatusInternalServerError, time.Millisecond)
+	readmitOutlier(service, "b")
+	if !registry.IsEjected(service, "b") {
+		t.Error("b is readmitted while the health check ejects it")
+	}
+
+	// the instance removed from the registry is forgotten
+	report(service, c, http.StatusInternalServerError, time.Millisecond)
+	mockregistry.RemoveInstance(service, "c")
+	readmitOutlier(service, "c")
+	outlierMu.Lock()
+	_, kept := outliers[service]["c"]
+	outlierMu.Unlock()
+	if kept || registry.IsEjected(service, "c") {
+		t.Error("the removed instance is kept")
+	}
+
+	events = outlierEvents.of(service)
+	if len(events) != 3 || events[2].Type != OutlierEject || events[2].Instance.InstanceID != "c" {
+		t.Errorf("want only the ejection of c reported, got %+v", events)
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"sync"
 )
 
-// RandomStrategy is strategy
+// RandomStrategy is strategy, the instances are picked by their weights
 type RandomStrategy struct {
 	instances []*registry.MicroServiceInstance
 	mtx       sync.Mutex
+	weights   []int
+	uniform   bool
 }
 
 func newRandomStrategy() Strategy {
@@ -21,6 +23,7 @@ func newRandomStrategy() Strategy {
 // ReceiveData receive data
 func (r *RandomStrategy) ReceiveData(inv *invocation.Invocation, instances []*registry.MicroServiceInstance, serviceName string) {
 	r.instances = instances
+	r.weights, r.uniform = instanceWeights(inv.MicroServiceName, instances)
 }
 
 // Pick return instance
@@ -29,6 +32,11 @@ func (r *RandomStrategy) Pick() (*registry.MicroServiceInstance, error) {
 		return nil, ErrNoneAvailableInstance
 	}
 
+	if !r.uniform {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		return weightedRandom(r.instances, r.weights), nil
+	}
 	r.mtx.Lock()
 	k := rand.Int() % len(r.instances)
 	r.mtx.Unlock()
@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+// ewmaDecay is the time constant of the latency average, the latency of 10 seconds ago weighs 1/e
+const ewmaDecay = 10 * time.Second
+
+// requestStats is the in-flight requests and the average latency of an instance
+type requestStats struct {
+	inflight int64
+
+	mu      sync.Mutex
+	latency float64 //ewma of the latency in nanoseconds
+	updated time.Time
+}
+
+var (
+	statsMu sync.RWMutex
+	// service name to instance id to the stats
+	requestStatsMap = make(map[string]map[string]*requestStats)
+)
+
+// getRequestStats returns the stats of the instance, the stats are dropped when the instance is removed from the registry
+func getRequestStats(service, instanceID string) *requestStats {
+	statsMu.RLock()
+	s, ok := requestStatsMap[service][instanceID]
+	statsMu.RUnlock()
+	if ok {
+		return s
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if s, ok = requestStatsMap[service][instanceID]; ok {
+		return s
+	}
+	if requestStatsMap[service] == nil {
+		requestStatsMap[service] = make(map[string]*requestStats)
+		registry.Watch(service, func(e registry.Event) {
+			if e.Type != registry.EventRemoved {
+				return
+			}
+			statsMu.Lock()
+			delete(requestStatsMap[service], e.Instance.InstanceID)
+			statsMu.Unlock()
+		})
+	}
+	s = &requestStats{}
+	requestStatsMap[service][instanceID] = s
+	return s
+}
+
+// BeginRequest counts the request to the instance picked by the load balancer as in-flight,
+// the returned function must be called with the latency when the request ends,
+// only the strategies which use the stats are counted
+func BeginRequest(i *invocation.Invocation) func(latency time.Duration) {
+	if i.Strategy != StrategyLeastRequest && i.Strategy != StrategyP2C {
+		return func(time.Duration) {}
+	}
+	ins, ok := i.Metadata[common.InstanceKey].(*registry.MicroServiceInstance)
+	if !ok || ins == nil {
+		return func(time.Duration) {}
+	}
+	s := getRequestStats(i.MicroServiceName, ins.InstanceID)
+	atomic.AddInt64(&s.inflight, 1)
+	return func(latency time.Duration) {
+		atomic.AddInt64(&s.inflight, -1)
+		s.observe(latency)
+	}
+}
+
+// observe adds the latency to the average, the weight of the old average decays by the elapsed time
+func (s *requestStats) observe(latency time.Duration) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.updated.IsZero() {
+		s.latency = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(s.updated)) / float64(ewmaDecay))
+		s.latency = s.latency*w + float64(latency)*(1-w)
+	}
+	s.updated = now
+}
+
+// load returns the in-flight requests and the average latency
+func (s *requestStats) load() (int64, float64) {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	return atomic.LoadInt64(&s.inflight), latency
+}
+
+// cost is the expected wait of a new request, the instance without latency is preferred until it responds
+func (s *requestStats) cost() float64 {
+	inflight, latency := s.load()
+	return float64(inflight+1) * (latency + 1)
+}
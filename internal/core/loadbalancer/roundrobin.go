@@ -8,10 +8,12 @@ import (
 	"github.com/leon-yc/ggs/internal/core/registry"
 )
 
-// RoundRobinStrategy is strategy
+// RoundRobinStrategy is strategy, it is the smooth weighted round robin if the instances have different weights
 type RoundRobinStrategy struct {
 	instances []*registry.MicroServiceInstance
 	key       string
+	weights   []int
+	uniform   bool
 }
 
 func newRoundRobinStrategy() Strategy {
@@ -22,6 +24,7 @@ func newRoundRobinStrategy() Strategy {
 func (r *RoundRobinStrategy) ReceiveData(inv *invocation.Invocation, instances []*registry.MicroServiceInstance, serviceKey string) {
 	r.instances = instances
 	r.key = serviceKey
+	r.weights, r.uniform = instanceWeights(inv.MicroServiceName, instances)
 }
 
 //Pick return instance
@@ -30,6 +33,9 @@ func (r *RoundRobinStrategy) Pick() (*registry.MicroServiceInstance, error) {
 		return nil, ErrNoneAvailableInstance
 	}
 
+	if !r.uniform {
+		return smoothPick(r.key, r.instances, r.weights), nil
+	}
 	i := pick(r.key)
 	return r.instances[i%len(r.instances)], nil
 }
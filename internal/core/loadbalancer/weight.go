@@ -0,0 +1,171 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+var (
+	seenMu sync.Mutex
+	// service name to instance id to the time the instance is added to the registry,
+	// it is zero for the instances known when the watch begins
+	firstSeen = make(map[string]map[string]time.Time)
+)
+
+// instanceWeights returns the weights of the instances, uniform is true if all instances have the same weight,
+// the weight is the config override, or the weight in the metadata, or the default weight,
+// it is ramped up from the min percent during the slow start window after the instance is added to the registry
+func instanceWeights(service string, instances []*registry.MicroServiceInstance) (weights []int, uniform bool) {
+	overrides := config.GetInstanceWeights("", service)
+	window := config.SlowStartWindow("", service)
+	minPercent := config.SlowStartMinWeightPercent("", service)
+	if window > 0 {
+		watchSeen(service)
+	}
+
+	weights = make([]int, len(instances))
+	uniform = true
+	total := 0
+	for i, ins := range instances {
+		w := weightOf(ins, overrides)
+		if window > 0 && w > 0 {
+			w = slowStart(service, ins.InstanceID, w, window, minPercent)
+		}
+		weights[i] = w
+		total += w
+		if w != weights[0] {
+			uniform = false
+		}
+	}
+	// the weights are useless if all of them are 0
+	if total == 0 {
+		return weights, true
+	}
+	return weights, uniform
+}
+
+func weightOf(ins *registry.MicroServiceInstance, overrides map[string]int) int {
+	if w, ok := overrides[ins.InstanceID]; ok {
+		return w
+	}
+	for _, ep := range ins.EndpointsMap {
+		if w, ok := overrides[ep]; ok {
+			return w
+		}
+	}
+	if w, ok := ins.Weight(); ok {
+		return w
+	}
+	return config.DefaultWeight
+}
+
+// watchSeen records the time the instances of the service are added from the registry events,
+// the instances known when the watch begins are not slow started
+func watchSeen(service string) {
+	seenMu.Lock()
+	defer seenMu.Unlock()
+	if firstSeen[service] != nil {
+		return
+	}
+	firstSeen[service] = make(map[string]time.Time)
+	registry.Watch(service, func(e registry.Event) {
+		seenMu.Lock()
+		defer seenMu.Unlock()
+		switch e.Type {
+		case registry.EventAdded:
+			if e.Replay {
+				firstSeen[service][e.Instance.InstanceID] = time.Time{}
+			} else {
+				firstSeen[service][e.Instance.InstanceID] = time.Now()
+			}
+		case registry.EventRemoved:
+			delete(firstSeen[service], e.Instance.InstanceID)
+		}
+	})
+}
+
+// slowStart returns the weight ramped linearly by the time since the instance is added to the registry,
+// the instance without the added event has the full weight
+func slowStart(service, instanceID string, w int, window time.Duration, minPercent int) int {
+	seenMu.Lock()
+	seen, ok := firstSeen[service][instanceID]
+	seenMu.Unlock()
+	if !ok || seen.IsZero() {
+		return w
+	}
+
+	elapsed := time.Since(seen)
+	if elapsed >= window {
+		return w
+	}
+	percent := float64(minPercent) + float64(100-minPercent)*float64(elapsed)/float64(window)
+	ramped := int(float64(w) * percent / 100)
+	if ramped < 1 {
+		ramped = 1
+	}
+	return ramped
+}
+
+// smoothWeights is the current weights of the smooth weighted round robin of a service key
+type smoothWeights struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+var (
+	swrrMu  sync.Mutex
+	swrrMap = make(map[string]*smoothWeights)
+)
+
+// smoothPick is the smooth weighted round robin of nginx, the instances are picked evenly by their weights
+func smoothPick(key string, instances []*registry.MicroServiceInstance, weights []int) *registry.MicroServiceInstance {
+	swrrMu.Lock()
+	s, ok := swrrMap[key]
+	if !ok {
+		s = &smoothWeights{current: make(map[string]int)}
+		swrrMap[key] = s
+	}
+	swrrMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total, best, bestWeight := 0, -1, 0
+	for i, ins := range instances {
+		total += weights[i]
+		cw := s.current[ins.InstanceID] + weights[i]
+		s.current[ins.InstanceID] = cw
+		if best < 0 || cw > bestWeight {
+			best, bestWeight = i, cw
+		}
+	}
+	s.current[instances[best].InstanceID] -= total
+	// drop the removed instances
+	if len(s.current) > len(instances) {
+		alive := make(map[string]int, len(instances))
+		for _, ins := range instances {
+			alive[ins.InstanceID] = s.current[ins.InstanceID]
+		}
+		s.current = alive
+	}
+	return instances[best]
+}
+
+// weightedRandom picks an instance randomly by the weights
+func weightedRandom(instances []*registry.MicroServiceInstance, weights []int) *registry.MicroServiceInstance {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return instances[i]
+		}
+		n -= w
+	}
+	return instances[len(instances)-1]
+}
@@ -0,0 +1,122 @@
+package loadbalancer
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+func TestSmoothPickDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		// the picks of the first round
+		sequence string
+	}{
+		{"uniform", []int{1, 1, 1}, "abc"},
+		{"weighted", []int{5, 1, 1}, "aabacaa"},
+		{"zero weight", []int{2, 0, 1}, "aca"},
+	}
+	for _, tt := range tests {
+		instances := newInstances("a", "b", "c")
+		total := 0
+		for _, w := range tt.weights {
+			total += w
+		}
+		key := "smooth-" + tt.name
+		var sequence strings.Builder
+		picked := make(map[string]int)
+		const rounds = 10
+		for n := 0; n < total*rounds; n++ {
+			id := smoothPick(key, instances, tt.weights).InstanceID
+			if n < total {
+				sequence.WriteString(id)
+			}
+			picked[id]++
+		}
+		if got := sequence.String(); got != tt.sequence {
+			t.Errorf("%s: want the sequence %s, got %s", tt.name, tt.sequence, got)
+		}
+		for i, ins := range instances {
+			if picked[ins.InstanceID] != tt.weights[i]*rounds {
+				t.Errorf("%s: %s is picked %d times, want %d", tt.name, ins.InstanceID, picked[ins.InstanceID], tt.weights[i]*rounds)
+			}
+		}
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	const service = "slow-started"
+	window := 10 * time.Second
+	now := time.Now()
+	seenMu.Lock()
+	firstSeen[service] = map[string]time.Time{
+		"new":      now,
+		"half":     now.Add(-window / 2),
+		"warm":     now.Add(-window),
+		"replayed": {},
+	}
+	seenMu.Unlock()
+	defer func() {
+		seenMu.Lock()
+		delete(firstSeen, service)
+		seenMu.Unlock()
+	}()
+
+	tests := []struct {
+		id     string
+		weight int
+		want   int
+	}{
+		{"new", 100, 10},
+		{"half", 100, 55},
+		{"warm", 100, 100},
+		{"replayed", 100, 100},
+		{"unknown", 100, 100},
+		{"new", 5, 1},
+	}
+	for _, tt := range tests {
+		got := slowStart(service, tt.id, tt.weight, window, 10)
+		// the elapsed time goes on during the test
+		if got < tt.want || got > tt.want+1 {
+			t.Errorf("%s of weight %d: want %d, got %d", tt.id, tt.weight, tt.want, got)
+		}
+	}
+}
+
+func TestSlowStartBeginsWhenAdded(t *testing.T) {
+	// the watch of the service is kept, a new service begins it again
+	service := "slow-start-added-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	archaius.Set("ggs.loadbalance."+service+".slowStart.windowMs", 60000)
+	defer archaius.Set("ggs.loadbalance."+service+".slowStart.windowMs", 0)
+	old := &registry.MicroServiceInstance{InstanceID: "old", Metadata: map[string]string{registry.MetaWeight: "100"}}
+	added := &registry.MicroServiceInstance{InstanceID: "added", Metadata: map[string]string{registry.MetaWeight: "100"}}
+	registry.SetInstances(service, []*registry.MicroServiceInstance{old})
+	defer registry.DeleteInstances(service)
+
+	// the instances known when the watch begins have the full weight
+	weights, uniform := instanceWeights(service, []*registry.MicroServiceInstance{old})
+	if weights[0] != 100 || !uniform {
+		t.Fatalf("want the full weight of the known instance, got %v", weights)
+	}
+
+	registry.SetInstances(service, []*registry.MicroServiceInstance{old, added})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		weights, uniform = instanceWeights(service, []*registry.MicroServiceInstance{old, added})
+		if weights[1] < 100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the added instance is not slow started, weights %v", weights)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if weights[0] != 100 || weights[1] > 11 || uniform {
+		t.Errorf("want the added instance ramped from 10%%, got %v", weights)
+	}
+}
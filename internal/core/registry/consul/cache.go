@@ -0,0 +1,190 @@
+package consul
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	chregistry "github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+const (
+	//DefaultWatchWaitTime is the max time a blocking query waits for changes
+	DefaultWatchWaitTime = 5 * time.Minute
+	//DefaultQueryTimeout is the timeout of the first query of a service
+	DefaultQueryTimeout = 10 * time.Second
+
+	//StatusDown is the status of the instances which are not passing
+	StatusDown = "DOWN"
+
+	minRetryInterval = 1 * time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+// newConsulClient creates consul api client from the address like http://127.0.0.1:8500
+func newConsulClient(addr string, tlsConfig *tls.Config) (*api.Client, error) {
+	uri, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := api.DefaultConfig()
+	if uri.Host != "" {
+		cfg.Address = uri.Host
+		cfg.Scheme = uri.Scheme
+	} else {
+		cfg.Address = addr
+	}
+	if tlsConfig != nil {
+		cfg.Scheme = "https"
+		cfg.Transport.TLSClientConfig = tlsConfig
+	}
+	return api.NewClient(cfg)
+}
+
+// instanceCache keeps the instances of the referenced services, and watches them by blocking queries
+type instanceCache struct {
+	client *api.Client
+	dc     string
+
+	mu        sync.RWMutex
+	instances map[string][]*chregistry.MicroServiceInstance
+	watches   map[string]context.CancelFunc
+	closed    bool
+}
+
+func newInstanceCache(client *api.Client, dc string) *instanceCache {
+	return &instanceCache{
+		client:    client,
+		dc:        dc,
+		instances: make(map[string][]*chregistry.MicroServiceInstance),
+		watches:   make(map[string]context.CancelFunc),
+	}
+}
+
+// get returns the cached instances of the service
+func (c *instanceCache) get(service string) ([]*chregistry.MicroServiceInstance, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	instances, ok := c.instances[service]
+	return instances, ok
+}
+
+// set saves the instances, and feeds them to the registry instance index
+func (c *instanceCache) set(service string, instances []*chregistry.MicroServiceInstance) {
+	c.mu.Lock()
+	c.instances[service] = instances
+	c.mu.Unlock()
+	chregistry.SetInstances(service, instances)
+}
+
+// fetch queries the service once, and saves the result
+func (c *instanceCache) fetch(service string) ([]*chregistry.MicroServiceInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	opts := &api.QueryOptions{
+		Datacenter: c.dc,
+		AllowStale: true,
+	}
+	entries, _, err := c.client.Health().Service(service, "", true, opts.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("query service %s from consul failed: %s", service, err)
+	}
+	instances := toInstances(fromServiceEntries(entries))
+	c.set(service, instances)
+	return instances, nil
+}
+
+// watchAll watches the services which are already referenced
+func (c *instanceCache) watchAll() {
+	c.mu.RLock()
+	services := make([]string, 0, len(c.instances))
+	for service := range c.instances {
+		services = append(services, service)
+	}
+	c.mu.RUnlock()
+	for _, service := range services {
+		c.watch(service)
+	}
+}
+
+// watch starts a blocking query loop of the service if it is not watched
+func (c *instanceCache) watch(service string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if _, ok := c.watches[service]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.watches[service] = cancel
+	go c.watchLoop(ctx, service)
+}
+
+// watchLoop keeps the last known instances when consul is unreachable, and retries with backoff
+func (c *instanceCache) watchLoop(ctx context.Context, service string) {
+	var index uint64
+	retry := minRetryInterval
+	for {
+		opts := &api.QueryOptions{
+			Datacenter: c.dc,
+			AllowStale: true,
+			WaitIndex:  index,
+			WaitTime:   DefaultWatchWaitTime,
+		}
+		entries, meta, err := c.client.Health().Service(service, "", true, opts.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			qlog.Warnf("watch service %s from consul failed, keep the last known instances, retry after %s: %s",
+				service, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry *= 2
+			if retry > maxRetryInterval {
+				retry = maxRetryInterval
+			}
+			continue
+		}
+		retry = minRetryInterval
+
+		// an index of 0 does not block, and the index may go backwards when consul restarts,
+		// then query from the beginning
+		if meta.LastIndex == 0 {
+			meta.LastIndex = 1
+		}
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		if meta.LastIndex == index {
+			continue
+		}
+		index = meta.LastIndex
+
+		instances := toInstances(fromServiceEntries(entries))
+		c.set(service, instances)
+		qlog.Tracef("service %s changed, %d instances", service, len(instances))
+	}
+}
+
+// close stops all watches
+func (c *instanceCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for service, cancel := range c.watches {
+		cancel()
+		delete(c.watches, service)
+	}
+	c.closed = true
+}
@@ -0,0 +1,503 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	chregistry "github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+const maintenancePrefix = "_service_maintenance:"
+
+// fakeConsul serves the agent and catalog apis used by the plugin, the state is kept in memory,
+// every change bumps the index, so the blocking queries return
+type fakeConsul struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]*api.AgentServiceRegistration // key is the service id
+	checks   map[string]*api.HealthCheck              // key is the check id
+	ttls     map[string]int                           // the times the ttl checks are updated
+}
+
+// newFakeConsul starts the server and points the registry address to it
+func newFakeConsul(t *testing.T) *fakeConsul {
+	s := &fakeConsul{
+		index:    1,
+		changed:  make(chan struct{}),
+		services: make(map[string]*api.AgentServiceRegistration),
+		checks:   make(map[string]*api.HealthCheck),
+		ttls:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	registry := &config.GlobalDefinition.Ggs.Service.Registry
+	old := registry.Address
+	registry.Address = s.URL
+	t.Cleanup(func() { registry.Address = old })
+	return s
+}
+
+// bump must be called with the lock held
+func (s *fakeConsul) bump() {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *fakeConsul) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/v1/agent/service/register":
+		var reg api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.services[reg.ID] = &reg
+		for i, c := range reg.Checks {
+			id := "service:" + reg.ID
+			if i > 0 {
+				id += ":" + strconv.Itoa(i)
+			}
+			s.checks[id] = &api.HealthCheck{CheckID: id, ServiceID: reg.ID, Status: c.Status}
+		}
+		s.bump()
+		s.mu.Unlock()
+	case path == "/v1/agent/check/register":
+		var reg api.AgentCheckRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.checks[reg.ID] = &api.HealthCheck{CheckID: reg.ID, ServiceID: reg.ServiceID, Status: reg.Status}
+		s.bump()
+		s.mu.Unlock()
+	case strings.HasPrefix(path, "/v1/agent/check/update/"):
+		var update struct{ Status string }
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := strings.TrimPrefix(path, "/v1/agent/check/update/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c, ok := s.checks[id]
+		if !ok {
+			http.Error(w, "unknown check "+id, http.StatusInternalServerError)
+			return
+		}
+		c.Status = update.Status
+		s.ttls[id]++
+		s.bump()
+	case strings.HasPrefix(path, "/v1/agent/service/maintenance/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/maintenance/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.services[id]; !ok {
+			http.Error(w, "unknown service "+id, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("enable") == "true" {
+			s.checks[maintenancePrefix+id] = &api.HealthCheck{CheckID: maintenancePrefix + id, ServiceID: id,
+				Status: api.HealthCritical, Notes: r.URL.Query().Get("reason")}
+		} else {
+			delete(s.checks, maintenancePrefix+id)
+		}
+		s.bump()
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/deregister/")
+		s.mu.Lock()
+		delete(s.services, id)
+		for cid, c := range s.checks {
+			if c.ServiceID == id {
+				delete(s.checks, cid)
+			}
+		}
+		s.bump()
+		s.mu.Unlock()
+	case path == "/v1/catalog/services":
+		s.mu.Lock()
+		services := make(map[string][]string)
+		for _, reg := range s.services {
+			services[reg.Name] = append(services[reg.Name], reg.Tags...)
+		}
+		s.reply(w, services)
+		s.mu.Unlock()
+	case strings.HasPrefix(path, "/v1/catalog/service/"):
+		name := strings.TrimPrefix(path, "/v1/catalog/service/")
+		s.mu.Lock()
+		var entries []*api.CatalogService
+		for _, reg := range s.sorted(name) {
+			entries = append(entries, &api.CatalogService{Node: "fake", ServiceID: reg.ID, ServiceName: reg.Name,
+				ServiceAddress: reg.Address, ServicePort: reg.Port, ServiceTags: reg.Tags, ServiceMeta: reg.Meta})
+		}
+		s.reply(w, entries)
+		s.mu.Unlock()
+	case strings.HasPrefix(path, "/v1/health/service/"):
+		s.serveHealth(w, r, strings.TrimPrefix(path, "/v1/health/service/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveHealth blocks until the index is greater than the index of the query
+func (s *fakeConsul) serveHealth(w http.ResponseWriter, r *http.Request, name string) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	timeout := time.After(2 * time.Second)
+	s.mu.Lock()
+	for s.index <= index {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-r.Context().Done():
+			return
+		}
+		s.mu.Lock()
+		if changed == s.changed {
+			break
+		}
+	}
+	defer s.mu.Unlock()
+
+	passingOnly := r.URL.Query().Get("passing") != ""
+	entries := make([]*api.ServiceEntry, 0)
+	for _, reg := range s.sorted(name) {
+		var checks api.HealthChecks
+		for _, c := range s.checks {
+			if c.ServiceID == reg.ID {
+				checks = append(checks, c)
+			}
+		}
+		if passingOnly && checks.AggregatedStatus() != api.HealthPassing {
+			continue
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Node: "fake", Address: "127.0.0.1"},
+			Service: &api.AgentService{ID: reg.ID, Service: reg.Name, Address: reg.Address, Port: reg.Port, Tags: reg.Tags, Meta: reg.Meta},
+			Checks:  checks,
+		})
+	}
+	s.reply(w, entries)
+}
+
+// sorted returns the registrations of the service name in the order of the ids
+func (s *fakeConsul) sorted(name string) []*api.AgentServiceRegistration {
+	var regs []*api.AgentServiceRegistration
+	for _, reg := range s.services {
+		if reg.Name == name {
+			regs = append(regs, reg)
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].ID < regs[j].ID })
+	return regs
+}
+
+// reply must be called with the lock held
+func (s *fakeConsul) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeConsul) service(id string) *api.AgentServiceRegistration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.services[id]
+}
+
+func (s *fakeConsul) check(id string) (api.HealthCheck, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.checks[id]
+	if !ok {
+		return api.HealthCheck{}, false
+	}
+	return *c, true
+}
+
+func (s *fakeConsul) ttlUpdates(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttls[id]
+}
+
+func (s *fakeConsul) setCheck(id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[id].Status = status
+	s.bump()
+}
+
+// register adds a service registered by others, it has no protocol in metadata
+func (s *fakeConsul) register(id, name, ip string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[id] = &api.AgentServiceRegistration{ID: id, Name: name, Address: ip, Port: port}
+	s.checks["service:"+id] = &api.HealthCheck{CheckID: "service:" + id, ServiceID: id, Status: api.HealthPassing}
+	s.bump()
+}
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	config.MicroserviceDefinition.ServiceDescription.Name = "hello"
+	config.MicroserviceDefinition.ServiceDescription.Version = "1.0.0"
+	os.Exit(m.Run())
+}
+
+// registerHello registers the rest and grpc endpoints of the hello service
+func registerHello(t *testing.T) *Registrator {
+	r := NewRegistrator(chregistry.Options{}).(*Registrator)
+	sid, instanceID, err := r.RegisterServiceAndInstance(&chregistry.MicroService{
+		ServiceName: "hello",
+		Framework:   &chregistry.Framework{Name: "ggs", Version: "test"},
+	}, &chregistry.MicroServiceInstance{
+		EndpointsMap: map[string]string{
+			common.ProtocolRest: "127.0.0.1:8080",
+			common.ProtocolGrpc: "127.0.0.1:9090",
+		},
+		Metadata: map[string]string{"color": "red"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "hello" || instanceID != "127.0.0.1:9090" {
+		t.Fatalf("unexpected service %s and instance %s", sid, instanceID)
+	}
+	t.Cleanup(func() { r.UnRegisterMicroServiceInstance(sid, instanceID) })
+	return r
+}
+
+func TestRegisterServiceAndInstance(t *testing.T) {
+	s := newFakeConsul(t)
+	r := registerHello(t)
+
+	if len(r.registrations) != 2 {
+		t.Fatalf("want a registration for each protocol, got %d", len(r.registrations))
+	}
+	for _, reg := range r.registrations {
+		got := s.service(reg.service.ServiceID())
+		if got == nil {
+			t.Fatalf("service %s is not registered", reg.service.ServiceID())
+		}
+		protocol := got.Meta[MetaProtocol]
+		wantName := map[string]string{common.ProtocolRest: "hello", common.ProtocolGrpc: "hello-grpc"}[protocol]
+		if got.Name != wantName {
+			t.Errorf("want name %s of protocol %s, got %s", wantName, protocol, got.Name)
+		}
+		if got.Meta[MetaInstance] != "127.0.0.1:9090" || got.Meta["color"] != "red" {
+			t.Errorf("unexpected metadata %v", got.Meta)
+		}
+		if c, ok := s.check(reg.checkID()); !ok || c.Status != api.HealthPassing || c.ServiceID != got.ID {
+			t.Errorf("the ttl check of %s is not registered: %+v", got.ID, c)
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	s := newFakeConsul(t)
+	r := registerHello(t)
+
+	// the ttl is expired
+	for _, reg := range r.registrations {
+		s.setCheck(reg.checkID(), api.HealthCritical)
+	}
+	ok, err := r.Heartbeat("hello", "127.0.0.1:9090")
+	if err != nil || !ok {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	for _, reg := range r.registrations {
+		c, _ := s.check(reg.checkID())
+		if c.Status != api.HealthPassing {
+			t.Errorf("want the check %s passing after heartbeat, got %s", c.CheckID, c.Status)
+		}
+		if n := s.ttlUpdates(reg.checkID()); n != 1 {
+			t.Errorf("want the check %s updated once, got %d", c.CheckID, n)
+		}
+	}
+}
+
+func TestUpdateMicroServiceInstanceStatus(t *testing.T) {
+	s := newFakeConsul(t)
+	r := registerHello(t)
+	d := NewServiceDiscovery(chregistry.Options{}).(*ServiceDiscovery)
+	defer d.Close()
+
+	if err := r.UpdateMicroServiceInstanceStatus("hello", "127.0.0.1:9090", "DOWN"); err != nil {
+		t.Fatal(err)
+	}
+	for _, reg := range r.registrations {
+		c, ok := s.check(maintenancePrefix + reg.service.ServiceID())
+		if !ok || c.Notes != MaintenanceReason+"DOWN" {
+			t.Errorf("service %s is not in maintenance: %+v", reg.service.ServiceID(), c)
+		}
+	}
+	instances, err := d.GetMicroServiceInstances("", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].Status != StatusDown {
+		t.Errorf("want the instance in maintenance DOWN, got %v", instances)
+	}
+	passing, err := d.cache.fetch("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passing) != 0 {
+		t.Errorf("want no passing instance in maintenance, got %d", len(passing))
+	}
+
+	if err := r.UpdateMicroServiceInstanceStatus("hello", "127.0.0.1:9090", common.DefaultStatus); err != nil {
+		t.Fatal(err)
+	}
+	for _, reg := range r.registrations {
+		if _, ok := s.check(maintenancePrefix + reg.service.ServiceID()); ok {
+			t.Errorf("service %s is still in maintenance", reg.service.ServiceID())
+		}
+	}
+	passing, err = d.cache.fetch("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passing) != 1 || passing[0].Status != common.DefaultStatus {
+		t.Errorf("want the instance UP again, got %v", passing)
+	}
+}
+
+func TestUpdateMicroServiceInstanceProperties(t *testing.T) {
+	s := newFakeConsul(t)
+	r := registerHello(t)
+
+	err := r.UpdateMicroServiceInstanceProperties("hello", "127.0.0.1:9090", map[string]string{
+		"color":   "blue",
+		"stage":   "canary",
+		"bad key": "x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reg := range r.registrations {
+		got := s.service(reg.service.ServiceID())
+		if got.Meta["color"] != "blue" || got.Meta["stage"] != "canary" {
+			t.Errorf("metadata of %s is not updated: %v", got.ID, got.Meta)
+		}
+		if _, ok := got.Meta["bad key"]; ok {
+			t.Errorf("the invalid key is registered to %s", got.ID)
+		}
+		if got.Meta[MetaInstance] != "127.0.0.1:9090" {
+			t.Errorf("the built-in metadata of %s is lost: %v", got.ID, got.Meta)
+		}
+		// the service is registered again, the ttl check must still be there
+		if _, ok := s.check(reg.checkID()); !ok {
+			t.Errorf("the ttl check of %s is lost", got.ID)
+		}
+	}
+}
+
+func TestCatalogQueries(t *testing.T) {
+	s := newFakeConsul(t)
+	registerHello(t)
+	s.register("other-1", "other", "10.0.0.1", 80)
+	d := NewServiceDiscovery(chregistry.Options{}).(*ServiceDiscovery)
+	defer d.Close()
+
+	services, err := d.GetAllMicroServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(services))
+	for _, ms := range services {
+		names = append(names, ms.ServiceName)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "hello,hello-grpc,other" {
+		t.Errorf("unexpected services %v", names)
+	}
+
+	ms, err := d.GetMicroService("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.ServiceID != "hello" || ms.Metadata[MetaProtocol] != common.ProtocolRest {
+		t.Errorf("unexpected service %+v", ms)
+	}
+	if _, err := d.GetMicroService("missing"); err == nil {
+		t.Error("want an error of the missing service")
+	}
+
+	instances, err := d.FindMicroServiceInstances("", "other", utiltags.Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("want 1 instance of other, got %d", len(instances))
+	}
+	ins := instances[0]
+	if ins.InstanceID != "other-1" || ins.EndpointsMap[common.ProtocolRest] != "10.0.0.1:80" ||
+		ins.EndpointsMap[common.ProtocolGrpc] != "10.0.0.1:80" {
+		t.Errorf("unexpected instance %+v", ins)
+	}
+}
+
+func TestWatchInstances(t *testing.T) {
+	s := newFakeConsul(t)
+	s.register("watched-1", "watched", "10.0.0.1", 80)
+	d := NewServiceDiscovery(chregistry.Options{}).(*ServiceDiscovery)
+	defer d.Close()
+
+	events := make(chan chregistry.Event, 10)
+	stop := chregistry.Watch("watched", func(e chregistry.Event) { events <- e })
+	defer stop()
+
+	if _, err := d.FindMicroServiceInstances("", "watched", utiltags.Tags{}); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, chregistry.EventAdded, "watched-1")
+
+	s.register("watched-2", "watched", "10.0.0.2", 80)
+	expectEvent(t, events, chregistry.EventAdded, "watched-2")
+
+	s.setCheck("service:watched-1", api.HealthCritical)
+	expectEvent(t, events, chregistry.EventRemoved, "watched-1")
+
+	instances, _ := d.FindMicroServiceInstances("", "watched", utiltags.Tags{})
+	if len(instances) != 1 || instances[0].InstanceID != "watched-2" {
+		t.Errorf("want the cache updated by the watch, got %v", instances)
+	}
+}
+
+func expectEvent(t *testing.T, events chan chregistry.Event, typ, instanceID string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ && e.Instance.InstanceID == instanceID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %s event of %s", typ, instanceID)
+		}
+	}
+}
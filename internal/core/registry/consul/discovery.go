@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/leon-yc/ggs/pkg/qlog"
@@ -8,8 +9,10 @@ import (
 	client "github.com/leon-yc/ggs/internal/pkg/scclient"
 	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
 
+	"github.com/hashicorp/consul/api"
 	qudiscovery "github.com/leon-gopher/discovery"
 	quregistry "github.com/leon-gopher/discovery/registry"
+	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/config"
 	chregistry "github.com/leon-yc/ggs/internal/core/registry"
 )
@@ -19,7 +22,8 @@ type ServiceDiscovery struct {
 	registryClient *client.RegistryClient
 	opts           client.Options
 
-	r *qudiscovery.Registry //for discovery
+	r     *qudiscovery.Registry //fallback when consul is unreachable and nothing is cached
+	cache *instanceCache
 }
 
 //NewServiceDiscovery new service center discovery
@@ -31,65 +35,130 @@ func NewServiceDiscovery(options chregistry.Options) chregistry.ServiceDiscovery
 	//}
 
 	consulAddr := config.GetServiceDiscoveryAddress()
+	if addrs := chregistry.OwnAddrs(options); len(addrs) > 0 {
+		consulAddr = options.URL(addrs[0])
+	}
 	r, err := qudiscovery.NewRegistryWithConsul(consulAddr)
 	if err != nil {
 		qlog.Errorf("new discovery object faild,consuladdr: %s err:%s", consulAddr, err.Error())
 		return nil
 	}
+	client, err := newConsulClient(consulAddr, options.TLSConfig)
+	if err != nil {
+		qlog.Errorf("new consul client faild,consuladdr: %s err:%s", consulAddr, err.Error())
+		return nil
+	}
 
 	return &ServiceDiscovery{
-		Name: ServiceCenter,
-		r:    r,
+		Name:  ServiceCenter,
+		r:     r,
+		cache: newInstanceCache(client, datacenter(options.Tenant)),
 		//registryClient: r,
 		//opts:           sco,
 	}
 }
 
+//datacenter returns the consul datacenter set by the tenant of the options, or the registry tenant
+func datacenter(tenant string) string {
+	if tenant == "" {
+		tenant = config.GlobalDefinition.Ggs.Service.Registry.Tenant //tenant -> dc
+	}
+	if tenant == "default" {
+		return ""
+	}
+	return tenant
+}
+
 func (dis *ServiceDiscovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
 	return microServiceName, nil
 }
 
+//GetAllMicroServices lists the services in the consul catalog
 func (dis *ServiceDiscovery) GetAllMicroServices() ([]*chregistry.MicroService, error) {
-	return nil, nil
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	services, _, err := dis.cache.client.Catalog().Services(dis.queryOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("list services from consul failed: %s", err)
+	}
+	microServices := make([]*chregistry.MicroService, 0, len(services))
+	for name := range services {
+		microServices = append(microServices, &chregistry.MicroService{
+			ServiceID:   name,
+			ServiceName: name,
+			Status:      common.DefaultStatus,
+		})
+	}
+	return microServices, nil
 }
 
+//GetMicroService returns the service of the name in the consul catalog, the service id is the service name
 func (dis *ServiceDiscovery) GetMicroService(microServiceID string) (*chregistry.MicroService, error) {
-	return nil, nil
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	entries, _, err := dis.cache.client.Catalog().Service(microServiceID, "", dis.queryOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("get service %s from consul failed: %s", microServiceID, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("service %s not found in consul", microServiceID)
+	}
+	return &chregistry.MicroService{
+		ServiceID:   microServiceID,
+		ServiceName: microServiceID,
+		Status:      common.DefaultStatus,
+		Metadata:    entries[0].ServiceMeta,
+	}, nil
 }
+
+//GetMicroServiceInstances returns all instances of the provider, the unhealthy ones are DOWN
 func (dis *ServiceDiscovery) GetMicroServiceInstances(consumerID, providerID string) ([]*chregistry.MicroServiceInstance, error) {
-	//consulAddr := config.GetServiceDiscoveryAddress()
-	//client, err := consul.New(consulAddr)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//
-	//r, err := qudiscovery.NewRegistry(qudiscovery.WithDiscoverys(client), qudiscovery.WithRegisters(client))
-	//if err != nil {
-	//	return nil, err
-	//}
-	//
-	//srvlist, err := r.LookupServices(providerID)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//
-	////var microinss []*chregistry.MicroServiceInstance
-	//for _, v := range srvlist {
-	//	var mi chregistry.MicroServiceInstance
-	//
-	//	mi.Metadata = v.Meta
-	//}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	entries, _, err := dis.cache.client.Health().Service(providerID, "", false, dis.queryOptions(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("get instances of %s from consul failed: %s", providerID, err)
+	}
+	return toInstances(fromServiceEntries(entries)), nil
+}
 
-	return nil, nil
+func (dis *ServiceDiscovery) queryOptions(ctx context.Context) *api.QueryOptions {
+	opts := &api.QueryOptions{
+		Datacenter: dis.cache.dc,
+		AllowStale: true,
+	}
+	return opts.WithContext(ctx)
 }
 
+//FindMicroServiceInstances returns the instances from the local cache, the service is queried and watched
+//when it is referenced for the first time
 func (dis *ServiceDiscovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) (
-	microinss []*chregistry.MicroServiceInstance, err error) {
+	[]*chregistry.MicroServiceInstance, error) {
+	if instances, ok := dis.cache.get(microServiceName); ok {
+		return instances, nil
+	}
 
+	instances, err := dis.cache.fetch(microServiceName)
+	if err != nil {
+		qlog.Warnf("%s, fall back to the local dump", err)
+		instances, err = dis.lookup(microServiceName)
+		if err != nil {
+			return nil, err
+		}
+		// the next picks read the dump from the cache instead of waiting for consul again,
+		// the watch replaces it when consul is back
+		dis.cache.set(microServiceName, instances)
+	}
+	dis.cache.watch(microServiceName)
+	return instances, nil
+}
+
+//lookup finds the instances by the discovery sdk, which reads the local dump when consul is unreachable
+func (dis *ServiceDiscovery) lookup(microServiceName string) ([]*chregistry.MicroServiceInstance, error) {
 	var srvlist []*quregistry.Service
-	tenant := config.GlobalDefinition.Ggs.Service.Registry.Tenant //tenant -> dc
-	if tenant != "" && tenant != "default" {
-		opt := quregistry.WithDC(tenant)
+	var err error
+	if dc := dis.cache.dc; dc != "" {
+		opt := quregistry.WithDC(dc)
 		srvlist, err = dis.r.LookupServices(microServiceName, opt) //opt 不能为nil
 	} else {
 		srvlist, err = dis.r.LookupServices(microServiceName)
@@ -98,30 +167,16 @@ func (dis *ServiceDiscovery) FindMicroServiceInstances(consumerID, microServiceN
 	if err != nil {
 		return nil, err
 	}
-
-	for _, v := range srvlist {
-		var mi chregistry.MicroServiceInstance
-		mi.EndpointsMap = make(map[string]string)
-
-		mi.Metadata = v.Meta
-		mi.HostName = v.IP
-		mi.InstanceID = v.ID
-		mi.ServiceID = v.ID
-
-		ep := v.IP + ":" + fmt.Sprintf("%d", v.Port)
-		mi.EndpointsMap["rest"] = ep
-		mi.EndpointsMap["grpc"] = ep
-
-		microinss = append(microinss, &mi)
-	}
-
-	return microinss, err
+	return toInstances(fromServices(srvlist)), nil
 }
 
+//AutoSync watches the referenced services by consul blocking queries
 func (dis *ServiceDiscovery) AutoSync() {
-
+	dis.cache.watchAll()
 }
 
+//Close stops the watches
 func (dis *ServiceDiscovery) Close() error {
+	dis.cache.close()
 	return nil
 }
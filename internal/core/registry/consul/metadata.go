@@ -0,0 +1,203 @@
+package consul
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	quregistry "github.com/leon-gopher/discovery/registry"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	chregistry "github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/pkg/runtime"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// keys of the metadata registered to consul
+const (
+	MetaProtocol = "protoc"
+	MetaInstance = "instance"
+	MetaHostName = "hostname"
+	MetaEnv      = "env"
+	MetaRegion   = "region"
+	MetaZone     = "zone"
+	MetaApp      = common.BuildinTagApp
+	MetaVersion  = common.BuildinTagVersion
+)
+
+// placeholders of the service name template
+const (
+	PlaceholderService  = "{service}"
+	PlaceholderProtocol = "{protocol}"
+)
+
+// consul only accepts the metadata keys of letters, digits, - and _
+var metaKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
+// registeredName returns the registered service name of the protocol,
+// by default rest uses the plain service name, and other protocols get a -<protocol> suffix
+func registeredName(tmpl, service, protocol string) string {
+	if tmpl == "" {
+		if protocol == common.ProtocolRest {
+			return service
+		}
+		return service + "-" + protocol
+	}
+	return strings.NewReplacer(PlaceholderService, service, PlaceholderProtocol, protocol).Replace(tmpl)
+}
+
+// registeredMeta returns the metadata of the protocol of the instance, the properties can not override the built-in keys
+func registeredMeta(instanceID, protocol string, instance *chregistry.MicroServiceInstance) map[string]string {
+	desc := config.MicroserviceDefinition.ServiceDescription
+	meta := make(map[string]string)
+	for _, props := range []map[string]string{desc.Properties, desc.InstanceProperties, instance.Metadata} {
+		for k, v := range props {
+			if !metaKeyRegexp.MatchString(k) {
+				qlog.Warnf("property %s is not registered to consul, the key must be letters, digits, - or _", k)
+				continue
+			}
+			meta[k] = v
+		}
+	}
+
+	builtin := map[string]string{
+		MetaProtocol: protocol,
+		MetaInstance: instanceID,
+		MetaHostName: runtime.HostName,
+		MetaApp:      runtime.App,
+		MetaVersion:  desc.Version,
+		MetaEnv:      desc.Environment,
+	}
+	if dc := instance.DataCenterInfo; dc != nil {
+		builtin[MetaRegion] = dc.Name
+		builtin[MetaZone] = dc.AvailableZone
+	}
+	for k, v := range builtin {
+		if v != "" {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// endpointEntry is a registered consul service of a protocol
+type endpointEntry struct {
+	id      string
+	name    string
+	ip      string
+	port    int
+	meta    map[string]string
+	passing bool
+}
+
+func fromServiceEntries(entries []*api.ServiceEntry) []endpointEntry {
+	eps := make([]endpointEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Service == nil {
+			continue
+		}
+		ip := e.Service.Address
+		if ip == "" && e.Node != nil {
+			ip = e.Node.Address
+		}
+		eps = append(eps, endpointEntry{
+			id:      e.Service.ID,
+			name:    e.Service.Service,
+			ip:      ip,
+			port:    e.Service.Port,
+			meta:    e.Service.Meta,
+			passing: e.Checks.AggregatedStatus() == api.HealthPassing,
+		})
+	}
+	return eps
+}
+
+func fromServices(services []*quregistry.Service) []endpointEntry {
+	eps := make([]endpointEntry, 0, len(services))
+	for _, s := range services {
+		eps = append(eps, endpointEntry{
+			id:      s.ID,
+			name:    s.Name,
+			ip:      s.IP,
+			port:    s.Port,
+			meta:    s.Meta,
+			passing: true,
+		})
+	}
+	return eps
+}
+
+// toInstances merges the entries of the same instance, each protocol is an endpoint of the instance,
+// the entries registered by others have no protocol in metadata, then they are both rest and grpc endpoints
+func toInstances(entries []endpointEntry) []*chregistry.MicroServiceInstance {
+	instances := make([]*chregistry.MicroServiceInstance, 0, len(entries))
+	index := make(map[string]*chregistry.MicroServiceInstance, len(entries))
+	for _, e := range entries {
+		key := e.meta[MetaInstance]
+		if key == "" {
+			key = e.id
+		}
+		ins, ok := index[key]
+		if !ok {
+			ins = &chregistry.MicroServiceInstance{
+				InstanceID:   key,
+				ServiceID:    e.name,
+				HostName:     e.ip,
+				Status:       common.DefaultStatus,
+				EndpointsMap: make(map[string]string),
+				Metadata:     make(map[string]string, len(e.meta)),
+			}
+			index[key] = ins
+			instances = append(instances, ins)
+		}
+
+		for k, v := range e.meta {
+			ins.Metadata[k] = v
+		}
+		if h := e.meta[MetaHostName]; h != "" {
+			ins.HostName = h
+		}
+		if !e.passing {
+			ins.Status = StatusDown
+		}
+
+		ep := fmt.Sprintf("%s:%d", e.ip, e.port)
+		if p := e.meta[MetaProtocol]; p != "" {
+			ins.EndpointsMap[p] = ep
+		} else {
+			ins.EndpointsMap[common.ProtocolRest] = ep
+			ins.EndpointsMap[common.ProtocolGrpc] = ep
+		}
+	}
+
+	for _, ins := range instances {
+		ins.DefaultProtocol = defaultProtocol(ins.EndpointsMap)
+		ins.DefaultEndpoint = ins.EndpointsMap[ins.DefaultProtocol]
+		if region, zone := ins.Metadata[MetaRegion], ins.Metadata[MetaZone]; region != "" || zone != "" {
+			ins.DataCenterInfo = &chregistry.DataCenterInfo{
+				Name:          region,
+				Region:        region,
+				AvailableZone: zone,
+			}
+		}
+	}
+	return instances
+}
+
+// defaultProtocol prefers rest, otherwise the first protocol in order
+func defaultProtocol(eps map[string]string) string {
+	if _, ok := eps[common.ProtocolRest]; ok {
+		return common.ProtocolRest
+	}
+	protocols := make([]string, 0, len(eps))
+	for p := range eps {
+		protocols = append(protocols, p)
+	}
+	sort.Strings(protocols)
+	if len(protocols) == 0 {
+		return ""
+	}
+	return protocols[0]
+}
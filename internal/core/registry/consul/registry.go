@@ -2,24 +2,39 @@ package consul
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/leon-yc/ggs/pkg/qlog"
 
+	"github.com/hashicorp/consul/api"
 	qudiscovery "github.com/leon-gopher/discovery"
 	"github.com/leon-gopher/discovery/errors"
 	quregistry "github.com/leon-gopher/discovery/registry"
+	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/config"
 	chregistry "github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/pkg/runtime"
 	"github.com/leon-yc/ggs/internal/pkg/util/iputil"
 )
 
 const (
 	ServiceCenter     = "consul"
 	ConsulDegratePath = "/data/cache/ggs-consul/"
+
+	//TTLCheckPrefix is the prefix of the ttl check id, the check is passed by heartbeat
+	TTLCheckPrefix = "ggs-ttl:"
+	//MaintenanceReason is the reason of the maintenance mode when the instance status is not UP
+	MaintenanceReason = "ggs: instance status is "
+
+	deregisterCriticalAfter = "24h"
 )
 
+//ttl is 3 times of the heartbeat interval, so the instance is critical after 3 missed heartbeats
+var ttl = fmt.Sprintf("%ds", 3*common.DefaultHBInterval)
+
 func init() {
 	chregistry.InstallRegistrator(ServiceCenter, NewRegistrator)
 	chregistry.InstallServiceDiscovery(ServiceCenter, NewServiceDiscovery)
@@ -27,8 +42,22 @@ func init() {
 
 type Registrator struct {
 	Name         string
-	deRegistors  []qudiscovery.ServiceRegister
 	microService *chregistry.MicroService
+
+	mu            sync.Mutex
+	r             *qudiscovery.Registry
+	client        *api.Client
+	registrations []*registration
+}
+
+//registration is a registered consul service of a protocol
+type registration struct {
+	service *quregistry.Service
+	dereg   qudiscovery.ServiceRegister
+}
+
+func (reg *registration) checkID() string {
+	return TTLCheckPrefix + reg.service.ServiceID()
 }
 
 //NewRegistrator new Service center registrator
@@ -38,6 +67,45 @@ func NewRegistrator(options chregistry.Options) chregistry.Registrator {
 	}
 }
 
+//init creates the consul clients once
+func (re *Registrator) init() error {
+	if re.r != nil {
+		return nil
+	}
+	consulAddr := config.GetRegistratorAddress()
+	r, err := qudiscovery.NewRegistryWithConsulAndFile(consulAddr, ConsulDegratePath)
+	if err != nil {
+		return err
+	}
+	client, err := newConsulClient(consulAddr, nil)
+	if err != nil {
+		return err
+	}
+	re.r = r
+	re.client = client
+	return nil
+}
+
+//register registers the service and its ttl check
+func (re *Registrator) register(reg *registration) error {
+	dereg, err := re.r.Register(reg.service)
+	if err != nil {
+		return err
+	}
+	reg.dereg = dereg
+
+	return re.client.Agent().CheckRegister(&api.AgentCheckRegistration{
+		ID:        reg.checkID(),
+		Name:      reg.service.Name + " heartbeat",
+		ServiceID: reg.service.ServiceID(),
+		AgentServiceCheck: api.AgentServiceCheck{
+			TTL:                            ttl,
+			Status:                         api.HealthPassing,
+			DeregisterCriticalServiceAfter: deregisterCriticalAfter,
+		},
+	})
+}
+
 func (r *Registrator) RegisterService(microService *chregistry.MicroService) (string, error) {
 	r.microService = microService
 	return microService.ServiceName, nil
@@ -48,11 +116,9 @@ func (re *Registrator) RegisterServiceInstance(sid string, instance *chregistry.
 		return "", errors.New("endpoints is empty")
 	}
 
-	consulAddr := config.GetRegistratorAddress()
-
-	//r, err := qudiscovery.NewRegistryWithConsul(consulAddr)
-	r, err := qudiscovery.NewRegistryWithConsulAndFile(consulAddr, ConsulDegratePath)
-	if err != nil {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if err := re.init(); err != nil {
 		//handle err
 		return "", err
 	}
@@ -62,12 +128,19 @@ func (re *Registrator) RegisterServiceInstance(sid string, instance *chregistry.
 	// 1. 只注册一个服务,而且是grpc协议,注册的服务名不加 -grpc,
 	// 2. 同时注册rest,grpc服务,grpc的服务需要加上 -grpc
 	//2019-11-19 修改规则：rest不加后缀，其他协议的服务，要在服务名后面加上协议名，比如：
+	//可通过ggs.service.registry.registrator.nameTemplate修改规则, 例如 {service}-{protocol}
+	tmpl := config.GetRegistratorNameTemplate()
+	protocols := make([]string, 0, len(instance.EndpointsMap))
+	for k := range instance.EndpointsMap {
+		protocols = append(protocols, k)
+	}
+	sort.Strings(protocols)
+
 	instanceID := ""
-	for k, v := range instance.EndpointsMap {
-		serviceName := config.MicroserviceDefinition.ServiceDescription.Name
-		if k != "rest" {
-			serviceName = config.MicroserviceDefinition.ServiceDescription.Name + "-" + k
-		}
+	registrations := make([]*registration, 0, len(instance.EndpointsMap))
+	for _, k := range protocols {
+		v := instance.EndpointsMap[k]
+		serviceName := registeredName(tmpl, config.MicroserviceDefinition.ServiceDescription.Name, k)
 
 		ipPort := strings.Split(v, ":")
 		if len(ipPort) != 2 {
@@ -81,12 +154,15 @@ func (re *Registrator) RegisterServiceInstance(sid string, instance *chregistry.
 		} else {
 			ip = iputil.GetLocalIP()
 		}
-		instanceID = ip
-
 		port, err := strconv.Atoi(ipPort[1])
-		if err != err {
+		if err != nil {
 			return "", errors.Errorf("recover port failed %s ", ipPort[1])
 		}
+		//all protocols of the instance share the id, so the discovery can merge them,
+		//the ip:port of the first protocol is unique even if the processes run on the same host
+		if instanceID == "" {
+			instanceID = fmt.Sprintf("%s:%d", ip, port)
+		}
 
 		tags := []string{
 			k,
@@ -94,20 +170,23 @@ func (re *Registrator) RegisterServiceInstance(sid string, instance *chregistry.
 			config.MicroserviceDefinition.ServiceDescription.Environment,
 			config.MicroserviceDefinition.ServiceDescription.Version,
 		}
-		//注册服务
-		deRegistorService, err := r.Register(&quregistry.Service{
-			//服务名: 建议ops项目名，不能使用下换线且任何非url safe的字符
-			Name: serviceName,
-			//服务注册ip地址
-			IP: ip,
-			//服务端口
-			Port: port,
-			Tags: tags,
-			Meta: map[string]string{
-				"protoc": k,
+		reg := &registration{
+			service: &quregistry.Service{
+				//服务名: 建议ops项目名，不能使用下换线且任何非url safe的字符
+				Name: serviceName,
+				//服务注册ip地址
+				IP: ip,
+				//服务端口
+				Port: port,
+				Tags: tags,
+				Meta: registeredMeta(instanceID, k, instance),
 			},
-		},
-		)
+		}
+		//the default id of the sdk has no port, the processes on the same host would replace each other,
+		//the protocols may be registered with the same name, so the protocol is in the id too
+		reg.service.ID = fmt.Sprintf("%s~%s:%d~%s~%s", serviceName, ip, port, runtime.HostName, k)
+		//注册服务
+		err = re.register(reg)
 
 		//annotation: 注册多个，有失败就返回
 		if err != nil {
@@ -115,18 +194,38 @@ func (re *Registrator) RegisterServiceInstance(sid string, instance *chregistry.
 			return "", err
 		}
 
-		re.deRegistors = append(re.deRegistors, deRegistorService)
+		registrations = append(registrations, reg)
 	}
+	//re-register replaces the registrations, the service ids are the same
+	re.registrations = registrations
+	chregistry.HBService.AddTask(sid, instanceID)
 
 	return instanceID, nil
 }
 
+//RegisterServiceAndInstance registers the instance of the service, consul has no service without instances
 func (r *Registrator) RegisterServiceAndInstance(microService *chregistry.MicroService, instance *chregistry.MicroServiceInstance) (string, string, error) {
-	return "", "", nil
+	sid, err := r.RegisterService(microService)
+	if err != nil {
+		return "", "", err
+	}
+	instanceID, err := r.RegisterServiceInstance(sid, instance)
+	if err != nil {
+		return "", "", err
+	}
+	return sid, instanceID, nil
 }
 
+//Heartbeat passes the ttl checks of the registered services
 func (r *Registrator) Heartbeat(microServiceID, microServiceInstanceID string) (bool, error) {
-	return false, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reg := range r.registrations {
+		if err := r.client.Agent().UpdateTTL(reg.checkID(), "", api.HealthPassing); err != nil {
+			return false, fmt.Errorf("heartbeat of %s failed: %s", reg.service.ServiceID(), err)
+		}
+	}
+	return true, nil
 }
 
 func (r *Registrator) AddDependencies(dep *chregistry.MicroServiceDependency) error {
@@ -134,27 +233,65 @@ func (r *Registrator) AddDependencies(dep *chregistry.MicroServiceDependency) er
 }
 
 func (r *Registrator) UnRegisterMicroServiceInstance(microServiceID, microServiceInstanceID string) (err error) {
-	for _, v := range r.deRegistors {
-		err = v.Deregister()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.registrations {
+		err = v.dereg.Deregister()
 		if err != nil {
 			qlog.Errorf("deregister service failed ")
 		}
 
 		qlog.Info("deregister service success")
 	}
+	r.registrations = nil
+	chregistry.HBService.RemoveTask(microServiceID, microServiceInstanceID)
 
 	return nil
 }
 
+//UpdateMicroServiceInstanceStatus puts the registered services into maintenance mode when the status is not UP,
+//so they are removed from the discovery before shutdown, and takes them out of maintenance mode when it is UP
 func (r *Registrator) UpdateMicroServiceInstanceStatus(microServiceID, microServiceInstanceID, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reg := range r.registrations {
+		var err error
+		if status == common.DefaultStatus {
+			err = r.client.Agent().DisableServiceMaintenance(reg.service.ServiceID())
+		} else {
+			err = r.client.Agent().EnableServiceMaintenance(reg.service.ServiceID(), MaintenanceReason+status)
+		}
+		if err != nil {
+			return fmt.Errorf("update status of %s to %s failed: %s", reg.service.ServiceID(), status, err)
+		}
+	}
+	qlog.Infof("update instance status to %s success", status)
 	return nil
 }
 
+//UpdateMicroServiceProperties updates the metadata of the registered services,
+//consul has no service level metadata, so it is the same as UpdateMicroServiceInstanceProperties
 func (r *Registrator) UpdateMicroServiceProperties(microServiceID string, properties map[string]string) error {
-	return nil
+	return r.UpdateMicroServiceInstanceProperties(microServiceID, "", properties)
 }
 
+//UpdateMicroServiceInstanceProperties merges the properties into the metadata of the registered services,
+//and registers them again
 func (r *Registrator) UpdateMicroServiceInstanceProperties(microServiceID, microServiceInstanceID string, properties map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reg := range r.registrations {
+		for k, v := range properties {
+			if !metaKeyRegexp.MatchString(k) {
+				qlog.Warnf("property %s is not registered to consul, the key must be letters, digits, - or _", k)
+				continue
+			}
+			reg.service.Meta[k] = v
+		}
+		if err := r.register(reg); err != nil {
+			return fmt.Errorf("update metadata of %s failed: %s", reg.service.ServiceID(), err)
+		}
+	}
 	return nil
 }
 
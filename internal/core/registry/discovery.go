@@ -2,6 +2,9 @@ package registry
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/leon-yc/ggs/internal/core/config"
 	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
@@ -24,7 +27,11 @@ func NewDiscovery(name string, opts Options) (ServiceDiscovery, error) {
 	if f == nil {
 		return nil, fmt.Errorf("no service discovery plugin: %s", name)
 	}
-	return f(opts), nil
+	sd := f(opts)
+	if sd == nil {
+		return nil, fmt.Errorf("create %s service discovery failed", name)
+	}
+	return sd, nil
 }
 
 //InstallContractDiscovery install contract service client
@@ -47,6 +54,106 @@ type ServiceDiscovery interface {
 //DefaultServiceDiscoveryService supplies service discovery
 var DefaultServiceDiscoveryService ServiceDiscovery
 
+// referenceRetryInterval is the time to wait before creating a failed reference discovery again
+const referenceRetryInterval = 10 * time.Second
+
+// referenceDiscovery is the discovery of ggs.references.<service>.discovery, or the error to create it,
+// done is closed once the creation finished
+type referenceDiscovery struct {
+	done     chan struct{}
+	sd       ServiceDiscovery
+	err      error
+	failedAt time.Time
+}
+
+// the discovery plugins used by ggs.references.<service>.discovery, created on first use,
+// the key is the plugin name, the address and the tenant
+var (
+	referenceMu          sync.Mutex
+	referenceDiscoveries = make(map[string]*referenceDiscovery)
+)
+
+//HasReferenceDiscovery returns true if the service has its own discovery plugin in ggs.references.<service>.discovery
+func HasReferenceDiscovery(microServiceName string) bool {
+	return config.GetReferenceDiscovery(microServiceName) != ""
+}
+
+//GetServiceDiscovery returns the discovery of the service, ggs.references.<service>.discovery overrides the default one
+func GetServiceDiscovery(microServiceName string) (ServiceDiscovery, error) {
+	t := config.GetReferenceDiscovery(microServiceName)
+	def := config.GetServiceDiscoveryType()
+	if def == "" {
+		def = DefaultServiceDiscoveryPlugin
+	}
+	addr := config.GetReferenceDiscoveryAddress(microServiceName)
+	tenant := config.GetReferenceDiscoveryTenant(microServiceName)
+	if t == "" || (t == def && addr == "" && tenant == "" && DefaultServiceDiscoveryService != nil) {
+		if DefaultServiceDiscoveryService == nil {
+			return nil, fmt.Errorf("service discovery is disabled, service: %s", microServiceName)
+		}
+		return DefaultServiceDiscoveryService, nil
+	}
+
+	key := strings.Join([]string{t, addr, tenant}, "|")
+	referenceMu.Lock()
+	r, ok := referenceDiscoveries[key]
+	if ok {
+		select {
+		case <-r.done:
+			if r.sd == nil && time.Since(r.failedAt) >= referenceRetryInterval {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		referenceMu.Unlock()
+		<-r.done
+		return r.sd, r.err
+	}
+	// the plugin may dial its server, create it without the lock, the callers of the same key wait for done
+	r = &referenceDiscovery{done: make(chan struct{})}
+	referenceDiscoveries[key] = r
+	referenceMu.Unlock()
+
+	sd, err := newReferenceDiscovery(t, microServiceName)
+	if err != nil {
+		qlog.Errorf("Enable %s service discovery for %s failed: %s", t, microServiceName, err)
+	} else {
+		sd.AutoSync()
+		qlog.Infof("Enable %s service discovery for %s.", t, microServiceName)
+	}
+	referenceMu.Lock()
+	r.sd, r.err = sd, err
+	if err != nil {
+		r.failedAt = time.Now()
+	}
+	referenceMu.Unlock()
+	close(r.done)
+	return sd, err
+}
+
+// newReferenceDiscovery creates the discovery with the address and tenant in ggs.references.<service>,
+// the address with https scheme uses the ssl config of serviceDiscovery.<service>
+func newReferenceDiscovery(t, microServiceName string) (ServiceDiscovery, error) {
+	var opts Options
+	if addr := config.GetReferenceDiscoveryAddress(microServiceName); addr != "" {
+		hosts, scheme, err := URIs2Hosts(strings.Split(addr, ","))
+		if err != nil {
+			return nil, err
+		}
+		opts.Addrs = hosts
+		opts.TLSConfig, err = getTLSConfig(scheme, SDTag+"."+microServiceName)
+		if err != nil {
+			return nil, err
+		}
+		opts.EnableSSL = opts.TLSConfig != nil
+	}
+	opts.Tenant = config.GetReferenceDiscoveryTenant(microServiceName)
+	opts.ConfigPath = config.GetServiceDiscoveryConfigPath()
+	return NewDiscovery(t, opts)
+}
+
 // DefaultContractDiscoveryService supplies contract discovery
 var DefaultContractDiscoveryService ContractDiscovery
 
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+)
+
+type stubDiscovery struct {
+	ServiceDiscovery
+	addr string
+}
+
+func (s *stubDiscovery) AutoSync()    {}
+func (s *stubDiscovery) Close() error { return nil }
+
+func TestReferenceDiscoveryCreatedOutsideLock(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var created int32
+	InstallServiceDiscovery("stub", func(opts Options) ServiceDiscovery {
+		atomic.AddInt32(&created, 1)
+		if opts.Addrs[0] == "slow:1" {
+			close(entered)
+			<-release
+		}
+		return &stubDiscovery{addr: opts.Addrs[0]}
+	})
+	defer delete(sdFunc, "stub")
+	for service, addr := range map[string]string{"slow1": "slow:1", "slow2": "slow:1", "fast": "fast:1"} {
+		archaius.Set("ggs.references."+service+".discovery", "stub")
+		archaius.Set("ggs.references."+service+".discoveryAddress", "http://"+addr)
+	}
+
+	results := make(chan ServiceDiscovery, 2)
+	for _, service := range []string{"slow1", "slow2"} {
+		go func(service string) {
+			sd, err := GetServiceDiscovery(service)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- sd
+		}(service)
+	}
+	<-entered
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sd, err := GetServiceDiscovery("fast")
+		if err != nil || sd.(*stubDiscovery).addr != "fast:1" {
+			t.Errorf("fast discovery: %v, %v", sd, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("creating another discovery is blocked by the slow one")
+	}
+
+	close(release)
+	sd1, sd2 := <-results, <-results
+	if sd1 == nil || sd1 != sd2 {
+		t.Errorf("the references with the same address should share the discovery, got %v and %v", sd1, sd2)
+	}
+	if n := atomic.LoadInt32(&created); n != 2 {
+		t.Errorf("expected 2 discoveries created, got %d", n)
+	}
+}
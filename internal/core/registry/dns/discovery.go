@@ -0,0 +1,384 @@
+// Package dns is a service discovery plugin which resolves the SRV or A/AAAA records of the services,
+// the records are refreshed in background when their ttl expires
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// Name is the name of the plugin
+const Name = "dns"
+
+// record types of ggs.references.<service>.dns.type
+const (
+	TypeSRV = "srv"
+	TypeA   = "a"
+)
+
+const (
+	//DefaultQueryTimeout is the timeout of a dns query
+	DefaultQueryTimeout = 5 * time.Second
+	//DefaultPort is the port of the A/AAAA records
+	DefaultPort = 80
+
+	minRefreshInterval = 1 * time.Second
+	maxRefreshInterval = 5 * time.Minute
+	minRetryInterval   = 1 * time.Second
+	maxRetryInterval   = 30 * time.Second
+)
+
+// the SRV services of the protocols, e.g. _grpc._tcp.<name>
+var srvServices = map[string]string{
+	common.ProtocolRest: "http",
+	common.ProtocolGrpc: "grpc",
+}
+
+// Discovery resolves the referenced services and refreshes them by the ttl of the records
+type Discovery struct {
+	resolver *resolver
+
+	mu       sync.RWMutex
+	services map[string]*serviceRecord
+	closed   bool
+}
+
+// serviceRecord keeps the last resolved instances of a service
+type serviceRecord struct {
+	name   string
+	cancel context.CancelFunc
+
+	mu        sync.RWMutex
+	instances []*registry.MicroServiceInstance
+}
+
+// serviceOptions is ggs.references.<service>.dns
+type serviceOptions struct {
+	name  string
+	typ   string
+	port  int
+	proto string
+}
+
+func getServiceOptions(microServiceName string) serviceOptions {
+	prefix := "ggs.references." + microServiceName + ".dns."
+	return serviceOptions{
+		name:  archaius.GetString(prefix+"name", microServiceName),
+		typ:   strings.ToLower(archaius.GetString(prefix+"type", "")),
+		port:  archaius.GetInt(prefix+"port", DefaultPort),
+		proto: archaius.GetString(prefix+"protocol", ""),
+	}
+}
+
+// newDiscovery creates the discovery of the name servers in the options, like the serviceDiscovery.address or
+// ggs.references.<service>.discoveryAddress, /etc/resolv.conf is used if it is empty
+func newDiscovery(opts registry.Options) registry.ServiceDiscovery {
+	return NewDiscovery(registry.OwnAddrs(opts)...)
+}
+
+// NewDiscovery creates the discovery of the name servers like 10.0.0.10:53
+func NewDiscovery(servers ...string) *Discovery {
+	return &Discovery{
+		resolver: newResolver(servers),
+		services: make(map[string]*serviceRecord),
+	}
+}
+
+// FindMicroServiceInstances returns the instances which have the tags, the service is resolved
+// when it is referenced for the first time
+func (d *Discovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
+	r, err := d.serviceRecord(microServiceName)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	all := r.instances
+	r.mu.RUnlock()
+	if len(tags.KV) == 0 {
+		return all, nil
+	}
+	instances := make([]*registry.MicroServiceInstance, 0, len(all))
+	for _, ins := range all {
+		if ins.Has(tags.KV) {
+			instances = append(instances, ins)
+		}
+	}
+	return instances, nil
+}
+
+// serviceRecord returns the record of the service, it resolves the service and starts the refresher for the first time
+func (d *Discovery) serviceRecord(microServiceName string) (*serviceRecord, error) {
+	d.mu.RLock()
+	r, ok := d.services[microServiceName]
+	d.mu.RUnlock()
+	if ok {
+		return r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	instances, ttl, err := d.resolve(ctx, microServiceName)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, fmt.Errorf("dns discovery is closed")
+	}
+	if r, ok := d.services[microServiceName]; ok {
+		return r, nil
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	r = &serviceRecord{name: microServiceName, cancel: cancel}
+	d.services[microServiceName] = r
+	d.set(r, instances)
+	go d.refresh(ctx, r, ttl)
+	return r, nil
+}
+
+func (d *Discovery) set(r *serviceRecord, instances []*registry.MicroServiceInstance) {
+	r.mu.Lock()
+	r.instances = instances
+	r.mu.Unlock()
+	registry.SetInstances(r.name, instances)
+}
+
+// refresh resolves the service again when the ttl expires, and keeps the last known instances on errors
+func (d *Discovery) refresh(ctx context.Context, r *serviceRecord, ttl time.Duration) {
+	retry := minRetryInterval
+	wait := ttl
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		qctx, cancel := context.WithTimeout(ctx, DefaultQueryTimeout)
+		instances, ttl, err := d.resolve(qctx, r.name)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			qlog.Warnf("resolve service %s failed, keep the last known instances, retry after %s: %s", r.name, retry, err)
+			wait = retry
+			retry *= 2
+			if retry > maxRetryInterval {
+				retry = maxRetryInterval
+			}
+			continue
+		}
+		retry = minRetryInterval
+		wait = ttl
+		d.set(r, instances)
+		qlog.Tracef("service %s resolved, %d instances, next refresh after %s", r.name, len(instances), ttl)
+	}
+}
+
+// resolve returns the instances of the service and the time to refresh them,
+// the SRV records of the protocols are used first, then the A/AAAA records
+func (d *Discovery) resolve(ctx context.Context, microServiceName string) ([]*registry.MicroServiceInstance, time.Duration, error) {
+	opts := getServiceOptions(microServiceName)
+	var (
+		instances []*registry.MicroServiceInstance
+		ttl       uint32
+		err       error
+	)
+	switch opts.typ {
+	case TypeSRV:
+		instances, ttl, err = d.resolveSRV(ctx, opts)
+	case TypeA:
+		instances, ttl, err = d.resolveHost(ctx, opts)
+	case "":
+		instances, ttl, err = d.resolveSRV(ctx, opts)
+		if err != nil || len(instances) == 0 {
+			instances, ttl, err = d.resolveHost(ctx, opts)
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown dns record type %s of service %s", opts.typ, microServiceName)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	interval := time.Duration(ttl) * time.Second
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+	return instances, interval, nil
+}
+
+// resolveSRV resolves _<service>._tcp.<name> of each protocol, or the name itself if it is a SRV name,
+// only the targets of the lowest priority are used
+func (d *Discovery) resolveSRV(ctx context.Context, opts serviceOptions) ([]*registry.MicroServiceInstance, uint32, error) {
+	names := make(map[string]string)
+	if strings.HasPrefix(opts.name, "_") {
+		names[opts.proto] = opts.name
+	} else {
+		for proto, service := range srvServices {
+			if opts.proto == "" || opts.proto == proto {
+				names[proto] = "_" + service + "._tcp." + opts.name
+			}
+		}
+	}
+
+	b := newInstanceBuilder()
+	ttl := uint32(0)
+	var lastErr error
+	for proto, name := range names {
+		targets, t, err := d.resolver.lookupSRV(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		ttl = minTTL(ttl, t)
+		lowest := targets[0].priority
+		for _, t := range targets {
+			if t.priority < lowest {
+				lowest = t.priority
+			}
+		}
+		for _, t := range targets {
+			if t.priority == lowest {
+				b.add(proto, t.ip, int(t.port), t.weight)
+			}
+		}
+	}
+	if len(b.instances) == 0 {
+		return nil, 0, lastErr
+	}
+	return b.instances, ttl, nil
+}
+
+// resolveHost resolves the A/AAAA records of the name, the port is ggs.references.<service>.dns.port
+func (d *Discovery) resolveHost(ctx context.Context, opts serviceOptions) ([]*registry.MicroServiceInstance, uint32, error) {
+	ips, ttl, err := d.resolver.lookupHost(ctx, opts.name)
+	if err != nil {
+		return nil, 0, err
+	}
+	b := newInstanceBuilder()
+	for _, ip := range ips {
+		b.add(opts.proto, ip, opts.port, 0)
+	}
+	return b.instances, ttl, nil
+}
+
+// instanceBuilder merges the addresses of the same ip into an instance, each protocol is an endpoint,
+// the addresses without protocol are both rest and grpc endpoints
+type instanceBuilder struct {
+	instances []*registry.MicroServiceInstance
+	index     map[string]*registry.MicroServiceInstance
+}
+
+func newInstanceBuilder() *instanceBuilder {
+	return &instanceBuilder{index: make(map[string]*registry.MicroServiceInstance)}
+}
+
+// add adds the endpoint to the instance of the ip, the srv weight is the weight of the instance if it is not 0
+func (b *instanceBuilder) add(proto, ip string, port int, weight uint16) {
+	protos := []string{proto}
+	if proto == "" {
+		protos = []string{common.ProtocolRest, common.ProtocolGrpc}
+	}
+	ep := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	// another port of the same protocol on the ip is another instance
+	key := ip
+	if ins, ok := b.index[key]; ok {
+		if _, ok := ins.EndpointsMap[protos[0]]; ok {
+			key = ep
+		}
+	}
+	ins, ok := b.index[key]
+	if !ok {
+		ins = &registry.MicroServiceInstance{
+			InstanceID:   key,
+			HostName:     ip,
+			Status:       common.DefaultStatus,
+			EndpointsMap: make(map[string]string),
+			Metadata:     make(map[string]string),
+		}
+		b.index[key] = ins
+		b.instances = append(b.instances, ins)
+	}
+	for _, p := range protos {
+		ins.EndpointsMap[p] = ep
+	}
+	if weight > 0 {
+		ins.Metadata[registry.MetaWeight] = strconv.Itoa(int(weight))
+	}
+	if _, ok := ins.EndpointsMap[common.ProtocolRest]; ok {
+		ins.DefaultProtocol = common.ProtocolRest
+	} else {
+		ins.DefaultProtocol = protos[0]
+	}
+	ins.DefaultEndpoint = ins.EndpointsMap[ins.DefaultProtocol]
+}
+
+// GetMicroServiceID returns the service name as its id
+func (d *Discovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
+	return microServiceName, nil
+}
+
+// GetAllMicroServices returns the referenced services
+func (d *Discovery) GetAllMicroServices() ([]*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make([]*registry.MicroService, 0, len(d.services))
+	for name := range d.services {
+		services = append(services, &registry.MicroService{ServiceID: name, ServiceName: name, Status: common.DefaultStatus})
+	}
+	return services, nil
+}
+
+// GetMicroService returns the service if it can be resolved
+func (d *Discovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	if _, err := d.serviceRecord(microServiceID); err != nil {
+		return nil, err
+	}
+	return &registry.MicroService{ServiceID: microServiceID, ServiceName: microServiceID, Status: common.DefaultStatus}, nil
+}
+
+// GetMicroServiceInstances returns the instances of the provider
+func (d *Discovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
+	return d.FindMicroServiceInstances(consumerID, providerID, utiltags.Tags{})
+}
+
+// AutoSync is noop, the services are refreshed when they are referenced
+func (d *Discovery) AutoSync() {}
+
+// Close stops the refreshers
+func (d *Discovery) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range d.services {
+		r.cancel()
+	}
+	d.services = make(map[string]*serviceRecord)
+	d.closed = true
+	return nil
+}
+
+func init() {
+	registry.InstallServiceDiscovery(Name, newDiscovery)
+}
@@ -0,0 +1,322 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// errNotFound means the name has no records of the type
+var errNotFound = errors.New("no such record")
+
+// srvTarget is an address of a SRV record
+type srvTarget struct {
+	ip       string
+	port     uint16
+	priority uint16
+	weight   uint16
+}
+
+// resolver is a minimal stub resolver which returns the ttl of the records,
+// the name servers and search domains are read from /etc/resolv.conf by default
+type resolver struct {
+	servers []string
+	search  []string
+	ndots   int
+	timeout time.Duration
+}
+
+// newResolver creates the resolver, the servers override the name servers of /etc/resolv.conf
+func newResolver(servers []string) *resolver {
+	r := &resolver{
+		ndots:   1,
+		timeout: DefaultQueryTimeout,
+	}
+	r.loadConf(resolvConf)
+	if len(servers) > 0 {
+		r.servers = r.servers[:0]
+		for _, s := range servers {
+			if s = strings.TrimSpace(s); s != "" {
+				r.servers = append(r.servers, withPort(s))
+			}
+		}
+	}
+	if len(r.servers) == 0 {
+		r.servers = []string{"127.0.0.1:53"}
+	}
+	return r
+}
+
+func (r *resolver) loadConf(path string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") || strings.HasPrefix(f[0], ";") {
+			continue
+		}
+		switch f[0] {
+		case "nameserver":
+			r.servers = append(r.servers, withPort(f[1]))
+		case "domain":
+			r.search = f[1:2]
+		case "search":
+			r.search = f[1:]
+		case "options":
+			for _, o := range f[1:] {
+				if strings.HasPrefix(o, "ndots:") {
+					if n, err := strconv.Atoi(o[len("ndots:"):]); err == nil {
+						r.ndots = n
+					}
+				}
+			}
+		}
+	}
+}
+
+func withPort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, "53")
+}
+
+// names returns the fully qualified names to query in order, like the search rules of the libc resolver
+func (r *resolver) names(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+	names := make([]string, 0, len(r.search)+1)
+	dots := strings.Count(name, ".") >= r.ndots
+	if dots {
+		names = append(names, name+".")
+	}
+	for _, s := range r.search {
+		names = append(names, name+"."+strings.TrimSuffix(s, ".")+".")
+	}
+	if !dots {
+		names = append(names, name+".")
+	}
+	return names
+}
+
+// query returns the answers of the first name which has records of the type, the ttl is the min ttl of the answers
+func (r *resolver) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, *dnsmessage.Message, error) {
+	var lastErr error = errNotFound
+	for _, n := range r.names(name) {
+		m, err := r.exchangeAny(ctx, n, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if m.RCode == dnsmessage.RCodeNameError {
+			continue
+		}
+		if m.RCode != dnsmessage.RCodeSuccess {
+			lastErr = fmt.Errorf("query %s failed, rcode: %s", n, m.RCode)
+			continue
+		}
+		answers := make([]dnsmessage.Resource, 0, len(m.Answers))
+		for _, a := range m.Answers {
+			if a.Header.Type == qtype {
+				answers = append(answers, a)
+			}
+		}
+		if len(answers) > 0 {
+			return answers, m, nil
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// exchangeAny asks the servers in order until one responds
+func (r *resolver) exchangeAny(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	var lastErr error
+	for _, s := range r.servers {
+		m, err := r.exchange(ctx, s, name, qtype)
+		if err == nil {
+			return m, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// exchange sends the query by udp, and again by tcp if the response is truncated
+func (r *resolver) exchange(ctx context.Context, server, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: n, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := r.roundTrip(ctx, "udp", server, b)
+	if err == nil && m.Header.Truncated {
+		m, err = r.roundTrip(ctx, "tcp", server, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Header.ID != id || !m.Header.Response {
+		return nil, fmt.Errorf("invalid response of %s from %s", name, server)
+	}
+	return m, nil
+}
+
+func (r *resolver) roundTrip(ctx context.Context, network, server string, b []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var resp []byte
+	if network == "tcp" {
+		req := make([]byte, 2+len(b))
+		binary.BigEndian.PutUint16(req, uint16(len(b)))
+		copy(req[2:], b)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		resp = make([]byte, 65535)
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, err
+		}
+		resp = resp[:n]
+	}
+
+	m := &dnsmessage.Message{}
+	if err := m.Unpack(resp); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// lookupHost returns the A and AAAA addresses of the name
+func (r *resolver) lookupHost(ctx context.Context, name string) ([]string, uint32, error) {
+	var ips []string
+	ttl := uint32(0)
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, _, err := r.query(ctx, name, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, a := range answers {
+			if ip := resourceIP(a); ip != "" {
+				ips = append(ips, ip)
+				ttl = minTTL(ttl, a.Header.TTL)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = errNotFound
+		}
+		return nil, 0, fmt.Errorf("lookup %s failed: %s", name, lastErr)
+	}
+	return ips, ttl, nil
+}
+
+// lookupSRV returns the addresses of the SRV records of the name, the addresses of the targets are taken
+// from the additional section if possible
+func (r *resolver) lookupSRV(ctx context.Context, name string) ([]srvTarget, uint32, error) {
+	answers, m, err := r.query(ctx, name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, fmt.Errorf("lookup SRV %s failed: %s", name, err)
+	}
+	additional := make(map[string][]string)
+	for _, a := range m.Additionals {
+		if ip := resourceIP(a); ip != "" {
+			key := strings.ToLower(a.Header.Name.String())
+			additional[key] = append(additional[key], ip)
+		}
+	}
+
+	ttl := uint32(0)
+	var targets []srvTarget
+	for _, a := range answers {
+		srv, ok := a.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		ttl = minTTL(ttl, a.Header.TTL)
+		host := srv.Target.String()
+		ips, ok := additional[strings.ToLower(host)]
+		if !ok {
+			var hostTTL uint32
+			ips, hostTTL, err = r.lookupHost(ctx, host)
+			if err != nil {
+				return nil, 0, err
+			}
+			ttl = minTTL(ttl, hostTTL)
+		}
+		for _, ip := range ips {
+			targets = append(targets, srvTarget{ip: ip, port: srv.Port, priority: srv.Priority, weight: srv.Weight})
+		}
+	}
+	return targets, ttl, nil
+}
+
+func resourceIP(a dnsmessage.Resource) string {
+	switch b := a.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	}
+	return ""
+}
+
+// minTTL returns the smaller ttl, 0 means unset
+func minTTL(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
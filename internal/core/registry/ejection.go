@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"sync"
+
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// the sources which eject the instances
+const (
+	EjectByHealthCheck      = "healthcheck"
+	EjectByOutlierDetection = "outlier"
+)
+
+// ejectedInstance is an instance ejected by one or more sources
+type ejectedInstance struct {
+	sources map[string]bool
+}
+
+var (
+	ejectMu sync.RWMutex
+	// service name to instance id to the ejection
+	ejected = make(map[string]map[string]*ejectedInstance)
+)
+
+//EjectInstance ejects the instance of the service by the source, it is kept in the registry cache but not picked
+//by the load balancer, it returns true if the instance was not ejected before
+func EjectInstance(service string, instance *MicroServiceInstance, source string) bool {
+	ejectMu.Lock()
+	e, ok := ejected[service][instance.InstanceID]
+	if !ok {
+		if ejected[service] == nil {
+			ejected[service] = make(map[string]*ejectedInstance)
+		}
+		e = &ejectedInstance{sources: make(map[string]bool)}
+		ejected[service][instance.InstanceID] = e
+	}
+	e.sources[source] = true
+	ejectMu.Unlock()
+	if ok {
+		return false
+	}
+	qlog.Warnf("instance %s of service %s is ejected by %s", instance.InstanceID, service, source)
+	return true
+}
+
+//ReadmitInstance cancels the ejection of the source, the instance is picked by the load balancer again
+//when no source ejects it, it returns true if the instance is readmitted
+func ReadmitInstance(service string, instance *MicroServiceInstance, source string) bool {
+	if !clearEjection(service, instance.InstanceID, source) {
+		return false
+	}
+	qlog.Infof("instance %s of service %s is readmitted by %s", instance.InstanceID, service, source)
+	return true
+}
+
+//ForgetEjectedInstance cancels the ejection of the source without reporting the readmission,
+//it is used when the instance is removed from the registry
+func ForgetEjectedInstance(service, instanceID, source string) {
+	clearEjection(service, instanceID, source)
+}
+
+// clearEjection removes the source of the ejection, it returns true if no source ejects the instance any more
+func clearEjection(service, instanceID, source string) bool {
+	ejectMu.Lock()
+	defer ejectMu.Unlock()
+	e, ok := ejected[service][instanceID]
+	if !ok || !e.sources[source] {
+		return false
+	}
+	delete(e.sources, source)
+	if len(e.sources) > 0 {
+		return false
+	}
+	delete(ejected[service], instanceID)
+	if len(ejected[service]) == 0 {
+		delete(ejected, service)
+	}
+	return true
+}
+
+//IsEjected returns true if the instance of the service is ejected
+func IsEjected(service, instanceID string) bool {
+	ejectMu.RLock()
+	defer ejectMu.RUnlock()
+	_, ok := ejected[service][instanceID]
+	return ok
+}
+
+//EjectedCount returns the number of the ejected instances of the service
+func EjectedCount(service string) int {
+	ejectMu.RLock()
+	defer ejectMu.RUnlock()
+	return len(ejected[service])
+}
+
+//FindAllInstances returns all instances of the service in the registry, including the ejected ones
+func FindAllInstances(service string) ([]*MicroServiceInstance, error) {
+	sd, err := GetServiceDiscovery(service)
+	if err != nil {
+		return nil, err
+	}
+	return sd.FindMicroServiceInstances("", service, utiltags.Tags{})
+}
+
+//FilterEjected returns the instances which are not ejected, all instances are returned if all of them are ejected,
+//so the service is still callable when the checks are wrong
+func FilterEjected(service string, instances []*MicroServiceInstance) []*MicroServiceInstance {
+	ejectMu.RLock()
+	defer ejectMu.RUnlock()
+	e := ejected[service]
+	if len(e) == 0 {
+		return instances
+	}
+	healthy := make([]*MicroServiceInstance, 0, len(instances))
+	for _, ins := range instances {
+		if _, ok := e[ins.InstanceID]; !ok {
+			healthy = append(healthy, ins)
+		}
+	}
+	if len(healthy) == 0 {
+		return instances
+	}
+	return healthy
+}
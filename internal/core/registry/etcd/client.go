@@ -0,0 +1,272 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errCompacted means the revision of the watch is compacted, the watch must start from a new range
+var errCompacted = fmt.Errorf("revision is compacted")
+
+// client is a minimal client of the etcd v3 json gateway
+type client struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints []string
+	current   int
+}
+
+// newClient creates the client of the addresses like 127.0.0.1:2379
+func newClient(addrs []string, tlsConfig *tls.Config) (*client, error) {
+	scheme := "http://"
+	c := &client{client: &http.Client{}}
+	if tlsConfig != nil {
+		scheme = "https://"
+		c.client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}
+	}
+	for _, addr := range addrs {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, "://") {
+			addr = scheme + addr
+		}
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(addr, "/"))
+	}
+	if len(c.endpoints) == 0 {
+		return nil, fmt.Errorf("etcd address is empty")
+	}
+	return c, nil
+}
+
+func (c *client) endpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoints[c.current]
+}
+
+// next switches to the next endpoint after a failure
+func (c *client) next(failed string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.endpoints[c.current] == failed {
+		c.current = (c.current + 1) % len(c.endpoints)
+	}
+}
+
+// do posts the request, and tries the next endpoint if the current one is unreachable
+func (c *client) do(ctx context.Context, path string, req interface{}) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for i := 0; i < len(c.endpoints); i++ {
+		ep := c.endpoint()
+		r, err := http.NewRequest(http.MethodPost, ep+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r = r.WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			c.next(ep)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			b, _ := ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("POST %s failed, status: %d, body: %s", path, resp.StatusCode, b)
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (c *client) call(ctx context.Context, path string, req, resp interface{}) error {
+	r, err := c.do(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// the json gateway encodes the bytes in base64, and the int64 in strings
+
+type header struct {
+	Revision int64 `json:"revision,string"`
+}
+
+type keyValue struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+	Lease       int64  `json:"lease,string"`
+}
+
+type event struct {
+	Type string   `json:"type"`
+	Kv   keyValue `json:"kv"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// prefixEnd returns the range end of the prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// grant creates a lease of the ttl in seconds
+func (c *client) grant(ctx context.Context, ttl int64) (int64, error) {
+	req := struct {
+		TTL int64 `json:"TTL,string"`
+	}{ttl}
+	resp := struct {
+		ID    int64  `json:"ID,string"`
+		Error string `json:"error"`
+	}{}
+	if err := c.call(ctx, "/v3/lease/grant", req, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("grant lease failed: %s", resp.Error)
+	}
+	return resp.ID, nil
+}
+
+// keepAlive renews the lease once, and returns the remaining ttl, 0 means the lease is expired
+func (c *client) keepAlive(ctx context.Context, id int64) (int64, error) {
+	req := struct {
+		ID int64 `json:"ID,string"`
+	}{id}
+	resp := struct {
+		Result *struct {
+			TTL int64 `json:"TTL,string"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}{}
+	if err := c.call(ctx, "/v3/lease/keepalive", req, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, fmt.Errorf("keepalive lease %x failed: %s", id, resp.Error.Message)
+	}
+	if resp.Result == nil {
+		return 0, nil
+	}
+	return resp.Result.TTL, nil
+}
+
+// revoke revokes the lease, and the keys of the lease are deleted
+func (c *client) revoke(ctx context.Context, id int64) error {
+	req := struct {
+		ID int64 `json:"ID,string"`
+	}{id}
+	return c.call(ctx, "/v3/lease/revoke", req, &struct{}{})
+}
+
+// put puts the key with the lease
+func (c *client) put(ctx context.Context, key string, value []byte, lease int64) error {
+	req := struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+		Lease int64  `json:"lease,string,omitempty"`
+	}{[]byte(key), value, lease}
+	return c.call(ctx, "/v3/kv/put", req, &struct{}{})
+}
+
+// rangePrefix returns the keys of the prefix and the revision of the store
+func (c *client) rangePrefix(ctx context.Context, prefix string) ([]keyValue, int64, error) {
+	req := struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}{[]byte(prefix), prefixEnd(prefix)}
+	resp := struct {
+		Header header     `json:"header"`
+		Kvs    []keyValue `json:"kvs"`
+	}{}
+	if err := c.call(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+// watchPrefix watches the prefix from the revision until the stream ends, it returns the next revision to watch
+func (c *client) watchPrefix(ctx context.Context, prefix string, rev int64, f func([]event)) (int64, error) {
+	type createRequest struct {
+		Key           []byte `json:"key"`
+		RangeEnd      []byte `json:"range_end"`
+		StartRevision int64  `json:"start_revision,string"`
+	}
+	req := struct {
+		CreateRequest createRequest `json:"create_request"`
+	}{createRequest{[]byte(prefix), prefixEnd(prefix), rev}}
+	resp, err := c.do(ctx, "/v3/watch", req)
+	if err != nil {
+		return rev, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result *struct {
+				Header          header  `json:"header"`
+				Canceled        bool    `json:"canceled"`
+				CompactRevision int64   `json:"compact_revision,string"`
+				CancelReason    string  `json:"cancel_reason"`
+				Events          []event `json:"events"`
+			} `json:"result"`
+			Error *rpcError `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return rev, ctx.Err()
+			}
+			return rev, fmt.Errorf("watch %s closed: %s", prefix, err)
+		}
+		if msg.Error != nil {
+			return rev, fmt.Errorf("watch %s failed: %s", prefix, msg.Error.Message)
+		}
+		if msg.Result == nil {
+			continue
+		}
+		if msg.Result.CompactRevision != 0 {
+			return rev, errCompacted
+		}
+		if msg.Result.Canceled {
+			return rev, fmt.Errorf("watch %s canceled: %s", prefix, msg.Result.CancelReason)
+		}
+		if len(msg.Result.Events) > 0 {
+			f(msg.Result.Events)
+			rev = msg.Result.Events[len(msg.Result.Events)-1].Kv.ModRevision + 1
+		}
+	}
+}
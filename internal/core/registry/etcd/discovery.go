@@ -0,0 +1,269 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+const (
+	minRetryInterval = 1 * time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+// Discovery lists the key prefix once, and watches it to keep the instances of all services
+type Discovery struct {
+	client *client
+	prefix string
+
+	mu       sync.RWMutex
+	services map[string]map[string]*registry.MicroServiceInstance
+	synced   bool
+	cancel   context.CancelFunc
+	closed   bool
+}
+
+// NewServiceDiscovery creates the discovery of ggs.service.registry.serviceDiscovery.address
+func NewServiceDiscovery(opts registry.Options) registry.ServiceDiscovery {
+	c, err := newClient(opts.Addrs, opts.TLSConfig)
+	if err != nil {
+		qlog.Errorf("new etcd discovery failed: %s", err)
+		return nil
+	}
+	return &Discovery{
+		client:   c,
+		prefix:   keyPrefix(),
+		services: make(map[string]map[string]*registry.MicroServiceInstance),
+	}
+}
+
+// sync lists the prefix and starts the watch for the first time
+func (d *Discovery) sync() error {
+	d.mu.RLock()
+	synced := d.synced
+	d.mu.RUnlock()
+	if synced {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return fmt.Errorf("etcd discovery is closed")
+	}
+	if d.synced {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	rev, err := d.list(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	ctx, d.cancel = context.WithCancel(context.Background())
+	d.synced = true
+	go d.watch(ctx, rev+1)
+	return nil
+}
+
+// list replaces the instances with the keys of the prefix, the caller must hold the lock
+func (d *Discovery) list(ctx context.Context) (int64, error) {
+	kvs, rev, err := d.client.rangePrefix(ctx, d.prefix)
+	if err != nil {
+		return 0, fmt.Errorf("list %s from etcd failed: %s", d.prefix, err)
+	}
+	services := make(map[string]map[string]*registry.MicroServiceInstance)
+	for _, kv := range kvs {
+		service, id, ok := splitKey(d.prefix, string(kv.Key))
+		if !ok {
+			continue
+		}
+		ins, err := decodeInstance(service, kv.Value)
+		if err != nil {
+			qlog.Warnf("invalid instance %s: %s", kv.Key, err)
+			continue
+		}
+		if services[service] == nil {
+			services[service] = make(map[string]*registry.MicroServiceInstance)
+		}
+		services[service][id] = ins
+	}
+	// the services which are gone must be cleared in the index too
+	for service := range d.services {
+		if _, ok := services[service]; !ok {
+			services[service] = make(map[string]*registry.MicroServiceInstance)
+		}
+	}
+	d.services = services
+	for service := range services {
+		d.updateIndex(service)
+	}
+	return rev, nil
+}
+
+// watch keeps the last known instances when etcd is unreachable, and retries with backoff
+func (d *Discovery) watch(ctx context.Context, rev int64) {
+	retry := minRetryInterval
+	for {
+		next, err := d.client.watchPrefix(ctx, d.prefix, rev, d.apply)
+		if ctx.Err() != nil {
+			return
+		}
+		if next > rev {
+			retry = minRetryInterval
+		}
+		rev = next
+		if err == errCompacted {
+			lctx, cancel := context.WithTimeout(ctx, DefaultRequestTimeout)
+			d.mu.Lock()
+			var listed int64
+			listed, err = d.list(lctx)
+			d.mu.Unlock()
+			cancel()
+			if err == nil {
+				rev = listed + 1
+				continue
+			}
+		}
+		qlog.Warnf("watch %s from etcd failed, keep the last known instances, retry after %s: %s", d.prefix, retry, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+		retry *= 2
+		if retry > maxRetryInterval {
+			retry = maxRetryInterval
+		}
+	}
+}
+
+// apply updates the instances by the events of the watch
+func (d *Discovery) apply(events []event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed := make(map[string]bool)
+	for _, e := range events {
+		service, id, ok := splitKey(d.prefix, string(e.Kv.Key))
+		if !ok {
+			continue
+		}
+		if e.Type == "DELETE" {
+			delete(d.services[service], id)
+			changed[service] = true
+			continue
+		}
+		ins, err := decodeInstance(service, e.Kv.Value)
+		if err != nil {
+			qlog.Warnf("invalid instance %s: %s", e.Kv.Key, err)
+			continue
+		}
+		if d.services[service] == nil {
+			d.services[service] = make(map[string]*registry.MicroServiceInstance)
+		}
+		d.services[service][id] = ins
+		changed[service] = true
+	}
+	for service := range changed {
+		d.updateIndex(service)
+		qlog.Tracef("service %s changed, %d instances", service, len(d.services[service]))
+	}
+}
+
+// updateIndex feeds the instances of the service to the registry instance index and the watchers, the caller must hold the lock
+func (d *Discovery) updateIndex(service string) {
+	instances := make([]*registry.MicroServiceInstance, 0, len(d.services[service]))
+	for _, ins := range d.services[service] {
+		instances = append(instances, ins)
+	}
+	registry.SetInstances(service, instances)
+}
+
+// instances returns the instances of the service which match the filter
+func (d *Discovery) instances(microServiceName string, filter func(ins *registry.MicroServiceInstance) bool) ([]*registry.MicroServiceInstance, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	instances := make([]*registry.MicroServiceInstance, 0, len(d.services[microServiceName]))
+	for _, ins := range d.services[microServiceName] {
+		if filter(ins) {
+			instances = append(instances, ins)
+		}
+	}
+	return instances, nil
+}
+
+// FindMicroServiceInstances returns the UP instances which have the tags
+func (d *Discovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
+	return d.instances(microServiceName, func(ins *registry.MicroServiceInstance) bool {
+		return ins.Status == common.DefaultStatus && ins.Has(tags.KV)
+	})
+}
+
+// GetMicroServiceInstances returns all instances of the provider
+func (d *Discovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
+	return d.instances(providerID, func(ins *registry.MicroServiceInstance) bool {
+		return true
+	})
+}
+
+// GetMicroServiceID returns the service name as its id
+func (d *Discovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
+	return microServiceName, nil
+}
+
+// GetAllMicroServices returns the services which have instances
+func (d *Discovery) GetAllMicroServices() ([]*registry.MicroService, error) {
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make([]*registry.MicroService, 0, len(d.services))
+	for name, instances := range d.services {
+		if len(instances) > 0 {
+			services = append(services, &registry.MicroService{ServiceID: name, ServiceName: name, Status: common.DefaultStatus})
+		}
+	}
+	return services, nil
+}
+
+// GetMicroService returns the service if it has instances
+func (d *Discovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	instances, err := d.GetMicroServiceInstances("", microServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("service %s not found", microServiceID)
+	}
+	return &registry.MicroService{ServiceID: microServiceID, ServiceName: microServiceID, Status: common.DefaultStatus}, nil
+}
+
+// AutoSync lists and watches the prefix in background
+func (d *Discovery) AutoSync() {
+	go func() {
+		if err := d.sync(); err != nil {
+			qlog.Warnf("sync instances from etcd failed, retry when the services are referenced: %s", err)
+		}
+	}()
+}
+
+// Close stops the watch
+func (d *Discovery) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,402 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+// fakeEtcd serves the json gateway apis used by the plugin, the keys, leases and events are kept in memory,
+// every change bumps the revision, so the watches send the new events
+type fakeEtcd struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	rev        int64
+	changed    chan struct{}
+	kvs        map[string]keyValue
+	history    []event
+	leases     map[int64]bool // the alive leases
+	nextLease  int64
+	keepAlives map[int64]int
+	revoked    map[int64]bool
+}
+
+func newFakeEtcd(t *testing.T) *fakeEtcd {
+	s := &fakeEtcd{
+		rev:        1,
+		changed:    make(chan struct{}),
+		kvs:        make(map[string]keyValue),
+		leases:     make(map[int64]bool),
+		nextLease:  0x100,
+		keepAlives: make(map[int64]int),
+		revoked:    make(map[int64]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeEtcd) options() registry.Options {
+	return registry.Options{Addrs: []string{s.Listener.Addr().String()}}
+}
+
+// bump must be called with the lock held
+func (s *fakeEtcd) bump() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// putKey must be called with the lock held
+func (s *fakeEtcd) putKey(key string, value []byte, lease int64) {
+	s.rev++
+	kv := keyValue{Key: []byte(key), Value: value, ModRevision: s.rev, Lease: lease}
+	s.kvs[key] = kv
+	s.history = append(s.history, event{Type: "PUT", Kv: kv})
+	s.bump()
+}
+
+// expire removes the lease and its keys, it must be called with the lock held
+func (s *fakeEtcd) expire(lease int64) {
+	delete(s.leases, lease)
+	keys := make([]string, 0)
+	for key, kv := range s.kvs {
+		if kv.Lease == lease {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.rev++
+		delete(s.kvs, key)
+		s.history = append(s.history, event{Type: "DELETE", Kv: keyValue{Key: []byte(key), ModRevision: s.rev}})
+	}
+	s.bump()
+}
+
+func (s *fakeEtcd) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID            int64  `json:"ID,string"`
+		TTL           int64  `json:"TTL,string"`
+		Key           []byte `json:"key"`
+		Value         []byte `json:"value"`
+		RangeEnd      []byte `json:"range_end"`
+		Lease         int64  `json:"lease,string"`
+		CreateRequest *struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			StartRevision int64  `json:"start_revision,string"`
+		} `json:"create_request"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/v3/watch" {
+		c := req.CreateRequest
+		s.serveWatch(w, r, c.Key, c.RangeEnd, c.StartRevision)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		s.nextLease++
+		s.leases[s.nextLease] = true
+		s.reply(w, map[string]string{"ID": formatInt(s.nextLease), "TTL": formatInt(req.TTL)})
+	case "/v3/lease/keepalive":
+		s.keepAlives[req.ID]++
+		if !s.leases[req.ID] {
+			// the gateway omits the ttl of an expired lease
+			s.reply(w, map[string]interface{}{"result": map[string]string{"ID": formatInt(req.ID)}})
+			return
+		}
+		s.reply(w, map[string]interface{}{"result": map[string]string{"ID": formatInt(req.ID), "TTL": formatInt(leaseTTL)}})
+	case "/v3/lease/revoke":
+		if !s.leases[req.ID] {
+			http.Error(w, `{"error":"requested lease not found","code":5}`, http.StatusNotFound)
+			return
+		}
+		s.revoked[req.ID] = true
+		s.expire(req.ID)
+		s.reply(w, map[string]interface{}{})
+	case "/v3/kv/put":
+		if req.Lease != 0 && !s.leases[req.Lease] {
+			http.Error(w, `{"error":"requested lease not found","code":5}`, http.StatusNotFound)
+			return
+		}
+		s.putKey(string(req.Key), req.Value, req.Lease)
+		s.reply(w, map[string]interface{}{})
+	case "/v3/kv/range":
+		kvs := make([]keyValue, 0)
+		for _, kv := range s.kvs {
+			if inRange(kv.Key, req.Key, req.RangeEnd) {
+				kvs = append(kvs, kv)
+			}
+		}
+		s.reply(w, struct {
+			Header header     `json:"header"`
+			Kvs    []keyValue `json:"kvs"`
+		}{header{s.rev}, kvs})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveWatch streams the events of the range from the revision until the request is canceled
+func (s *fakeEtcd) serveWatch(w http.ResponseWriter, r *http.Request, key, end []byte, rev int64) {
+	type result struct {
+		Header header  `json:"header"`
+		Events []event `json:"events,omitempty"`
+	}
+	enc := json.NewEncoder(w)
+	flusher := w.(http.Flusher)
+	s.mu.Lock()
+	enc.Encode(map[string]result{"result": {Header: header{s.rev}}})
+	s.mu.Unlock()
+	flusher.Flush()
+	for {
+		s.mu.Lock()
+		var events []event
+		for _, e := range s.history {
+			if e.Kv.ModRevision >= rev && inRange(e.Kv.Key, key, end) {
+				events = append(events, e)
+			}
+		}
+		changed := s.changed
+		if len(events) > 0 {
+			enc.Encode(map[string]result{"result": {Header: header{s.rev}, Events: events}})
+			rev = events[len(events)-1].Kv.ModRevision + 1
+		}
+		s.mu.Unlock()
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// reply must be called with the lock held
+func (s *fakeEtcd) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeEtcd) value(key string) (*instanceValue, int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kv, ok := s.kvs[key]
+	if !ok {
+		return nil, 0, false
+	}
+	v := &instanceValue{}
+	if err := json.Unmarshal(kv.Value, v); err != nil {
+		return nil, 0, false
+	}
+	return v, kv.Lease, true
+}
+
+func (s *fakeEtcd) put(key string, v *instanceValue) {
+	b, _ := json.Marshal(v)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putKey(key, b, 0)
+}
+
+func inRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0)
+}
+
+func formatInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	config.MicroserviceDefinition.ServiceDescription.Name = "hello"
+	config.MicroserviceDefinition.ServiceDescription.Version = "1.0.0"
+	os.Exit(m.Run())
+}
+
+const helloKey = DefaultKeyPrefix + "hello/127.0.0.1:9090"
+
+// registerHello registers the rest and grpc endpoints of the hello service
+func registerHello(t *testing.T, s *fakeEtcd) *Registrator {
+	r := NewRegistrator(s.options()).(*Registrator)
+	sid, instanceID, err := r.RegisterServiceAndInstance(&registry.MicroService{
+		ServiceName: "hello",
+	}, &registry.MicroServiceInstance{
+		EndpointsMap: map[string]string{
+			common.ProtocolRest: "127.0.0.1:8080",
+			common.ProtocolGrpc: "127.0.0.1:9090",
+		},
+		Metadata: map[string]string{"color": "red"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "hello" || instanceID != "127.0.0.1:9090" {
+		t.Fatalf("unexpected service %s and instance %s", sid, instanceID)
+	}
+	t.Cleanup(func() { r.UnRegisterMicroServiceInstance(sid, instanceID) })
+	return r
+}
+
+func TestRegisterServiceAndInstance(t *testing.T) {
+	s := newFakeEtcd(t)
+	r := registerHello(t, s)
+
+	v, lease, ok := s.value(helloKey)
+	if !ok {
+		t.Fatalf("%s is not registered", helloKey)
+	}
+	if lease == 0 || lease != r.lease {
+		t.Errorf("want the key bound to lease %x, got %x", r.lease, lease)
+	}
+	if v.Status != common.DefaultStatus || v.Endpoints[common.ProtocolRest] != "127.0.0.1:8080" ||
+		v.Metadata["color"] != "red" || v.Metadata[common.BuildinTagVersion] != "1.0.0" {
+		t.Errorf("unexpected value %+v", v)
+	}
+
+	// registering again replaces the lease
+	old := r.lease
+	if _, err := r.RegisterServiceInstance("hello", &registry.MicroServiceInstance{
+		EndpointsMap: map[string]string{common.ProtocolGrpc: "127.0.0.1:9090"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	revoked := s.revoked[old]
+	s.mu.Unlock()
+	if r.lease == old || !revoked {
+		t.Errorf("the old lease %x is not revoked after registering again", old)
+	}
+	if _, lease, ok := s.value(helloKey); !ok || lease != r.lease {
+		t.Errorf("want the key bound to the new lease %x, got %x", r.lease, lease)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	s := newFakeEtcd(t)
+	r := registerHello(t, s)
+
+	ok, err := r.Heartbeat("hello", "127.0.0.1:9090")
+	if err != nil || !ok {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	s.mu.Lock()
+	n := s.keepAlives[r.lease]
+	s.expire(r.lease)
+	s.mu.Unlock()
+	if n != 1 {
+		t.Errorf("want the lease kept alive once, got %d", n)
+	}
+
+	// the heartbeat service registers again if the lease is expired
+	if ok, err := r.Heartbeat("hello", "127.0.0.1:9090"); ok || err == nil {
+		t.Errorf("want the heartbeat of the expired lease failed, got %v, %v", ok, err)
+	}
+}
+
+func TestUnRegisterMicroServiceInstance(t *testing.T) {
+	s := newFakeEtcd(t)
+	r := registerHello(t, s)
+	lease := r.lease
+
+	if err := r.UnRegisterMicroServiceInstance("hello", "127.0.0.1:9090"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.value(helloKey); ok {
+		t.Errorf("%s is not deleted", helloKey)
+	}
+	s.mu.Lock()
+	revoked := s.revoked[lease]
+	s.mu.Unlock()
+	if !revoked || r.lease != 0 {
+		t.Errorf("the lease %x is not revoked", lease)
+	}
+	if err := r.UpdateMicroServiceInstanceStatus("hello", "127.0.0.1:9090", "DOWN"); err == nil {
+		t.Error("want the update of the deregistered instance failed")
+	}
+}
+
+// waitInstances waits until the UP instances of the service are the ids
+func waitInstances(t *testing.T, d registry.ServiceDiscovery, service string, ids ...string) {
+	t.Helper()
+	sort.Strings(ids)
+	var got []string
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		instances, err := d.FindMicroServiceInstances("", service, utiltags.Tags{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = got[:0]
+		for _, ins := range instances {
+			got = append(got, ins.InstanceID)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") == strings.Join(ids, ",") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("want instances %v of %s, got %v", ids, service, got)
+}
+
+func TestDiscoveryWatchesChanges(t *testing.T) {
+	s := newFakeEtcd(t)
+	s.put(DefaultKeyPrefix+"hello/a", &instanceValue{InstanceID: "a", Endpoints: map[string]string{common.ProtocolRest: "10.0.0.1:80"}})
+	d := NewServiceDiscovery(s.options())
+	t.Cleanup(func() { d.Close() })
+
+	waitInstances(t, d, "hello", "a")
+	instances, _ := d.FindMicroServiceInstances("", "hello", utiltags.Tags{})
+	if ins := instances[0]; ins.DefaultProtocol != common.ProtocolRest || ins.DefaultEndpoint != "10.0.0.1:80" || ins.Status != common.DefaultStatus {
+		t.Errorf("unexpected instance %+v", ins)
+	}
+
+	// the watch receives the registration, the status change and the deregistration
+	r := registerHello(t, s)
+	waitInstances(t, d, "hello", "a", "127.0.0.1:9090")
+	if err := r.UpdateMicroServiceInstanceStatus("hello", "127.0.0.1:9090", "DOWN"); err != nil {
+		t.Fatal(err)
+	}
+	waitInstances(t, d, "hello", "a")
+	all, err := d.GetMicroServiceInstances("", "hello")
+	if err != nil || len(all) != 2 {
+		t.Errorf("want the DOWN instance kept in all instances, got %d, %v", len(all), err)
+	}
+	if err := r.UnRegisterMicroServiceInstance("hello", "127.0.0.1:9090"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		all, _ = d.GetMicroServiceInstances("", "hello")
+		if len(all) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the deregistered instance is not removed, got %d instances", len(all))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package etcd
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+// DefaultKeyPrefix is the default key prefix of the instances, the key of an instance is <prefix><service>/<instance id>
+const DefaultKeyPrefix = "/ggs/services/"
+
+// instanceValue is the value of an instance key
+type instanceValue struct {
+	InstanceID string            `json:"instanceId"`
+	HostName   string            `json:"hostName"`
+	Status     string            `json:"status"`
+	Endpoints  map[string]string `json:"endpoints"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+}
+
+// keyPrefix returns ggs.service.registry.keyPrefix which ends with /
+func keyPrefix() string {
+	p := config.GetRegistryKeyPrefix()
+	if p == "" {
+		return DefaultKeyPrefix
+	}
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+func instanceKey(prefix, service, instanceID string) string {
+	return prefix + service + "/" + instanceID
+}
+
+// splitKey returns the service and instance id of the key
+func splitKey(prefix, key string) (string, string, bool) {
+	s := strings.TrimPrefix(key, prefix)
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func (v *instanceValue) toInstance(service string) *registry.MicroServiceInstance {
+	ins := &registry.MicroServiceInstance{
+		InstanceID:   v.InstanceID,
+		ServiceID:    service,
+		HostName:     v.HostName,
+		Status:       v.Status,
+		EndpointsMap: v.Endpoints,
+		Metadata:     v.Metadata,
+	}
+	if ins.Status == "" {
+		ins.Status = common.DefaultStatus
+	}
+	if ins.EndpointsMap == nil {
+		ins.EndpointsMap = make(map[string]string)
+	}
+	if ins.Metadata == nil {
+		ins.Metadata = make(map[string]string)
+	}
+	if _, ok := ins.EndpointsMap[common.ProtocolRest]; ok {
+		ins.DefaultProtocol = common.ProtocolRest
+	} else {
+		for p := range ins.EndpointsMap {
+			if ins.DefaultProtocol == "" || p < ins.DefaultProtocol {
+				ins.DefaultProtocol = p
+			}
+		}
+	}
+	ins.DefaultEndpoint = ins.EndpointsMap[ins.DefaultProtocol]
+	if v.Region != "" || v.Zone != "" {
+		ins.DataCenterInfo = &registry.DataCenterInfo{
+			Name:          v.Region,
+			Region:        v.Region,
+			AvailableZone: v.Zone,
+		}
+	}
+	return ins
+}
+
+func decodeInstance(service string, b []byte) (*registry.MicroServiceInstance, error) {
+	v := &instanceValue{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	return v.toInstance(service), nil
+}
@@ -0,0 +1,287 @@
+// Package etcd is a registry plugin which registers the instances to etcd v3 with a lease,
+// and discovers the instances by watching the key prefix
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/pkg/runtime"
+	"github.com/leon-yc/ggs/internal/pkg/util/iputil"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// Name is the name of the plugin
+const Name = "etcd"
+
+// MetaEnv is the metadata key of the environment of the service
+const MetaEnv = "env"
+
+const (
+	//DefaultRequestTimeout is the timeout of a request to etcd
+	DefaultRequestTimeout = 10 * time.Second
+)
+
+//leaseTTL is 3 times of the heartbeat interval, so the instance is removed after 3 missed heartbeats
+var leaseTTL = int64(3 * common.DefaultHBInterval)
+
+// Registrator registers the instance under <prefix><service>/<instance id>, the key is bound to a lease
+// which is kept alive by the heartbeat
+type Registrator struct {
+	client *client
+	prefix string
+
+	mu      sync.Mutex
+	service string
+	key     string
+	value   *instanceValue
+	lease   int64
+}
+
+// NewRegistrator creates the registrator of ggs.service.registry.registrator.address
+func NewRegistrator(opts registry.Options) registry.Registrator {
+	c, err := newClient(opts.Addrs, opts.TLSConfig)
+	if err != nil {
+		qlog.Errorf("new etcd registrator failed: %s", err)
+		return nil
+	}
+	return &Registrator{
+		client: c,
+		prefix: keyPrefix(),
+	}
+}
+
+// RegisterService returns the service name as its id, the service is registered with the instance
+func (r *Registrator) RegisterService(microService *registry.MicroService) (string, error) {
+	r.mu.Lock()
+	r.service = microService.ServiceName
+	r.mu.Unlock()
+	return microService.ServiceName, nil
+}
+
+// RegisterServiceInstance puts the instance with a new lease, the instance id is the first endpoint
+func (r *Registrator) RegisterServiceInstance(sid string, instance *registry.MicroServiceInstance) (string, error) {
+	if len(instance.EndpointsMap) == 0 {
+		return "", fmt.Errorf("endpoints is empty")
+	}
+	protocols := make([]string, 0, len(instance.EndpointsMap))
+	for p := range instance.EndpointsMap {
+		protocols = append(protocols, p)
+	}
+	sort.Strings(protocols)
+
+	eps := make(map[string]string, len(instance.EndpointsMap))
+	for _, p := range protocols {
+		host, port, err := net.SplitHostPort(instance.EndpointsMap[p])
+		if err != nil {
+			return "", fmt.Errorf("invalid endpoint %s of %s: %s", instance.EndpointsMap[p], p, err)
+		}
+		if host == "" {
+			host = iputil.GetLocalIP()
+		}
+		eps[p] = net.JoinHostPort(host, port)
+	}
+	instanceID := instance.InstanceID
+	if instanceID == "" {
+		instanceID = eps[protocols[0]]
+	}
+
+	v := &instanceValue{
+		InstanceID: instanceID,
+		HostName:   instance.HostName,
+		Status:     instance.Status,
+		Endpoints:  eps,
+		Metadata:   registeredMeta(instance),
+	}
+	if v.Status == "" {
+		v.Status = common.DefaultStatus
+	}
+	if dc := instance.DataCenterInfo; dc != nil {
+		v.Region = dc.Region
+		v.Zone = dc.AvailableZone
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.service == "" {
+		r.service = config.MicroserviceDefinition.ServiceDescription.Name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	lease, err := r.client.grant(ctx, leaseTTL)
+	if err != nil {
+		return "", err
+	}
+	key := instanceKey(r.prefix, r.service, instanceID)
+	if err := r.putValue(ctx, key, v, lease); err != nil {
+		return "", err
+	}
+	// the old lease is useless after re-register
+	if r.lease != 0 && r.lease != lease {
+		if err := r.client.revoke(ctx, r.lease); err != nil {
+			qlog.Warnf("revoke the old lease %x failed: %s", r.lease, err)
+		}
+	}
+	r.key, r.value, r.lease = key, v, lease
+	registry.HBService.AddTask(sid, instanceID)
+	qlog.Infof("register instance %s to etcd, lease %x", key, lease)
+	return instanceID, nil
+}
+
+func (r *Registrator) putValue(ctx context.Context, key string, v *instanceValue, lease int64) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := r.client.put(ctx, key, b, lease); err != nil {
+		return fmt.Errorf("put %s failed: %s", key, err)
+	}
+	return nil
+}
+
+// registeredMeta returns the properties and the built-in tags of the instance
+func registeredMeta(instance *registry.MicroServiceInstance) map[string]string {
+	desc := config.MicroserviceDefinition.ServiceDescription
+	meta := make(map[string]string)
+	for _, props := range []map[string]string{desc.Properties, desc.InstanceProperties, instance.Metadata} {
+		for k, v := range props {
+			meta[k] = v
+		}
+	}
+	builtin := map[string]string{
+		common.BuildinTagApp:     runtime.App,
+		common.BuildinTagVersion: desc.Version,
+		MetaEnv:                  desc.Environment,
+	}
+	for k, v := range builtin {
+		if v != "" {
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// RegisterServiceAndInstance registers the instance of the service, etcd has no service without instances
+func (r *Registrator) RegisterServiceAndInstance(microService *registry.MicroService, instance *registry.MicroServiceInstance) (string, string, error) {
+	sid, err := r.RegisterService(microService)
+	if err != nil {
+		return "", "", err
+	}
+	instanceID, err := r.RegisterServiceInstance(sid, instance)
+	if err != nil {
+		return "", "", err
+	}
+	return sid, instanceID, nil
+}
+
+// Heartbeat keeps the lease alive, it fails if the lease is expired, then the heartbeat service registers again
+func (r *Registrator) Heartbeat(microServiceID, microServiceInstanceID string) (bool, error) {
+	r.mu.Lock()
+	lease := r.lease
+	r.mu.Unlock()
+	if lease == 0 {
+		return false, fmt.Errorf("instance %s is not registered", microServiceInstanceID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	ttl, err := r.client.keepAlive(ctx, lease)
+	if err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease %x of instance %s is expired", lease, microServiceInstanceID)
+	}
+	return true, nil
+}
+
+// AddDependencies is noop
+func (r *Registrator) AddDependencies(dep *registry.MicroServiceDependency) error {
+	return nil
+}
+
+// UnRegisterMicroServiceInstance revokes the lease, so the instance key is deleted
+func (r *Registrator) UnRegisterMicroServiceInstance(microServiceID, microServiceInstanceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registry.HBService.RemoveTask(microServiceID, microServiceInstanceID)
+	if r.lease == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	if err := r.client.revoke(ctx, r.lease); err != nil {
+		return fmt.Errorf("revoke lease %x of %s failed: %s", r.lease, r.key, err)
+	}
+	qlog.Infof("deregister instance %s from etcd", r.key)
+	r.lease, r.key, r.value = 0, "", nil
+	return nil
+}
+
+// UpdateMicroServiceInstanceStatus updates the status of the instance, the discovery only returns the UP instances
+func (r *Registrator) UpdateMicroServiceInstanceStatus(microServiceID, microServiceInstanceID, status string) error {
+	return r.update(func(v *instanceValue) {
+		v.Status = status
+	})
+}
+
+// UpdateMicroServiceProperties is the same as UpdateMicroServiceInstanceProperties, etcd has no service level metadata
+func (r *Registrator) UpdateMicroServiceProperties(microServiceID string, properties map[string]string) error {
+	return r.UpdateMicroServiceInstanceProperties(microServiceID, "", properties)
+}
+
+// UpdateMicroServiceInstanceProperties merges the properties into the metadata of the instance
+func (r *Registrator) UpdateMicroServiceInstanceProperties(microServiceID, microServiceInstanceID string, properties map[string]string) error {
+	return r.update(func(v *instanceValue) {
+		if v.Metadata == nil {
+			v.Metadata = make(map[string]string, len(properties))
+		}
+		for k, val := range properties {
+			v.Metadata[k] = val
+		}
+	})
+}
+
+// update changes a copy of the registered value and puts it with the same lease
+func (r *Registrator) update(f func(v *instanceValue)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.value == nil {
+		return fmt.Errorf("instance is not registered")
+	}
+	v := *r.value
+	v.Metadata = make(map[string]string, len(r.value.Metadata))
+	for k, val := range r.value.Metadata {
+		v.Metadata[k] = val
+	}
+	f(&v)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	if err := r.putValue(ctx, r.key, &v, r.lease); err != nil {
+		return err
+	}
+	r.value = &v
+	return nil
+}
+
+// AddSchemas is noop
+func (r *Registrator) AddSchemas(microServiceID, schemaName, schemaInfo string) error {
+	return nil
+}
+
+// Close is noop, the instance is deregistered by UnRegisterMicroServiceInstance
+func (r *Registrator) Close() error {
+	return nil
+}
+
+func init() {
+	registry.InstallRegistrator(Name, NewRegistrator)
+	registry.InstallServiceDiscovery(Name, NewServiceDiscovery)
+}
@@ -5,10 +5,11 @@ import (
 
 	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
 
+	"github.com/leon-yc/ggs/internal/core/common"
 	"github.com/leon-yc/ggs/internal/core/registry"
-	"github.com/leon-yc/ggs/internal/core/registry/servicecenter"
 	client "github.com/leon-yc/ggs/internal/pkg/scclient"
-	"github.com/leon-yc/ggs/internal/pkg/scclient/proto"
+	"github.com/leon-yc/ggs/internal/pkg/util/iputil"
+	"github.com/leon-yc/ggs/pkg/qlog"
 )
 
 // constant string for file
@@ -28,14 +29,25 @@ func (f *Registrator) Close() error {
 	return nil
 }
 
-// RegisterServiceInstance register service instance
+// RegisterServiceInstance does not write the files, it returns the instance id, or the first endpoint if it is empty
 func (f *Registrator) RegisterServiceInstance(sid string, instance *registry.MicroServiceInstance) (string, error) {
-	return "", nil
+	if instance.InstanceID != "" {
+		return instance.InstanceID, nil
+	}
+	for _, p := range []string{common.ProtocolRest, common.ProtocolGrpc} {
+		if ep, ok := instance.EndpointsMap[p]; ok {
+			return ep, nil
+		}
+	}
+	for _, ep := range instance.EndpointsMap {
+		return ep, nil
+	}
+	return iputil.GetLocalIP(), nil
 }
 
-// RegisterService register service
+// RegisterService does not write the files, the service name is the id
 func (f *Registrator) RegisterService(microservice *registry.MicroService) (string, error) {
-	return "", nil
+	return microservice.ServiceName, nil
 }
 
 // RegisterServiceAndInstance register service and instance
@@ -85,21 +97,24 @@ type Discovery struct {
 	opts           Options
 }
 
-// Close close the file
+// Close stops watching the files
 func (f *Discovery) Close() error {
-	return nil
+	return f.registryClient.close()
 }
 
-// GetMicroServiceID get micro-service id
+// GetMicroServiceID returns the service name as its id
 func (f *Discovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
-	return "helloService", nil
+	return microServiceName, nil
 }
 
-// GetAllMicroServices get all microservices
+// GetAllMicroServices returns the services in the files
 func (f *Discovery) GetAllMicroServices() ([]*registry.MicroService, error) {
-	return []*registry.MicroService{
-		{},
-	}, nil
+	names := f.registryClient.serviceNames()
+	services := make([]*registry.MicroService, 0, len(names))
+	for _, name := range names {
+		services = append(services, newMicroService(name))
+	}
+	return services, nil
 }
 
 // GetAllApplications get all applications
@@ -107,19 +122,27 @@ func (f *Discovery) GetAllApplications() ([]string, error) {
 	return []string{}, nil
 }
 
-// GetMicroService get micro-service
+// GetMicroService returns the service if it is in the files
 func (f *Discovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	if _, ok := f.registryClient.FindMicroServiceInstances(microServiceID); !ok {
+		return nil, fmt.Errorf("service %s not found in files %v", microServiceID, f.registryClient.Addresses)
+	}
+	return newMicroService(microServiceID), nil
+}
+
+func newMicroService(name string) *registry.MicroService {
 	return &registry.MicroService{
-		ServiceID:   "helloService",
-		ServiceName: "helloService",
-		Status:      "UP",
+		ServiceID:   name,
+		ServiceName: name,
+		Status:      common.DefaultStatus,
 		Metadata:    map[string]string{},
-	}, nil
+	}
 }
 
-// GetMicroServiceInstances get micro-service instances
+// GetMicroServiceInstances returns all instances of the provider, including the instances which are not UP
 func (f *Discovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
-	return []*registry.MicroServiceInstance{}, nil
+	instances, _ := f.registryClient.FindMicroServiceInstances(providerID)
+	return instances, nil
 }
 
 // WatchMicroService watch micro-service
@@ -127,30 +150,26 @@ func (f *Discovery) WatchMicroService(selfMicroServiceID string, callback func(*
 	return
 }
 
-// AutoSync auto sync
+// AutoSync watches the files, and reloads them when they change
 func (f *Discovery) AutoSync() {
-
+	if err := f.registryClient.watch(); err != nil {
+		qlog.Warnf("watch service files failed, the changes will not be reloaded: %s", err)
+	}
 }
 
-// FindMicroServiceInstances find micro-service instances
+// FindMicroServiceInstances returns the UP instances which have the tags
 func (f *Discovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
-	providerInstances, err := f.registryClient.FindMicroServiceInstances(microServiceName)
-	if err != nil {
-		return nil, fmt.Errorf("FindMicroServiceInstances failed, err: %s", err)
+	all, ok := f.registryClient.FindMicroServiceInstances(microServiceName)
+	if !ok {
+		return nil, fmt.Errorf("FindMicroServiceInstances failed, err: service %s not found in files %v", microServiceName, f.registryClient.Addresses)
 	}
-	instances := filterInstances(providerInstances)
-
-	return instances, nil
-}
-
-// filterInstances filter instances
-func filterInstances(providerInstances []*proto.MicroServiceInstance) []*registry.MicroServiceInstance {
-	instances := make([]*registry.MicroServiceInstance, 0)
-	for _, ins := range providerInstances {
-		msi := servicecenter.ToMicroServiceInstance(ins)
-		instances = append(instances, msi)
+	instances := make([]*registry.MicroServiceInstance, 0, len(all))
+	for _, ins := range all {
+		if ins.Status == common.DefaultStatus && ins.Has(tags.KV) {
+			instances = append(instances, ins)
+		}
 	}
-	return instances
+	return instances, nil
 }
 
 // newFileRegistry new file registry
@@ -1,98 +1,367 @@
 package file
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/leon-yc/ggs/internal/pkg/scclient/proto"
+	"github.com/fsnotify/fsnotify"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/registry"
 	"github.com/leon-yc/ggs/internal/pkg/util/fileutil"
 	"github.com/leon-yc/ggs/pkg/qlog"
+	"gopkg.in/yaml.v2"
 )
 
 const (
 	// ServiceJSON service json
 	ServiceJSON = "service.json"
-)
+	// DefaultDir is the directory of the service files under the work dir
+	DefaultDir = "disco"
+	// MetaWeight is the metadata key of the instance weight
+	MetaWeight = registry.MetaWeight
 
-type localFileData struct {
-	ServiceName  string `json:"serviceName,omitempty"`
-	InstanceData *proto.MicroServiceInstance
-}
+	// the changes in a short time are reloaded once
+	reloadDelay = 100 * time.Millisecond
+)
 
 // Options struct having addresses
 type Options struct {
 	Addrs []string
 }
 
-type fileClient struct {
-	Addresses []string
-}
-
+// serviceData is the content of a service file, the file is yaml or json
 type serviceData struct {
-	Service []*service `json:"service,omitempty"`
+	Services []*service `yaml:"services"`
+	// Deprecated: the old format which has only a list of endpoints
+	Service []*service `yaml:"service"`
 }
+
 type service struct {
-	Name     string   `json:"name,omitempty"`
-	Instance []string `json:"instance,omitempty"`
+	Name      string      `yaml:"name"`
+	Instances []*instance `yaml:"instances"`
+	// Deprecated: the old format, the endpoints like rest://127.0.0.1:8080 or 127.0.0.1:8080
+	Instance []string `yaml:"instance"`
 }
 
-func (f *fileClient) Initialize(opt Options) {
-	f.Addresses = opt.Addrs
+type instance struct {
+	ID       string            `yaml:"id"`
+	Host     string            `yaml:"host"`
+	Status   string            `yaml:"status"`
+	Version  string            `yaml:"version"`
+	App      string            `yaml:"app"`
+	Weight   int               `yaml:"weight"`
+	Region   string            `yaml:"region"`
+	Zone     string            `yaml:"zone"`
+	Metadata map[string]string `yaml:"metadata"`
+	// protocol to address, like rest: 127.0.0.1:8080
+	Endpoints map[string]string `yaml:"endpoints"`
 }
 
-func (f *fileClient) FindMicroServiceInstances(microServiceName string) ([]*proto.MicroServiceInstance, error) {
-	var instanceData []*proto.MicroServiceInstance
+// fileClient keeps the instances of the service files in memory, and reloads them when the files change
+type fileClient struct {
+	Addresses []string
 
-	data := f.getInstanceDataFromFile()
-	if data == nil {
-		return instanceData, fmt.Errorf("failed to get instance information")
+	mu       sync.RWMutex
+	services map[string][]*registry.MicroServiceInstance
+	watcher  *fsnotify.Watcher
+}
+
+// Initialize loads the files of the addresses, the address is a file or a directory, the default is disco in the work dir
+func (f *fileClient) Initialize(opt Options) {
+	// the address may be the default address of the registry, only the existing paths are used
+	f.Addresses = nil
+	for _, addr := range opt.Addrs {
+		if _, err := os.Stat(addr); err != nil {
+			qlog.Warnf("service file %s is ignored: %s", addr, err)
+			continue
+		}
+		f.Addresses = append(f.Addresses, addr)
+	}
+	if len(f.Addresses) == 0 {
+		cwd, _ := fileutil.GetWorkDir()
+		f.Addresses = []string{filepath.Join(cwd, DefaultDir)}
+	}
+	f.services = make(map[string][]*registry.MicroServiceInstance)
+	if err := f.reload(); err != nil {
+		qlog.Warnf("load service files failed: %s", err)
 	}
+}
 
-	localData := &localFileData{}
-	for _, value := range data.Service {
-		if value.Name == microServiceName {
-			insData := &proto.MicroServiceInstance{
-				Endpoints: value.Instance,
-			}
-			localData.ServiceName = value.Name
-			localData.InstanceData = insData
+// files returns the service files of the addresses
+func (f *fileClient) files() []string {
+	var files []string
+	for _, addr := range f.Addresses {
+		st, err := os.Stat(addr)
+		if err != nil {
+			continue
+		}
+		if !st.IsDir() {
+			files = append(files, addr)
+			continue
+		}
+		for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(addr, pattern))
+			files = append(files, matches...)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
 
-			instanceData = append(instanceData, localData.InstanceData)
-			return instanceData, nil
+// reload reads all files, the cache is kept if any file is invalid
+func (f *fileClient) reload() error {
+	services := make(map[string][]*registry.MicroServiceInstance)
+	for _, file := range f.files() {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read %s failed: %s", file, err)
+		}
+		data := &serviceData{}
+		if err := yaml.Unmarshal(b, data); err != nil {
+			return fmt.Errorf("parse %s failed: %s", file, err)
+		}
+		for _, s := range append(data.Services, data.Service...) {
+			if s.Name == "" {
+				return fmt.Errorf("service without name in %s", file)
+			}
+			instances, err := s.toInstances()
+			if err != nil {
+				return fmt.Errorf("invalid service %s in %s: %s", s.Name, file, err)
+			}
+			services[s.Name] = append(services[s.Name], instances...)
 		}
 	}
 
-	return instanceData, nil
+	f.mu.Lock()
+	old := f.services
+	f.services = services
+	f.mu.Unlock()
+	for name, instances := range services {
+		registry.SetInstances(name, instances)
+	}
+	for name := range old {
+		if _, ok := services[name]; !ok {
+			registry.SetInstances(name, []*registry.MicroServiceInstance{})
+		}
+	}
+	qlog.Tracef("load %d services from files %v", len(services), f.Addresses)
+	return nil
 }
 
-func (f *fileClient) getInstanceDataFromFile() *serviceData {
-	var data *serviceData
-	path := strings.Join(f.Addresses, "")
+func (s *service) toInstances() ([]*registry.MicroServiceInstance, error) {
+	instances := make([]*registry.MicroServiceInstance, 0, len(s.Instances)+len(s.Instance))
+	for i, ins := range s.Instances {
+		if len(ins.Endpoints) == 0 {
+			return nil, fmt.Errorf("instance %d has no endpoints", i)
+		}
+		instances = append(instances, ins.toInstance(s.Name))
+	}
 
-	if path == "" {
-		cwd, _ := fileutil.GetWorkDir()
-		path = filepath.Join(cwd, "disco", ServiceJSON)
+	// the endpoints of the old format on the same host are the protocols of an instance
+	index := make(map[string]*instance)
+	var legacy []*instance
+	for _, ep := range s.Instance {
+		proto, addr := splitEndpoint(ep)
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %s", ep, err)
+		}
+		ins, ok := index[host]
+		if !ok || (proto != "" && ins.Endpoints[proto] != "") {
+			ins = &instance{Host: host, Endpoints: make(map[string]string)}
+			index[host] = ins
+			legacy = append(legacy, ins)
+		}
+		if proto == "" {
+			ins.Endpoints[common.ProtocolRest] = addr
+			ins.Endpoints[common.ProtocolGrpc] = addr
+		} else {
+			ins.Endpoints[proto] = addr
+		}
+	}
+	for _, ins := range legacy {
+		instances = append(instances, ins.toInstance(s.Name))
 	}
+	return instances, nil
+}
 
-	file, err := os.Open(path)
+// splitEndpoint splits the endpoint like rest://127.0.0.1:8080, the protocol is empty if there is no scheme
+func splitEndpoint(ep string) (string, string) {
+	if !strings.Contains(ep, "://") {
+		return "", ep
+	}
+	u, err := url.Parse(ep)
 	if err != nil {
-		qlog.Warnf("failed to open a file", err)
+		return "", ep
+	}
+	return u.Scheme, u.Host
+}
+
+func (ins *instance) toInstance(serviceName string) *registry.MicroServiceInstance {
+	protocols := make([]string, 0, len(ins.Endpoints))
+	for p := range ins.Endpoints {
+		protocols = append(protocols, p)
 	}
-	defer file.Close()
+	sort.Strings(protocols)
 
-	plan, err := ioutil.ReadFile(path)
-	if err != nil {
-		qlog.Warnf("failed to do readfile operation", err)
+	msi := &registry.MicroServiceInstance{
+		InstanceID:   ins.ID,
+		ServiceID:    serviceName,
+		HostName:     ins.Host,
+		Status:       strings.ToUpper(ins.Status),
+		EndpointsMap: make(map[string]string, len(ins.Endpoints)),
+		Metadata:     make(map[string]string, len(ins.Metadata)+3),
+	}
+	for p, ep := range ins.Endpoints {
+		msi.EndpointsMap[p] = ep
+	}
+	if msi.InstanceID == "" {
+		msi.InstanceID = ins.Endpoints[protocols[0]]
+	}
+	if msi.HostName == "" {
+		msi.HostName, _, _ = net.SplitHostPort(ins.Endpoints[protocols[0]])
 	}
+	if msi.Status == "" {
+		msi.Status = common.DefaultStatus
+	}
+	msi.DefaultProtocol = protocols[0]
+	if _, ok := msi.EndpointsMap[common.ProtocolRest]; ok {
+		msi.DefaultProtocol = common.ProtocolRest
+	}
+	msi.DefaultEndpoint = msi.EndpointsMap[msi.DefaultProtocol]
 
-	err = json.Unmarshal(plan, &data)
+	for k, v := range ins.Metadata {
+		msi.Metadata[k] = v
+	}
+	if ins.Version != "" {
+		msi.Metadata[common.BuildinTagVersion] = ins.Version
+	}
+	if ins.App != "" {
+		msi.Metadata[common.BuildinTagApp] = ins.App
+	}
+	if ins.Weight > 0 {
+		msi.Metadata[MetaWeight] = strconv.Itoa(ins.Weight)
+	}
+	if ins.Region != "" || ins.Zone != "" {
+		msi.DataCenterInfo = &registry.DataCenterInfo{
+			Name:          ins.Region,
+			Region:        ins.Region,
+			AvailableZone: ins.Zone,
+		}
+	}
+	return msi
+}
+
+// watch reloads the files when they change, the directories are watched because editors replace the files
+func (f *fileClient) watch() error {
+	w, err := fsnotify.NewWatcher()
 	if err != nil {
-		qlog.Warnf("failed to do unmarshall", err)
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, addr := range f.Addresses {
+		dir := addr
+		if st, err := os.Stat(addr); err != nil || !st.IsDir() {
+			dir = filepath.Dir(addr)
+		}
+		if dirs[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return fmt.Errorf("watch %s failed: %s", dir, err)
+		}
+		dirs[dir] = true
+	}
+	f.mu.Lock()
+	f.watcher = w
+	f.mu.Unlock()
+	go f.watchLoop(w)
+	return nil
+}
+
+func (f *fileClient) watchLoop(w *fsnotify.Watcher) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if !f.isServiceFile(e.Name) || e.Op == fsnotify.Chmod {
+				continue
+			}
+			if timer == nil {
+				timer = time.After(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			qlog.Warnf("watch service files failed: %s", err)
+		case <-timer:
+			timer = nil
+			if err := f.reload(); err != nil {
+				qlog.Warnf("reload service files failed, keep the last instances: %s", err)
+				continue
+			}
+			qlog.Info("service files reloaded")
+		}
 	}
+}
+
+// isServiceFile returns true if the file is an address or a service file in an address directory
+func (f *fileClient) isServiceFile(name string) bool {
+	for _, addr := range f.Addresses {
+		if filepath.Clean(name) == filepath.Clean(addr) {
+			return true
+		}
+		if filepath.Dir(name) == filepath.Clean(addr) {
+			switch filepath.Ext(name) {
+			case ".json", ".yaml", ".yml":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *fileClient) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher == nil {
+		return nil
+	}
+	err := f.watcher.Close()
+	f.watcher = nil
+	return err
+}
+
+// serviceNames returns the names of the services
+func (f *fileClient) serviceNames() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.services))
+	for name := range f.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	return data
+// FindMicroServiceInstances returns all instances of the service
+func (f *fileClient) FindMicroServiceInstances(microServiceName string) ([]*registry.MicroServiceInstance, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	instances, ok := f.services[microServiceName]
+	return instances, ok
 }
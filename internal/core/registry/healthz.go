@@ -127,9 +127,11 @@ func (hc *HealthChecker) doCheck(i *WrapInstance) <-chan checkResult {
 			Version:     i.Version,
 		}
 
+		// the instance is alive only if all endpoints are alive
 		for protocol, ep := range i.Instance.EndpointsMap {
-			r.Err = client.Test(ctx, protocol, ep, req)
-			return
+			if r.Err = client.Test(ctx, protocol, ep, req); r.Err != nil {
+				return
+			}
 		}
 	}()
 	return cr
@@ -147,7 +149,7 @@ func (hc *HealthChecker) removeFromCache(i *WrapInstance) {
 		}
 		is = append(is, inst)
 	}
-	MicroserviceInstanceIndex.Set(i.ServiceName, is)
+	SetInstances(i.ServiceName, is)
 	qlog.Tracef("Health check: cached [%d] Instances of service [%s]", len(is), i.ServiceName)
 }
 
@@ -170,7 +172,7 @@ func RefreshCache(service string, ups []*MicroServiceInstance, downs map[string]
 	c, ok := MicroserviceInstanceIndex.Get(service, nil)
 	if !ok || c == nil {
 		// if full new instances or at less one instance, then refresh simpleCache immediately
-		MicroserviceInstanceIndex.Set(service, ups)
+		SetInstances(service, ups)
 		return
 	}
 
@@ -229,9 +231,9 @@ func RefreshCache(service string, ups []*MicroServiceInstance, downs map[string]
 	lefts = append(lefts, saves...)
 	if len(lefts) == 0 {
 		//todo remove this when the simpleCache struct can delete the key if the input is an empty slice
-		MicroserviceInstanceIndex.Delete(service)
+		DeleteInstances(service)
 	} else {
-		MicroserviceInstanceIndex.Set(service, lefts)
+		SetInstances(service, lefts)
 	}
 
 	qlog.Tracef("Cached [%d] Instances of service [%s]", len(lefts), service)
@@ -0,0 +1,192 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// files mounted into the pods by the service account
+const (
+	serviceAccountDir   = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	serviceAccountToken = serviceAccountDir + "token"
+	serviceAccountCA    = serviceAccountDir + "ca.crt"
+	serviceAccountNS    = serviceAccountDir + "namespace"
+)
+
+// errGone means the resource version is too old, the watch must start from a new list
+var errGone = fmt.Errorf("resource version is gone")
+
+// apiClient is a minimal client of the kubernetes api server
+type apiClient struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+// newAPIClient creates the client of the address, or the in cluster api server if the address is empty
+func newAPIClient(addr string, tlsConfig *tls.Config) (*apiClient, error) {
+	c := &apiClient{
+		host:   strings.TrimSuffix(addr, "/"),
+		client: &http.Client{},
+	}
+	if token, err := ioutil.ReadFile(serviceAccountToken); err == nil {
+		c.token = strings.TrimSpace(string(token))
+	}
+	if c.host != "" {
+		if tlsConfig != nil {
+			c.client.Transport = &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			}
+		}
+		return c, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in kubernetes, set the api server address in ggs.service.registry.serviceDiscovery.address")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("read service account ca failed: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	c.host = "https://" + net.JoinHostPort(host, port)
+	c.client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+	return c, nil
+}
+
+// defaultNamespace returns the namespace of the pod, or default
+func defaultNamespace() string {
+	if ns, err := ioutil.ReadFile(serviceAccountNS); err == nil {
+		if s := strings.TrimSpace(string(ns)); s != "" {
+			return s
+		}
+	}
+	return "default"
+}
+
+func (c *apiClient) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errGone
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET %s failed, status: %d, body: %s", path, resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+func (c *apiClient) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func sliceSelector(service string) url.Values {
+	return url.Values{"labelSelector": []string{"kubernetes.io/service-name=" + service}}
+}
+
+// listSlices lists the endpoint slices of the service
+func (c *apiClient) listSlices(ctx context.Context, namespace, service string) (*endpointSliceList, error) {
+	list := &endpointSliceList{}
+	path := fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices", namespace)
+	if err := c.get(ctx, path, sliceSelector(service), list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// watchSlices watches the endpoint slices of the service from the resource version until the stream ends,
+// it returns the last resource version
+func (c *apiClient) watchSlices(ctx context.Context, namespace, service, rv string, f func(eventType string, s *endpointSlice)) (string, error) {
+	query := sliceSelector(service)
+	query.Set("watch", "true")
+	query.Set("resourceVersion", rv)
+	query.Set("allowWatchBookmarks", "true")
+	path := fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices", namespace)
+	resp, err := c.do(ctx, path, query)
+	if err != nil {
+		return rv, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e watchEvent
+		if err := dec.Decode(&e); err != nil {
+			if ctx.Err() != nil {
+				return rv, ctx.Err()
+			}
+			// the server closes the stream on timeout, watch again from the last version
+			return rv, nil
+		}
+		if e.Type == "ERROR" {
+			st := &status{}
+			if err := json.Unmarshal(e.Object, st); err == nil && st.Code == http.StatusGone {
+				return rv, errGone
+			}
+			return rv, fmt.Errorf("watch endpoint slices of %s failed: %s", service, e.Object)
+		}
+		s := &endpointSlice{}
+		if err := json.Unmarshal(e.Object, s); err != nil {
+			return rv, err
+		}
+		rv = s.Metadata.ResourceVersion
+		if e.Type == "BOOKMARK" {
+			continue
+		}
+		f(e.Type, s)
+	}
+}
+
+// getLabels returns the labels of the pod or node
+func (c *apiClient) getLabels(ctx context.Context, path string) (map[string]string, error) {
+	o := &object{}
+	if err := c.get(ctx, path, nil, o); err != nil {
+		return nil, err
+	}
+	return o.Metadata.Labels, nil
+}
+
+func podPath(namespace, name string) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name)
+}
+
+func nodePath(name string) string {
+	return "/api/v1/nodes/" + name
+}
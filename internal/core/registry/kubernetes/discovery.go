@@ -0,0 +1,484 @@
+// Package kubernetes is a service discovery plugin which watches the endpoint slices of the kubernetes services,
+// the named ports are the protocols of the instances, and the pod labels are the metadata
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// Name is the name of the plugin
+const Name = "kubernetes"
+
+// labels of the nodes
+const (
+	LabelZone   = "topology.kubernetes.io/zone"
+	LabelRegion = "topology.kubernetes.io/region"
+)
+
+// metadata keys set by the discovery besides the pod labels
+const (
+	MetaNodeName  = "nodeName"
+	MetaNamespace = "namespace"
+)
+
+const (
+	queryTimeout     = 10 * time.Second
+	minRetryInterval = 1 * time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+// Discovery watches the endpoint slices of the referenced services
+type Discovery struct {
+	client    *apiClient
+	namespace string
+
+	mu       sync.RWMutex
+	services map[string]*serviceWatch
+	closed   bool
+
+	// the labels of nodes, key is the node name
+	nodesMu sync.Mutex
+	nodes   map[string]map[string]string
+}
+
+// serviceWatch keeps the endpoint slices of a service
+type serviceWatch struct {
+	// key is the service name referenced by the caller, like svc or svc.namespace
+	key       string
+	namespace string
+	name      string
+	cancel    context.CancelFunc
+
+	mu        sync.RWMutex
+	slices    map[string]*endpointSlice
+	instances []*registry.MicroServiceInstance
+	// the labels of the pods in the slices, key is the pod uid or name
+	pods map[string]map[string]string
+}
+
+// newDiscovery creates the discovery of the api server address in the options, like the serviceDiscovery.address or
+// ggs.references.<service>.discoveryAddress, the in cluster api server is used if it is empty,
+// and the namespace is the tenant
+func newDiscovery(opts registry.Options) registry.ServiceDiscovery {
+	var host string
+	if addrs := registry.OwnAddrs(opts); len(addrs) > 0 {
+		host = opts.URL(addrs[0])
+	}
+	c, err := newAPIClient(host, opts.TLSConfig)
+	if err != nil {
+		qlog.Errorf("new kubernetes discovery failed: %s", err)
+		return nil
+	}
+	ns := opts.Tenant
+	if ns == "" || ns == "default" {
+		ns = defaultNamespace()
+	}
+	return newDiscoveryWithClient(c, ns)
+}
+
+// NewDiscovery creates the discovery of the api server address, e.g. a kubectl proxy or a fake server,
+// the token may be empty
+func NewDiscovery(host, namespace, token string) (*Discovery, error) {
+	if host == "" {
+		return nil, fmt.Errorf("api server address is empty")
+	}
+	c, err := newAPIClient(host, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.token = token
+	return newDiscoveryWithClient(c, namespace), nil
+}
+
+func newDiscoveryWithClient(c *apiClient, namespace string) *Discovery {
+	return &Discovery{
+		client:    c,
+		namespace: namespace,
+		services:  make(map[string]*serviceWatch),
+		nodes:     make(map[string]map[string]string),
+	}
+}
+
+// splitName splits the service name like svc or svc.namespace
+func (d *Discovery) splitName(microServiceName string) (string, string) {
+	if i := strings.Index(microServiceName, "."); i > 0 {
+		return microServiceName[:i], microServiceName[i+1:]
+	}
+	return microServiceName, d.namespace
+}
+
+// FindMicroServiceInstances returns the ready instances which have the tags, the service is listed and watched
+// when it is referenced for the first time
+func (d *Discovery) FindMicroServiceInstances(consumerID, microServiceName string, tags utiltags.Tags) ([]*registry.MicroServiceInstance, error) {
+	w, err := d.serviceWatch(microServiceName)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	all := w.instances
+	w.mu.RUnlock()
+	if len(tags.KV) == 0 {
+		return all, nil
+	}
+	instances := make([]*registry.MicroServiceInstance, 0, len(all))
+	for _, ins := range all {
+		if ins.Has(tags.KV) {
+			instances = append(instances, ins)
+		}
+	}
+	return instances, nil
+}
+
+// serviceWatch returns the watch of the service, it lists the service and starts the watch for the first time
+func (d *Discovery) serviceWatch(microServiceName string) (*serviceWatch, error) {
+	d.mu.RLock()
+	w, ok := d.services[microServiceName]
+	d.mu.RUnlock()
+	if ok {
+		return w, nil
+	}
+
+	name, ns := d.splitName(microServiceName)
+	w = &serviceWatch{
+		key:       microServiceName,
+		namespace: ns,
+		name:      name,
+		slices:    make(map[string]*endpointSlice),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	rv, err := d.list(ctx, w)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, fmt.Errorf("kubernetes discovery is closed")
+	}
+	if existing, ok := d.services[microServiceName]; ok {
+		return existing, nil
+	}
+	ctx, w.cancel = context.WithCancel(context.Background())
+	d.services[microServiceName] = w
+	go d.watch(ctx, microServiceName, w, rv)
+	return w, nil
+}
+
+// list replaces the slices of the service, and returns the resource version of the list
+func (d *Discovery) list(ctx context.Context, w *serviceWatch) (string, error) {
+	list, err := d.client.listSlices(ctx, w.namespace, w.name)
+	if err != nil {
+		return "", fmt.Errorf("list endpoint slices of %s/%s failed: %s", w.namespace, w.name, err)
+	}
+	slices := make(map[string]*endpointSlice, len(list.Items))
+	for k := range list.Items {
+		s := list.Items[k]
+		slices[s.Metadata.Name] = &s
+	}
+	w.mu.Lock()
+	w.slices = slices
+	w.mu.Unlock()
+	d.rebuild(ctx, w)
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch keeps the last known instances when the api server is unreachable, and retries with backoff
+func (d *Discovery) watch(ctx context.Context, microServiceName string, w *serviceWatch, rv string) {
+	retry := minRetryInterval
+	for {
+		var err error
+		if rv == "" {
+			rv, err = d.list(ctx, w)
+		}
+		start := time.Now()
+		if err == nil {
+			rv, err = d.client.watchSlices(ctx, w.namespace, w.name, rv, func(eventType string, s *endpointSlice) {
+				w.mu.Lock()
+				if eventType == "DELETED" {
+					delete(w.slices, s.Metadata.Name)
+				} else {
+					w.slices[s.Metadata.Name] = s
+				}
+				w.mu.Unlock()
+				d.rebuild(ctx, w)
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := retry
+		switch {
+		case err == errGone:
+			// list again right now
+			rv, wait = "", 0
+		case err == nil:
+			// the stream is closed by the server, watch again from the last version
+			retry = minRetryInterval
+			wait = 0
+			if time.Since(start) < minRetryInterval {
+				wait = minRetryInterval
+			}
+		default:
+			qlog.Warnf("watch endpoint slices of %s failed, keep the last known instances, retry after %s: %s",
+				microServiceName, retry, err)
+			retry *= 2
+			if retry > maxRetryInterval {
+				retry = maxRetryInterval
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// rebuild converts the slices to instances, the endpoints of the same pod in different slices are merged
+func (d *Discovery) rebuild(ctx context.Context, w *serviceWatch) {
+	w.mu.RLock()
+	slices := make([]*endpointSlice, 0, len(w.slices))
+	for _, s := range w.slices {
+		slices = append(slices, s)
+	}
+	oldPods := w.pods
+	w.mu.RUnlock()
+
+	// keep the labels of the pods still in the slices only
+	pods := make(map[string]map[string]string)
+	instances := make([]*registry.MicroServiceInstance, 0)
+	index := make(map[string]*registry.MicroServiceInstance)
+	for _, s := range slices {
+		for _, ep := range s.Endpoints {
+			if len(ep.Addresses) == 0 || !isReady(ep) {
+				continue
+			}
+			key := ep.Addresses[0]
+			if ep.TargetRef != nil && ep.TargetRef.UID != "" {
+				key = ep.TargetRef.UID
+			}
+			ins, ok := index[key]
+			if !ok {
+				ins = d.newInstance(ctx, w, ep, oldPods, pods)
+				index[key] = ins
+				instances = append(instances, ins)
+			}
+			for _, p := range s.Ports {
+				if p.Port == nil {
+					continue
+				}
+				addr := net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(*p.Port)))
+				protocol := portProtocol(p)
+				if protocol == "" {
+					// an unnamed port serves all protocols
+					ins.EndpointsMap[common.ProtocolRest] = addr
+					ins.EndpointsMap[common.ProtocolGrpc] = addr
+					continue
+				}
+				ins.EndpointsMap[protocol] = addr
+			}
+		}
+	}
+	for _, ins := range instances {
+		if _, ok := ins.EndpointsMap[common.ProtocolRest]; ok {
+			ins.DefaultProtocol = common.ProtocolRest
+		} else {
+			for p := range ins.EndpointsMap {
+				ins.DefaultProtocol = p
+				break
+			}
+		}
+		ins.DefaultEndpoint = ins.EndpointsMap[ins.DefaultProtocol]
+	}
+
+	w.mu.Lock()
+	w.instances = instances
+	w.pods = pods
+	w.mu.Unlock()
+	// the same service name may be in different namespaces, so the referenced name is the key
+	registry.SetInstances(w.key, instances)
+	qlog.Tracef("endpoint slices of %s/%s changed, %d instances", w.namespace, w.name, len(instances))
+}
+
+// newInstance creates the instance of the endpoint, the pod labels are the metadata,
+// and the zone and region of the node are the data center info
+func (d *Discovery) newInstance(ctx context.Context, w *serviceWatch, ep endpoint, oldPods, pods map[string]map[string]string) *registry.MicroServiceInstance {
+	ins := &registry.MicroServiceInstance{
+		InstanceID:   ep.Addresses[0],
+		ServiceID:    w.key,
+		HostName:     ep.Addresses[0],
+		Status:       common.DefaultStatus,
+		EndpointsMap: make(map[string]string),
+		Metadata:     map[string]string{MetaNamespace: w.namespace},
+	}
+	if ep.Hostname != nil {
+		ins.HostName = *ep.Hostname
+	}
+	if ref := ep.TargetRef; ref != nil && ref.Kind == "Pod" {
+		ins.InstanceID = ref.Name
+		ins.HostName = ref.Name
+		key := ref.UID
+		if key == "" {
+			key = ref.Name
+		}
+		labels, ok := oldPods[key]
+		if !ok {
+			ns := ref.Namespace
+			if ns == "" {
+				ns = w.namespace
+			}
+			labels = d.getLabels(ctx, podPath(ns, ref.Name))
+		}
+		pods[key] = labels
+		for k, v := range labels {
+			ins.Metadata[k] = v
+		}
+	}
+
+	var zone, region string
+	if ep.Zone != nil {
+		zone = *ep.Zone
+	}
+	if ep.NodeName != nil {
+		ins.Metadata[MetaNodeName] = *ep.NodeName
+		nodeLabels := d.nodeLabels(ctx, *ep.NodeName)
+		region = nodeLabels[LabelRegion]
+		if zone == "" {
+			zone = nodeLabels[LabelZone]
+		}
+	}
+	if zone != "" || region != "" {
+		ins.DataCenterInfo = &registry.DataCenterInfo{
+			Name:          region,
+			Region:        region,
+			AvailableZone: zone,
+		}
+	}
+	return ins
+}
+
+// nodeLabels returns the cached labels of the node
+func (d *Discovery) nodeLabels(ctx context.Context, name string) map[string]string {
+	d.nodesMu.Lock()
+	labels, ok := d.nodes[name]
+	d.nodesMu.Unlock()
+	if ok {
+		return labels
+	}
+
+	// cache the failure too, reading nodes needs the cluster role which may not be granted
+	labels = d.getLabels(ctx, nodePath(name))
+	d.nodesMu.Lock()
+	d.nodes[name] = labels
+	d.nodesMu.Unlock()
+	return labels
+}
+
+// getLabels returns the labels of the pod or node, nil if they can not be read, e.g. no permission,
+// the ctx of the watch has no deadline, so the request has its own timeout
+func (d *Discovery) getLabels(ctx context.Context, path string) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	labels, err := d.client.getLabels(ctx, path)
+	if err != nil {
+		qlog.Warnf("get labels of %s failed: %s", path, err)
+		return nil
+	}
+	return labels
+}
+
+func isReady(ep endpoint) bool {
+	// nil means ready
+	return ep.Conditions.Ready == nil || *ep.Conditions.Ready
+}
+
+// portProtocol maps the port to the protocol by its app protocol or name,
+// e.g. http, http-api and rest are rest, grpc and grpc-api are grpc, an empty name returns empty
+func portProtocol(p endpointPort) string {
+	name := ""
+	if p.AppProtocol != nil {
+		name = *p.AppProtocol
+	}
+	if name == "" && p.Name != nil {
+		name = *p.Name
+	}
+	name = strings.ToLower(name)
+	switch {
+	case name == "":
+		return ""
+	case name == "http" || name == "https" || name == "rest" ||
+		strings.HasPrefix(name, "http-") || strings.HasPrefix(name, "rest-"):
+		return common.ProtocolRest
+	case name == "grpc" || strings.HasPrefix(name, "grpc-"):
+		return common.ProtocolGrpc
+	}
+	return name
+}
+
+// GetMicroServiceID returns the service name as its id
+func (d *Discovery) GetMicroServiceID(appID, microServiceName, version, env string) (string, error) {
+	return microServiceName, nil
+}
+
+// GetAllMicroServices returns the referenced services
+func (d *Discovery) GetAllMicroServices() ([]*registry.MicroService, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	services := make([]*registry.MicroService, 0, len(d.services))
+	for name := range d.services {
+		services = append(services, &registry.MicroService{ServiceID: name, ServiceName: name, Status: common.DefaultStatus})
+	}
+	return services, nil
+}
+
+// GetMicroService returns the service if it has endpoint slices
+func (d *Discovery) GetMicroService(microServiceID string) (*registry.MicroService, error) {
+	w, err := d.serviceWatch(microServiceID)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if len(w.slices) == 0 {
+		return nil, fmt.Errorf("service %s not found", microServiceID)
+	}
+	return &registry.MicroService{ServiceID: microServiceID, ServiceName: microServiceID, Status: common.DefaultStatus}, nil
+}
+
+// GetMicroServiceInstances returns the ready instances of the provider
+func (d *Discovery) GetMicroServiceInstances(consumerID, providerID string) ([]*registry.MicroServiceInstance, error) {
+	return d.FindMicroServiceInstances(consumerID, providerID, utiltags.Tags{})
+}
+
+// AutoSync is noop, the services are watched when they are referenced
+func (d *Discovery) AutoSync() {}
+
+// Close stops the watches
+func (d *Discovery) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.services {
+		w.cancel()
+	}
+	d.services = make(map[string]*serviceWatch)
+	d.closed = true
+	return nil
+}
+
+func init() {
+	registry.InstallServiceDiscovery(Name, newDiscovery)
+}
@@ -0,0 +1,328 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+// fakeAPIServer serves the endpoint slices, pods and nodes, the watches receive the events sent by the test
+type fakeAPIServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	slices  map[string][]endpointSlice // key is namespace/service
+	pods    map[string]map[string]string
+	nodes   map[string]map[string]string
+	watches map[string]chan watchEvent
+	// the paths of the label requests
+	gets []string
+}
+
+// newFakeAPIServer starts the server, it is closed after the discoveries of the test stop watching
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	s := &fakeAPIServer{
+		slices:  make(map[string][]endpointSlice),
+		pods:    make(map[string]map[string]string),
+		nodes:   make(map[string]map[string]string),
+		watches: make(map[string]chan watchEvent),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeAPIServer) watch(namespace, service string) chan watchEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := namespace + "/" + service
+	if s.watches[key] == nil {
+		s.watches[key] = make(chan watchEvent, 10)
+	}
+	return s.watches[key]
+}
+
+func (s *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/apis/discovery.k8s.io/v1/namespaces/") && len(parts) == 6:
+		ns := parts[4]
+		service := strings.TrimPrefix(r.URL.Query().Get("labelSelector"), "kubernetes.io/service-name=")
+		if r.URL.Query().Get("watch") == "true" {
+			s.serveWatch(w, r, s.watch(ns, service))
+			return
+		}
+		s.mu.Lock()
+		list := endpointSliceList{Metadata: listMeta{ResourceVersion: "1"}, Items: s.slices[ns+"/"+service]}
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/") && len(parts) == 6:
+		s.serveLabels(w, r, s.pods, parts[3]+"/"+parts[5])
+	case strings.HasPrefix(r.URL.Path, "/api/v1/nodes/"):
+		s.serveLabels(w, r, s.nodes, parts[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeAPIServer) serveLabels(w http.ResponseWriter, r *http.Request, objects map[string]map[string]string, key string) {
+	s.mu.Lock()
+	s.gets = append(s.gets, r.URL.Path)
+	labels, ok := objects[key]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(object{Metadata: objectMeta{Labels: labels}})
+}
+
+func (s *fakeAPIServer) serveWatch(w http.ResponseWriter, r *http.Request, events chan watchEvent) {
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			enc.Encode(e)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (s *fakeAPIServer) labelGets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.gets...)
+}
+
+func strPtr(s string) *string { return &s }
+func int32Ptr(i int32) *int32 { return &i }
+func boolPtr(b bool) *bool    { return &b }
+
+func podEndpoint(ip, pod, node string, ready bool) endpoint {
+	return endpoint{
+		Addresses:  []string{ip},
+		Conditions: endpointConditions{Ready: boolPtr(ready)},
+		TargetRef:  &objectReference{Kind: "Pod", Name: pod, UID: pod + "-uid"},
+		NodeName:   strPtr(node),
+	}
+}
+
+func slice(name, rv string, eps ...endpoint) endpointSlice {
+	return endpointSlice{
+		Metadata:  objectMeta{Name: name, ResourceVersion: rv},
+		Endpoints: eps,
+		Ports: []endpointPort{
+			{Name: strPtr("http"), Port: int32Ptr(8080)},
+			{Name: strPtr("grpc-api"), Port: int32Ptr(9090)},
+		},
+	}
+}
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestDiscovery(t *testing.T, s *fakeAPIServer, namespace string) *Discovery {
+	d, err := NewDiscovery(s.URL, namespace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.DefaultServiceDiscoveryService = d
+	t.Cleanup(func() {
+		d.Close()
+		registry.DefaultServiceDiscoveryService = nil
+	})
+	return d
+}
+
+func TestFindMicroServiceInstances(t *testing.T) {
+	s := newFakeAPIServer(t)
+	s.slices["default/foo"] = []endpointSlice{slice("foo-abc", "1",
+		podEndpoint("10.0.0.1", "foo-1", "node-1", true),
+		podEndpoint("10.0.0.2", "foo-2", "node-1", false),
+	)}
+	s.pods["default/foo-1"] = map[string]string{"version": "1.2.0", "env": "gray"}
+	s.nodes["node-1"] = map[string]string{LabelRegion: "cn-east", LabelZone: "az1"}
+	d := newTestDiscovery(t, s, "default")
+
+	instances, err := d.FindMicroServiceInstances("", "foo", utiltags.Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("want 1 ready instance, got %d", len(instances))
+	}
+	ins := instances[0]
+	if ins.InstanceID != "foo-1" || ins.ServiceID != "foo" {
+		t.Errorf("unexpected instance %s of service %s", ins.InstanceID, ins.ServiceID)
+	}
+	if ins.EndpointsMap[common.ProtocolRest] != "10.0.0.1:8080" || ins.EndpointsMap[common.ProtocolGrpc] != "10.0.0.1:9090" {
+		t.Errorf("unexpected endpoints %v", ins.EndpointsMap)
+	}
+	if ins.DefaultProtocol != common.ProtocolRest {
+		t.Errorf("want default protocol rest, got %s", ins.DefaultProtocol)
+	}
+	if ins.Metadata["env"] != "gray" || ins.Metadata[MetaNamespace] != "default" || ins.Metadata[MetaNodeName] != "node-1" {
+		t.Errorf("unexpected metadata %v", ins.Metadata)
+	}
+	if dc := ins.DataCenterInfo; dc == nil || dc.Region != "cn-east" || dc.AvailableZone != "az1" {
+		t.Errorf("unexpected data center %+v", dc)
+	}
+
+	tagged, err := d.FindMicroServiceInstances("", "foo", utiltags.Tags{KV: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tagged) != 0 {
+		t.Errorf("want no instance with env=prod, got %d", len(tagged))
+	}
+}
+
+func TestWatchNamespacedService(t *testing.T) {
+	s := newFakeAPIServer(t)
+	s.slices["ns1/foo"] = []endpointSlice{slice("foo-1", "1", podEndpoint("10.0.1.1", "foo-a", "node-1", true))}
+	s.slices["ns2/foo"] = []endpointSlice{slice("foo-2", "1", podEndpoint("10.0.2.1", "foo-b", "node-1", true))}
+	d := newTestDiscovery(t, s, "default")
+
+	events := make(chan registry.Event, 10)
+	stop := registry.Watch("foo.ns2", func(e registry.Event) { events <- e })
+	defer stop()
+
+	ns1, err := d.FindMicroServiceInstances("", "foo.ns1", utiltags.Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns2, err := d.FindMicroServiceInstances("", "foo.ns2", utiltags.Tags{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns1) != 1 || ns1[0].InstanceID != "foo-a" || ns1[0].ServiceID != "foo.ns1" {
+		t.Fatalf("unexpected instances of foo.ns1: %v", ns1)
+	}
+	if len(ns2) != 1 || ns2[0].InstanceID != "foo-b" || ns2[0].ServiceID != "foo.ns2" {
+		t.Fatalf("unexpected instances of foo.ns2: %v", ns2)
+	}
+	expectEvent(t, events, registry.EventAdded, "foo-b")
+
+	// a new ready pod is added to the slice of ns2
+	updated := slice("foo-2", "2",
+		podEndpoint("10.0.2.1", "foo-b", "node-1", true),
+		podEndpoint("10.0.2.2", "foo-c", "node-1", true),
+	)
+	raw, _ := json.Marshal(updated)
+	s.watch("ns2", "foo") <- watchEvent{Type: "MODIFIED", Object: raw}
+	expectEvent(t, events, registry.EventAdded, "foo-c")
+
+	ns1, _ = d.FindMicroServiceInstances("", "foo.ns1", utiltags.Tags{})
+	if len(ns1) != 1 {
+		t.Errorf("the change of ns2 must not change ns1, got %d instances", len(ns1))
+	}
+}
+
+func TestLabelsAreCached(t *testing.T) {
+	s := newFakeAPIServer(t)
+	s.slices["default/foo"] = []endpointSlice{slice("foo-abc", "1", podEndpoint("10.0.0.1", "foo-1", "node-1", true))}
+	s.pods["default/foo-1"] = map[string]string{"env": "gray"}
+	d := newTestDiscovery(t, s, "default")
+
+	if _, err := d.FindMicroServiceInstances("", "foo", utiltags.Tags{}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(slice("foo-abc", "2", podEndpoint("10.0.0.1", "foo-1", "node-1", true)))
+	s.watch("default", "foo") <- watchEvent{Type: "MODIFIED", Object: raw}
+	waitFor(t, func() bool {
+		d.mu.RLock()
+		w := d.services["foo"]
+		d.mu.RUnlock()
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		return w.slices["foo-abc"].Metadata.ResourceVersion == "2"
+	})
+
+	// the pod is read once, the node has no labels and is read once too
+	if gets := s.labelGets(); len(gets) != 2 {
+		t.Errorf("want the pod and the node read once, got %v", gets)
+	}
+}
+
+func TestReferencesWithOwnAddress(t *testing.T) {
+	s1 := newFakeAPIServer(t)
+	s1.slices["team1/foo"] = []endpointSlice{slice("foo-1", "1", podEndpoint("10.0.1.1", "foo-a", "node-1", true))}
+	s2 := newFakeAPIServer(t)
+	s2.slices["team2/bar"] = []endpointSlice{slice("bar-1", "1", podEndpoint("10.0.2.1", "bar-a", "node-1", true))}
+	for service, addr := range map[string]string{"foo": s1.URL, "bar": s2.URL} {
+		archaius.Set("ggs.references."+service+".discovery", Name)
+		archaius.Set("ggs.references."+service+".discoveryAddress", addr)
+	}
+	archaius.Set("ggs.references.foo.discoveryTenant", "team1")
+	archaius.Set("ggs.references.bar.discoveryTenant", "team2")
+
+	foo, err := registry.GetServiceDiscovery("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { foo.Close() })
+	bar, err := registry.GetServiceDiscovery("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bar.Close() })
+	if foo == bar {
+		t.Fatal("the references with different addresses share the discovery")
+	}
+
+	for service, want := range map[string]string{"foo": "foo-a", "bar": "bar-a"} {
+		sd, _ := registry.GetServiceDiscovery(service)
+		instances, err := sd.FindMicroServiceInstances("", service, utiltags.Tags{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != 1 || instances[0].InstanceID != want {
+			t.Errorf("want %s of %s from its own api server, got %v", want, service, instances)
+		}
+	}
+}
+
+func expectEvent(t *testing.T, events chan registry.Event, typ, instanceID string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ && e.Instance.InstanceID == instanceID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %s event of %s", typ, instanceID)
+		}
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,76 @@
+package kubernetes
+
+import "encoding/json"
+
+// the subset of the kubernetes api objects used by the discovery
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	UID             string            `json:"uid"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+}
+
+type listMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// endpointSlice is discovery.k8s.io/v1 EndpointSlice
+type endpointSlice struct {
+	Metadata    objectMeta     `json:"metadata"`
+	AddressType string         `json:"addressType"`
+	Endpoints   []endpoint     `json:"endpoints"`
+	Ports       []endpointPort `json:"ports"`
+}
+
+type endpointSliceList struct {
+	Metadata listMeta        `json:"metadata"`
+	Items    []endpointSlice `json:"items"`
+}
+
+type endpoint struct {
+	Addresses  []string           `json:"addresses"`
+	Conditions endpointConditions `json:"conditions"`
+	Hostname   *string            `json:"hostname"`
+	TargetRef  *objectReference   `json:"targetRef"`
+	NodeName   *string            `json:"nodeName"`
+	Zone       *string            `json:"zone"`
+}
+
+type endpointConditions struct {
+	Ready       *bool `json:"ready"`
+	Terminating *bool `json:"terminating"`
+}
+
+type endpointPort struct {
+	Name        *string `json:"name"`
+	Protocol    *string `json:"protocol"`
+	Port        *int32  `json:"port"`
+	AppProtocol *string `json:"appProtocol"`
+}
+
+type objectReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+}
+
+// object is a pod or a node, only the metadata is used
+type object struct {
+	Metadata objectMeta `json:"metadata"`
+}
+
+// watchEvent is an event of the watch stream, the object is a status in ERROR events
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// status is returned in ERROR events and failed requests
+type status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
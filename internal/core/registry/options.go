@@ -2,7 +2,11 @@ package registry
 
 import (
 	"crypto/tls"
+	"reflect"
+	"strings"
 	"time"
+
+	"github.com/leon-yc/ggs/internal/core/config"
 )
 
 // Options having micro-service parameters
@@ -17,3 +21,34 @@ type Options struct {
 	Version    string
 	ConfigPath string
 }
+
+//OwnAddrs returns the addresses in the options without the empty ones. The options of the default discovery
+//have the registry address if serviceDiscovery.address is empty, it is dropped too, so the plugins which are
+//not a registry center, like dns and kubernetes, use their own default address
+func OwnAddrs(opts Options) []string {
+	addrs := make([]string, 0, len(opts.Addrs))
+	for _, addr := range opts.Addrs {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 || config.GlobalDefinition.Ggs.Service.Registry.ServiceDiscovery.Address != "" {
+		return addrs
+	}
+	registryAddrs, _, err := URIs2Hosts(strings.Split(config.GetServiceDiscoveryAddress(), ","))
+	if err == nil && reflect.DeepEqual(addrs, registryAddrs) {
+		return nil
+	}
+	return addrs
+}
+
+//URL returns the address with the scheme, it is https if the options have the tls config
+func (o Options) URL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	if o.TLSConfig != nil {
+		return "https://" + addr
+	}
+	return "http://" + addr
+}
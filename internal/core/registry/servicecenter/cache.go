@@ -230,7 +230,7 @@ func (c *CacheManager) compareAndDeleteOutdatedProviders(newProviders sets.Strin
 	oldProviders := registry.MicroserviceInstanceIndex.FullCache().Items()
 	for old := range oldProviders {
 		if !newProviders.Has(old) { //provider is outdated, delete it
-			registry.MicroserviceInstanceIndex.Delete(old)
+			registry.DeleteInstances(old)
 		}
 	}
 }
@@ -328,7 +328,7 @@ func createAction(response *client.MicroServiceInstanceChangedEvent) {
 	}
 	msi := ToMicroServiceInstance(response.Instance).WithAppID(response.Key.AppID)
 	microServiceInstances = append(microServiceInstances, msi)
-	registry.MicroserviceInstanceIndex.Set(key, microServiceInstances)
+	registry.SetInstances(key, microServiceInstances)
 	qlog.Tracef("Cached Instances,action is EVT_CREATE, sid = %s, instances length = %d", response.Instance.ServiceId, len(microServiceInstances))
 }
 
@@ -351,7 +351,7 @@ func deleteAction(response *client.MicroServiceInstanceChangedEvent) {
 		}
 	}
 
-	registry.MicroserviceInstanceIndex.Set(key, newInstances)
+	registry.SetInstances(key, newInstances)
 	qlog.Tracef("Cached [%d] Instances of service [%s]", len(newInstances), key)
 }
 
@@ -386,6 +386,6 @@ func updateAction(response *client.MicroServiceInstanceChangedEvent) {
 	default:
 		qlog.Warnf("updateAction error, iid:%s", response.Instance.InstanceId)
 	}
-	registry.MicroserviceInstanceIndex.Set(key, microServiceInstances)
+	registry.SetInstances(key, microServiceInstances)
 	qlog.Tracef("Cached Instances,action is EVT_UPDATE, sid = %s, instances length = %d", response.Instance.ServiceId, len(microServiceInstances))
 }
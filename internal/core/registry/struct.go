@@ -1,6 +1,13 @@
 package registry
 
-import "github.com/leon-yc/ggs/internal/core/common"
+import (
+	"strconv"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+)
+
+// MetaWeight is the metadata key of the instance weight
+const MetaWeight = "weight"
 
 // MicroService struct having full info about micro-service
 type MicroService struct {
@@ -57,6 +64,19 @@ func (m *MicroServiceInstance) Has(tags map[string]string) bool {
 	return true
 }
 
+// Weight returns the weight in the metadata, ok is false if the weight is not set or invalid
+func (m *MicroServiceInstance) Weight() (w int, ok bool) {
+	v, ok := m.Metadata[MetaWeight]
+	if !ok {
+		return 0, false
+	}
+	w, err := strconv.Atoi(v)
+	if err != nil || w < 0 {
+		return 0, false
+	}
+	return w, true
+}
+
 // WithAppID add app tag for microservice instance
 func (m *MicroServiceInstance) WithAppID(v string) *MicroServiceInstance {
 	m.Metadata[common.BuildinTagApp] = v
@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"reflect"
+	"sync"
+
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// the types of the registry events
+const (
+	EventAdded   = "ADDED"
+	EventRemoved = "REMOVED"
+	EventUpdated = "UPDATED"
+)
+
+// Event is a change of an instance of the service
+type Event struct {
+	Type    string
+	Service string
+	//Instance is the new instance, it is the removed instance for EventRemoved
+	Instance *MicroServiceInstance
+	//Old is the instance before the update, it is nil for EventAdded and EventRemoved
+	Old *MicroServiceInstance
+	//Count is the number of the instances after the change, 0 means the service has no instance
+	Count int
+	//Replay is true for the EventAdded of the instances known when the watch begins
+	Replay bool
+}
+
+// watcher delivers the events in order by its own goroutine, so a slow or blocking f
+// does not block the discovery plugins and the events are never reordered
+type watcher struct {
+	f func(Event)
+
+	mu     sync.Mutex
+	queue  []Event
+	wake   chan struct{}
+	stopCh chan struct{}
+}
+
+func newWatcher(f func(Event)) *watcher {
+	w := &watcher{
+		f:      f,
+		wake:   make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// push queues the events, it never blocks
+func (w *watcher) push(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.queue = append(w.queue, events...)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) loop() {
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-w.wake:
+		}
+		for {
+			w.mu.Lock()
+			if len(w.queue) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			e := w.queue[0]
+			w.queue[0] = Event{}
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+
+			select {
+			case <-w.stopCh:
+				return
+			default:
+			}
+			w.f(e)
+		}
+	}
+}
+
+var (
+	// watchMu serializes the changes of the instances, so the index, the snapshots and the queues of the watchers
+	// see the changes in the same order
+	watchMu sync.Mutex
+	// service name to the instances last notified by the discovery plugins
+	snapshots = make(map[string]map[string]*MicroServiceInstance)
+	watchers  = make(map[string][]*watcher)
+)
+
+//Watch calls f when the instances of the service are added, removed or updated, the known instances are
+//reported as added first, f is called in order by a goroutine of the watch,
+//it returns the function to stop the watch
+func Watch(service string, f func(Event)) func() {
+	w := newWatcher(f)
+	watchMu.Lock()
+	known := snapshots[service]
+	replay := make([]Event, 0, len(known))
+	for _, ins := range known {
+		replay = append(replay, Event{Type: EventAdded, Service: service, Instance: ins, Count: len(known), Replay: true})
+	}
+	// the replay is queued before any later change, as the changes are queued with the lock held too
+	w.push(replay...)
+	watchers[service] = append(watchers[service], w)
+	watchMu.Unlock()
+
+	// the plugins which discover the services lazily start to sync the service
+	go func() {
+		sd, err := GetServiceDiscovery(service)
+		if err != nil {
+			return
+		}
+		if _, err := sd.FindMicroServiceInstances("", service, utiltags.Tags{}); err != nil {
+			qlog.Warnf("watch service %s: %s", service, err)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			watchMu.Lock()
+			defer watchMu.Unlock()
+			ws := watchers[service]
+			for i := range ws {
+				if ws[i] == w {
+					watchers[service] = append(ws[:i:i], ws[i+1:]...)
+					break
+				}
+			}
+			if len(watchers[service]) == 0 {
+				delete(watchers, service)
+			}
+			close(w.stopCh)
+		})
+	}
+}
+
+//SetInstances saves all instances of the service to the instance index and notifies the watchers,
+//the discovery plugins and the health checker feed the instances only by it, so the index and the events agree
+func SetInstances(service string, instances []*MicroServiceInstance) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if MicroserviceInstanceIndex != nil {
+		MicroserviceInstanceIndex.Set(service, instances)
+	}
+	notifyLocked(service, instances)
+}
+
+//DeleteInstances removes the service from the instance index, the watchers receive the removal of all instances
+func DeleteInstances(service string) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if MicroserviceInstanceIndex != nil {
+		MicroserviceInstanceIndex.Delete(service)
+	}
+	notifyLocked(service, nil)
+}
+
+//NotifyInstances is called by the discovery plugins which do not use the instance index with all instances
+//of the service when they change, the differences with the last instances are reported to the watchers
+func NotifyInstances(service string, instances []*MicroServiceInstance) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	notifyLocked(service, instances)
+}
+
+// notifyLocked must be called with watchMu held
+func notifyLocked(service string, instances []*MicroServiceInstance) {
+	current := make(map[string]*MicroServiceInstance, len(instances))
+	for _, ins := range instances {
+		current[ins.InstanceID] = ins
+	}
+
+	last := snapshots[service]
+	if len(current) == 0 {
+		delete(snapshots, service)
+	} else {
+		snapshots[service] = current
+	}
+	ws := watchers[service]
+	if len(ws) == 0 {
+		return
+	}
+
+	var events []Event
+	for id, old := range last {
+		if _, ok := current[id]; !ok {
+			events = append(events, Event{Type: EventRemoved, Service: service, Instance: old, Count: len(current)})
+		}
+	}
+	for id, ins := range current {
+		old, ok := last[id]
+		if !ok {
+			events = append(events, Event{Type: EventAdded, Service: service, Instance: ins, Count: len(current)})
+		} else if old != ins && !reflect.DeepEqual(old, ins) {
+			events = append(events, Event{Type: EventUpdated, Service: service, Instance: ins, Old: old, Count: len(current)})
+		}
+	}
+	for _, w := range ws {
+		w.push(events...)
+	}
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/config"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func instances(ids ...string) []*MicroServiceInstance {
+	is := make([]*MicroServiceInstance, 0, len(ids))
+	for _, id := range ids {
+		is = append(is, &MicroServiceInstance{InstanceID: id, EndpointsMap: map[string]string{"rest": id}})
+	}
+	return is
+}
+
+func TestWatchReplaysBeforeChanges(t *testing.T) {
+	SetInstances("replay", instances("a"))
+	defer DeleteInstances("replay")
+
+	events := make(chan Event, 10)
+	stop := Watch("replay", func(e Event) { events <- e })
+	defer stop()
+	SetInstances("replay", nil)
+
+	want := []string{EventAdded + " a", EventRemoved + " a"}
+	for i, w := range want {
+		select {
+		case e := <-events:
+			if got := e.Type + " " + e.Instance.InstanceID; got != w {
+				t.Fatalf("want %s, got %s", w, got)
+			}
+			if e.Replay != (i == 0) {
+				t.Fatalf("want replay %v for %s", i == 0, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event %s", w)
+		}
+	}
+}
+
+func TestWatchDeliversInOrder(t *testing.T) {
+	defer DeleteInstances("ordered")
+	events := make(chan Event, 1000)
+	stop := Watch("ordered", func(e Event) {
+		// a slow watcher must not reorder or lose the events
+		time.Sleep(time.Microsecond)
+		events <- e
+	})
+	defer stop()
+
+	const n = 200
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			SetInstances("ordered", instances(fmt.Sprint(i)))
+		}
+		close(done)
+	}()
+	<-done
+
+	// each change replaces the instance, so the watcher sees added i, removed i-1 alternately
+	next := 0
+	for next < n {
+		select {
+		case e := <-events:
+			if e.Type == EventAdded {
+				if e.Instance.InstanceID != fmt.Sprint(next) {
+					t.Fatalf("want instance %d added, got %s", next, e.Instance.InstanceID)
+				}
+				next++
+			} else if e.Type == EventRemoved && e.Instance.InstanceID != fmt.Sprint(next-1) {
+				t.Fatalf("want instance %d removed, got %s", next-1, e.Instance.InstanceID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event after instance %d", next-1)
+		}
+	}
+}
+
+func TestStopWatch(t *testing.T) {
+	defer DeleteInstances("stopped")
+	events := make(chan Event, 10)
+	stop := Watch("stopped", func(e Event) { events <- e })
+	stop()
+	stop()
+	SetInstances("stopped", instances("a"))
+
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %s of %s after stop", e.Type, e.Instance.InstanceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -24,6 +24,7 @@ type Options struct {
 type RegisterOptions struct {
 	SchemaID   string
 	ServerName string
+	ChainName  string
 	// grpc 相关
 	SvcDesc    *grpc.ServiceDesc
 	UnaryInts  []grpc.UnaryServerInterceptor
@@ -41,3 +42,45 @@ func NewRegisterOptions(options ...RegisterOption) RegisterOptions {
 	}
 	return opts
 }
+
+//WithSchemaID set schemaID parameter, e.g. ggs.RegisterSchema("rest", &HelloService{}, server.WithSchemaID("hello"))
+func WithSchemaID(id string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.SchemaID = id
+	}
+}
+
+//WithServerName set the server name, e.g. rest-admin
+func WithServerName(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.ServerName = name
+	}
+}
+
+//WithRPCServiceDesc set the *grpc.ServiceDesc of the registered grpc service
+func WithRPCServiceDesc(desc *grpc.ServiceDesc) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.SvcDesc = desc
+	}
+}
+
+//WithUnaryInterceptors append unary interceptors which only apply to the registered grpc service
+func WithUnaryInterceptors(ints ...grpc.UnaryServerInterceptor) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.UnaryInts = append(o.UnaryInts, ints...)
+	}
+}
+
+//WithStreamInterceptors append stream interceptors which only apply to the registered grpc service
+func WithStreamInterceptors(ints ...grpc.StreamServerInterceptor) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.StreamInts = append(o.StreamInts, ints...)
+	}
+}
+
+//WithChain set the provider chain of the registered schema or gin group
+func WithChain(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.ChainName = name
+	}
+}
@@ -15,4 +15,6 @@ type GinServer interface {
 	ProtocolServer
 	//Engine return the *gin.Engine
 	Engine() interface{}
+	//Group return a *gin.RouterGroup, the chain of the group can be set by WithChain
+	Group(string, ...RegisterOption) interface{}
 }
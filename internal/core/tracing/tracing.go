@@ -6,4 +6,6 @@ const (
 	HTTPPath       = "http.path"
 	HTTPStatusCode = "http.status_code"
 	HTTPHost       = "http.host"
+	GrpcSentMsgs   = "grpc.sent_msgs"
+	GrpcRecvMsgs   = "grpc.recv_msgs"
 )
\ No newline at end of file
@@ -23,4 +23,6 @@ func Init() {
 	//RegisterKeys(lbEventListener, LoadBalanceKey)
 	//RegisterKeys(&LoggerEventListener{}, LoggerLevelKey)
 
+	RegisterKeys(&HandlerChainEventListener{}, HandlerChainKey)
+
 }
@@ -0,0 +1,33 @@
+package eventlistener
+
+import (
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/leon-yc/ggs/pkg/qlog"
+	"github.com/go-chassis/go-archaius/event"
+)
+
+// HandlerChainKey is the key of handler chain events
+const HandlerChainKey = "^ggs\\.handler\\.chain\\."
+
+//HandlerChainEventListener rebuilds the handler chains when they are changed
+type HandlerChainEventListener struct {
+	Key string
+}
+
+//Event is a method used to handle a handler chain event
+func (e *HandlerChainEventListener) Event(evt *event.Event) {
+	qlog.Infof("handler chain event, key: %s, type: %s", evt.Key, evt.EventType)
+	chains, err := config.ReadHandlerChainFromArchaius()
+	if err != nil {
+		qlog.Error("can not unmarshal new handler chain config: " + err.Error())
+		return
+	}
+	if err := handler.ReloadChains(common.Provider, chains.Provider); err != nil {
+		qlog.Error("can not reload provider chains: " + err.Error())
+	}
+	if err := handler.ReloadChains(common.Consumer, chains.Consumer); err != nil {
+		qlog.Error("can not reload consumer chains: " + err.Error())
+	}
+}
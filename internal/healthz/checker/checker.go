@@ -0,0 +1,300 @@
+// Package checker probes the instances of the referenced services continuously, the instances which fail
+// the probes successively are ejected from the instance index and the load balancer until they recover
+package checker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/healthz/client"
+	"github.com/leon-yc/ggs/pkg/metrics"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// the results and actions of the metrics
+const (
+	resultHealthy   = "healthy"
+	resultUnhealthy = "unhealthy"
+	actionEject     = "eject"
+	actionReadmit   = "readmit"
+)
+
+// instanceState is the successive results of an instance
+type instanceState struct {
+	instance  *registry.MicroServiceInstance
+	failures  int
+	successes int
+	ejected   bool
+}
+
+// serviceChecker checks the instances of a service in a goroutine, until ctx is canceled
+type serviceChecker struct {
+	service string
+	states  map[string]*instanceState
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+var (
+	mu       sync.RWMutex
+	checkers = make(map[string]*serviceChecker)
+)
+
+//Watch starts the health check of the service if ggs.healthCheck.<service>.enabled is true,
+//it is noop if the service is checked already
+func Watch(service string) {
+	mu.RLock()
+	_, ok := checkers[service]
+	mu.RUnlock()
+	if ok || !config.HealthCheckEnabled(service) {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := checkers[service]; ok {
+		return
+	}
+	sc := &serviceChecker{
+		service: service,
+		states:  make(map[string]*instanceState),
+		stopped: make(chan struct{}),
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	checkers[service] = sc
+	go sc.run()
+	qlog.Infof("start health check of service %s", service)
+}
+
+//Unwatch stops the health check of the service and readmits the instances ejected by it
+func Unwatch(service string) {
+	mu.Lock()
+	sc, ok := checkers[service]
+	delete(checkers, service)
+	mu.Unlock()
+	if ok {
+		sc.cancel()
+		<-sc.stopped
+	}
+}
+
+//Close stops the health check of all services
+func Close() {
+	mu.Lock()
+	all := checkers
+	checkers = make(map[string]*serviceChecker)
+	mu.Unlock()
+	for _, sc := range all {
+		sc.cancel()
+	}
+	for _, sc := range all {
+		<-sc.stopped
+	}
+}
+
+func (sc *serviceChecker) run() {
+	defer close(sc.stopped)
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-sc.ctx.Done():
+			sc.stop()
+			return
+		case <-t.C:
+		}
+		if !config.HealthCheckEnabled(sc.service) {
+			sc.stop()
+			return
+		}
+		sc.check()
+		t.Reset(config.HealthCheckInterval(sc.service))
+	}
+}
+
+// stop readmits the ejected instances, the service is checked again by the next Watch
+func (sc *serviceChecker) stop() {
+	mu.Lock()
+	if checkers[sc.service] == sc {
+		delete(checkers, sc.service)
+	}
+	mu.Unlock()
+	sc.cancel()
+	for _, st := range sc.states {
+		if st.ejected {
+			sc.readmit(st)
+		}
+	}
+	qlog.Infof("stop health check of service %s", sc.service)
+}
+
+// check probes all instances once, and ejects or readmits them by the thresholds
+func (sc *serviceChecker) check() {
+	// the ejected instances are probed too, so they are readmitted when they recover
+	instances, err := registry.FindAllInstances(sc.service)
+	if err != nil {
+		qlog.Warnf("health check of service %s failed: %s", sc.service, err)
+		return
+	}
+
+	probe := config.HealthCheckProbe(sc.service)
+	opts := client.ProbeOptions{
+		Path:    config.HealthCheckPath(sc.service),
+		Service: config.HealthCheckGrpcService(sc.service),
+	}
+	timeout := config.HealthCheckTimeout(sc.service)
+	errs := make([]error, len(instances))
+	var wg sync.WaitGroup
+	for i, ins := range instances {
+		wg.Add(1)
+		go func(i int, ins *registry.MicroServiceInstance) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(sc.ctx, timeout)
+			defer cancel()
+			errs[i] = probeInstance(ctx, ins, probe, opts)
+		}(i, ins)
+	}
+	wg.Wait()
+	// the probes canceled by Unwatch are not failures
+	if sc.ctx.Err() != nil {
+		return
+	}
+
+	alive := make(map[string]bool, len(instances))
+	for i, ins := range instances {
+		alive[ins.InstanceID] = true
+		st, ok := sc.states[ins.InstanceID]
+		if !ok {
+			st = &instanceState{}
+			sc.states[ins.InstanceID] = st
+		}
+		st.instance = ins
+		sc.update(st, errs[i])
+	}
+	// the instances removed from the registry need no readmission
+	for id, st := range sc.states {
+		if alive[id] {
+			continue
+		}
+		if st.ejected {
+			registry.ForgetEjectedInstance(sc.service, id, registry.EjectByHealthCheck)
+		}
+		delete(sc.states, id)
+	}
+	metrics.GaugeSet(metrics.HealthCheckEjected, float64(sc.ejectedCount()),
+		map[string]string{metrics.HealthCheckService: sc.service})
+}
+
+// update records the result, and ejects or readmits the instance when a threshold is reached
+func (sc *serviceChecker) update(st *instanceState, err error) {
+	result := resultHealthy
+	if err != nil {
+		result = resultUnhealthy
+	}
+	metrics.CounterAdd(metrics.HealthCheckCount, 1, map[string]string{
+		metrics.HealthCheckService: sc.service,
+		metrics.HealthCheckProbe:   probeName(st.instance, config.HealthCheckProbe(sc.service)),
+		metrics.HealthCheckResult:  result,
+	})
+
+	if err != nil {
+		st.successes = 0
+		st.failures++
+		qlog.Tracef("health check of instance %s of service %s failed %d times: %s",
+			st.instance.InstanceID, sc.service, st.failures, err)
+		if !st.ejected && st.failures >= config.UnhealthyThreshold(sc.service) {
+			st.ejected = true
+			if registry.EjectInstance(sc.service, st.instance, registry.EjectByHealthCheck) {
+				metrics.CounterAdd(metrics.HealthCheckEjections, 1, map[string]string{
+					metrics.HealthCheckService: sc.service,
+					metrics.HealthCheckAction:  actionEject,
+				})
+			}
+		}
+		return
+	}
+	st.failures = 0
+	st.successes++
+	if st.ejected && st.successes >= config.HealthyThreshold(sc.service) {
+		sc.readmit(st)
+	}
+}
+
+func (sc *serviceChecker) readmit(st *instanceState) {
+	st.ejected = false
+	if registry.ReadmitInstance(sc.service, st.instance, registry.EjectByHealthCheck) {
+		metrics.CounterAdd(metrics.HealthCheckEjections, 1, map[string]string{
+			metrics.HealthCheckService: sc.service,
+			metrics.HealthCheckAction:  actionReadmit,
+		})
+	}
+}
+
+func (sc *serviceChecker) ejectedCount() int {
+	n := 0
+	for _, st := range sc.states {
+		if st.ejected {
+			n++
+		}
+	}
+	return n
+}
+
+// probeInstance probes the default endpoint with the configured probe, or all endpoints with the probes
+// of their protocols if no probe is configured, the instance is healthy only if all endpoints are healthy.
+// An address listed by several protocols, like the dns records, is probed once, by tcp if their probes differ
+func probeInstance(ctx context.Context, ins *registry.MicroServiceInstance, probe string, opts client.ProbeOptions) error {
+	if probe != "" {
+		p, err := client.GetProbe(probe)
+		if err != nil {
+			return err
+		}
+		ep := ins.DefaultEndpoint
+		if ep == "" {
+			for _, v := range ins.EndpointsMap {
+				ep = v
+				break
+			}
+		}
+		return p(ctx, address(ep), opts)
+	}
+	probes := make(map[string]string, len(ins.EndpointsMap))
+	for protocol, ep := range ins.EndpointsMap {
+		name := client.DefaultProbe(protocol)
+		if other, ok := probes[address(ep)]; ok && other != name {
+			name = client.ProbeTCP
+		}
+		probes[address(ep)] = name
+	}
+	for addr, name := range probes {
+		p, err := client.GetProbe(name)
+		if err != nil {
+			return err
+		}
+		if err := p(ctx, addr, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeName returns the probe of the metrics label
+func probeName(ins *registry.MicroServiceInstance, probe string) string {
+	if probe != "" {
+		return probe
+	}
+	return client.DefaultProbe(ins.DefaultProtocol)
+}
+
+// address removes the options of the endpoint, like 127.0.0.1:8080?sslEnabled=false
+func address(ep string) string {
+	if i := strings.Index(ep, "?"); i >= 0 {
+		return ep[:i]
+	}
+	return ep
+}
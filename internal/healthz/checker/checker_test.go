@@ -0,0 +1,200 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chassis/go-archaius"
+	"github.com/leon-yc/ggs/ggstest/mockregistry"
+	"github.com/leon-yc/ggs/internal/core/config"
+	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/internal/healthz/client"
+	"github.com/leon-yc/ggs/pkg/metrics"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.InitWithConfigs(map[string]interface{}{}); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(); err != nil {
+		panic(err)
+	}
+	registry.DefaultServiceDiscoveryService = mockregistry.Default()
+	client.InstallProbe("fake", fake.probe)
+	os.Exit(m.Run())
+}
+
+// fakeProbe fails the addresses marked down
+type fakeProbe struct {
+	mu    sync.Mutex
+	down  map[string]bool
+	calls []string
+}
+
+var fake = &fakeProbe{down: make(map[string]bool)}
+
+func (f *fakeProbe) probe(ctx context.Context, endpoint string, opts client.ProbeOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, endpoint)
+	if f.down[endpoint] {
+		return errors.New("down")
+	}
+	return nil
+}
+
+func (f *fakeProbe) set(endpoint string, down bool) {
+	f.mu.Lock()
+	f.down[endpoint] = down
+	f.mu.Unlock()
+}
+
+func addInstance(service, id string) {
+	mockregistry.AddInstance(service, &registry.MicroServiceInstance{
+		InstanceID:   id,
+		EndpointsMap: map[string]string{"rest": id + ":80"},
+	})
+}
+
+func useFakeProbe(t *testing.T, service string) {
+	archaius.Set("ggs.healthCheck."+service+".probe", "fake")
+	archaius.Set("ggs.healthCheck."+service+".unhealthyThreshold", 2)
+	archaius.Set("ggs.healthCheck."+service+".healthyThreshold", 2)
+	t.Cleanup(func() {
+		archaius.Set("ggs.healthCheck."+service+".enabled", false)
+		mockregistry.Reset()
+	})
+}
+
+func TestCheckEjectsAndReadmits(t *testing.T) {
+	const service = "checked"
+	useFakeProbe(t, service)
+	addInstance(service, "a")
+	addInstance(service, "b")
+	fake.set("a:80", true)
+	defer fake.set("a:80", false)
+
+	sc := &serviceChecker{service: service, states: make(map[string]*instanceState), ctx: context.Background()}
+	steps := []struct {
+		name    string
+		down    bool
+		ejected bool
+	}{
+		{"first failure", true, false},
+		{"unhealthy threshold", true, true},
+		{"first success", false, true},
+		{"healthy threshold", false, false},
+		{"failure after readmission", true, false},
+	}
+	for _, s := range steps {
+		fake.set("a:80", s.down)
+		sc.check()
+		if got := registry.IsEjected(service, "a"); got != s.ejected {
+			t.Errorf("%s: ejected is %v, want %v", s.name, got, s.ejected)
+		}
+		if registry.IsEjected(service, "b") {
+			t.Errorf("%s: the healthy instance is ejected", s.name)
+		}
+	}
+
+	// the removed instance is forgotten
+	sc.check()
+	if !registry.IsEjected(service, "a") {
+		t.Fatal("a is not ejected again")
+	}
+	if err := mockregistry.RemoveInstance(service, "a"); err != nil {
+		t.Fatal(err)
+	}
+	sc.check()
+	if registry.IsEjected(service, "a") || len(sc.states) != 1 {
+		t.Errorf("the removed instance is kept, states: %v", sc.states)
+	}
+}
+
+func TestUnwatchReadmits(t *testing.T) {
+	const service = "unwatched"
+	useFakeProbe(t, service)
+	archaius.Set("ggs.healthCheck."+service+".enabled", true)
+	archaius.Set("ggs.healthCheck."+service+".intervalMs", 10)
+	addInstance(service, "a")
+	addInstance(service, "b")
+	fake.set("a:80", true)
+	defer fake.set("a:80", false)
+
+	Watch(service)
+	deadline := time.Now().Add(2 * time.Second)
+	for !registry.IsEjected(service, "a") {
+		if time.Now().After(deadline) {
+			t.Fatal("a is not ejected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Unwatch(service)
+	if registry.IsEjected(service, "a") {
+		t.Error("a is still ejected after unwatch")
+	}
+	mu.RLock()
+	_, ok := checkers[service]
+	mu.RUnlock()
+	if ok {
+		t.Error("the checker is kept after unwatch")
+	}
+}
+
+func TestProbeEachAddressOnce(t *testing.T) {
+	restore := make(map[string]client.Probe)
+	for _, name := range []string{client.ProbeHTTP, client.ProbeGRPC, client.ProbeTCP} {
+		restore[name], _ = client.GetProbe(name)
+		name := name
+		client.InstallProbe(name, func(ctx context.Context, endpoint string, opts client.ProbeOptions) error {
+			return fake.probe(ctx, name+" "+endpoint, opts)
+		})
+	}
+	defer func() {
+		for name, p := range restore {
+			client.InstallProbe(name, p)
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		endpoints map[string]string
+		want      []string
+	}{
+		{"distinct addresses", map[string]string{"rest": "1.1.1.1:80", "grpc": "1.1.1.1:90"},
+			[]string{"grpc 1.1.1.1:90", "http 1.1.1.1:80"}},
+		{"same address of protocols", map[string]string{"rest": "1.1.1.1:80", "grpc": "1.1.1.1:80?sslEnabled=false"},
+			[]string{"tcp 1.1.1.1:80"}},
+		{"same probe of protocols", map[string]string{"highway": "1.1.1.1:80", "dubbo": "1.1.1.1:80"},
+			[]string{"tcp 1.1.1.1:80"}},
+	}
+	for _, tt := range tests {
+		fake.mu.Lock()
+		fake.calls = nil
+		fake.mu.Unlock()
+		ins := &registry.MicroServiceInstance{InstanceID: "a", EndpointsMap: tt.endpoints}
+		if err := probeInstance(context.Background(), ins, "", client.ProbeOptions{}); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		fake.mu.Lock()
+		calls := fake.calls
+		fake.mu.Unlock()
+		sort.Strings(calls)
+		if len(calls) != len(tt.want) {
+			t.Errorf("%s: probes %v, want %v", tt.name, calls, tt.want)
+			continue
+		}
+		for i := range calls {
+			if calls[i] != tt.want[i] {
+				t.Errorf("%s: probes %v, want %v", tt.name, calls, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// names of the built-in probes
+const (
+	ProbeHTTP = "http"
+	ProbeGRPC = "grpc"
+	ProbeTCP  = "tcp"
+)
+
+// ProbeOptions is the options of a probe
+type ProbeOptions struct {
+	//Path is the path of the http probe
+	Path string
+	//Service is the service name of the grpc health check, empty means the whole server
+	Service string
+}
+
+// Probe checks an endpoint of an instance, nil means healthy
+type Probe func(ctx context.Context, endpoint string, opts ProbeOptions) error
+
+var probes = map[string]Probe{
+	ProbeHTTP: httpProbe,
+	ProbeGRPC: grpcProbe,
+	ProbeTCP:  tcpProbe,
+}
+
+// InstallProbe installs a probe, it is not thread safe, install the probes in init
+func InstallProbe(name string, p Probe) {
+	probes[name] = p
+}
+
+// GetProbe returns the probe of the name
+func GetProbe(name string) (Probe, error) {
+	p, ok := probes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown probe %s", name)
+	}
+	return p, nil
+}
+
+// DefaultProbe returns the probe of the protocol, rest uses http, grpc uses the grpc health check, the others use tcp
+func DefaultProbe(protocol string) string {
+	switch protocol {
+	case common.ProtocolRest:
+		return ProbeHTTP
+	case common.ProtocolGrpc:
+		return ProbeGRPC
+	}
+	return ProbeTCP
+}
+
+var probeClient = &http.Client{
+	// a redirect is a response of the instance, it is healthy
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// httpProbe gets the path, the status less than 400 is healthy
+func httpProbe(ctx context.Context, endpoint string, opts ProbeOptions) error {
+	path := opts.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probeClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("GET %s returns %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// grpcProbe calls grpc.health.v1.Health/Check, only SERVING is healthy,
+// the server without the health service is healthy if no service is specified
+func grpcProbe(ctx context.Context, endpoint string, opts ProbeOptions) error {
+	// not blocking, the connection is made by the call and fails with it
+	conn, err := grpc.DialContext(ctx, endpoint, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: opts.Service})
+	if status.Code(err) == codes.Unimplemented && opts.Service == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc health status is %s", resp.Status)
+	}
+	return nil
+}
+
+// tcpProbe connects the endpoint
+func tcpProbe(ctx context.Context, endpoint string, opts ProbeOptions) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func serveGRPC(t *testing.T, hs *health.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	if hs != nil {
+		healthpb.RegisterHealthServer(s, hs)
+	}
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
+
+func TestGRPCProbe(t *testing.T) {
+	hs := health.NewServer()
+	hs.SetServingStatus("foo", healthpb.HealthCheckResponse_NOT_SERVING)
+	withHealth := serveGRPC(t, hs)
+	withoutHealth := serveGRPC(t, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		service  string
+		healthy  bool
+	}{
+		{"serving", withHealth, "", true},
+		{"not serving", withHealth, "foo", false},
+		{"no health service", withoutHealth, "", true},
+		{"no health service of the specified service", withoutHealth, "foo", false},
+		{"closed", closed, "", false},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := grpcProbe(ctx, tt.endpoint, ProbeOptions{Service: tt.service})
+		cancel()
+		if (err == nil) != tt.healthy {
+			t.Errorf("%s: healthy is %v, want %v, err: %v", tt.name, err == nil, tt.healthy, err)
+		}
+	}
+}
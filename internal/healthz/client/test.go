@@ -19,7 +19,12 @@ func Test(ctx context.Context, protocol, endpoint string, expected Reply) (err e
 	case common.ProtocolRest:
 		err = restTest(ctx, endpoint, expected)
 	default:
-		err = fmt.Errorf("unsupport protocol %s", protocol)
+		// the other protocols have no identity api, the endpoint is probed only
+		var p Probe
+		p, err = GetProbe(DefaultProbe(protocol))
+		if err == nil {
+			err = p(ctx, endpoint, ProbeOptions{})
+		}
 	}
 	return
 }
@@ -1,49 +1,57 @@
 package loadbalancing
 
 import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
 	"github.com/leon-yc/ggs/internal/core/config"
 	"github.com/leon-yc/ggs/internal/core/loadbalancer"
 	"github.com/leon-yc/ggs/internal/core/registry"
+	"github.com/leon-yc/ggs/pkg/qlog"
 )
 
 func init() {
-	loadbalancer.InstallFilter(loadbalancer.ZoneAware, FilterAvailableZoneAffinity)
+	loadbalancer.InstallServiceFilter(loadbalancer.ZoneAware, FilterAvailableZoneAffinity)
+	loadbalancer.InstallFilter(loadbalancer.FilterMetadata, FilterByMetadata)
 }
 
-//FilterAvailableZoneAffinity is a region and zone based Select Filter which will Do the selection of instance in the same region and zone, if not Do the selection of instance in any zone in same region , if not Do the selection of instance in any zone of any region
-func FilterAvailableZoneAffinity(old []*registry.MicroServiceInstance, c []*loadbalancer.Criteria) []*registry.MicroServiceInstance {
-	var instances []*registry.MicroServiceInstance
-	if config.GetDataCenter() == nil {
+//FilterAvailableZoneAffinity is a region and zone based Select Filter, the instances are grouped into the localities by the
+//failover order: the same zone, the configured localities or the other zones in the same region, and then any region.
+//A locality takes all the traffic if it has enough healthy instances, otherwise it takes a part proportional to its
+//healthy instances and the rest spills over to the next locality, the locality config can be overridden per service
+func FilterAvailableZoneAffinity(service string, old []*registry.MicroServiceInstance, c []*loadbalancer.Criteria) []*registry.MicroServiceInstance {
+	dc := config.GetDataCenter()
+	if dc == nil {
 		return old
 	}
-	if config.GetDataCenter().Name == "" || config.GetDataCenter().AvailableZone == "" {
-		return old // Either no information or partial data center information specified, return all instances
+	region := dc.Name
+	if dc.Region != "" {
+		region = dc.Region
 	}
-
-	availableZone := config.GetDataCenter().AvailableZone
-	regionName := config.GetDataCenter().Name
-	instances = getInstancesZoneWise(old, regionName, availableZone)
-	if len(instances) == 0 {
-		instances = getAvailableInstancesInSameRegion(old, regionName)
-		if len(instances) == 0 {
-			return old //out of region (multi region) case
-		}
-
-		return instances //same region but any available zone case
+	if region == "" || dc.AvailableZone == "" {
+		return old // Either no information or partial data center information specified, return all instances
 	}
 
-	return instances //same region and same zone case
+	levels := groupByLocality(old, region, dc.AvailableZone, config.LocalityFailover("", service))
+	return pickLocality(levels, config.LocalityMinHealthyInstances("", service), rand.Float64())
 }
 
-// getInstancesZoneWise check for the same zone and region
-func getInstancesZoneWise(providerInstances []*registry.MicroServiceInstance, region, availableZone string) []*registry.MicroServiceInstance {
-	instances := make([]*registry.MicroServiceInstance, 0)
-	for _, ins := range providerInstances {
-		if ins.DataCenterInfo == nil {
+// FilterByMetadata filter instances based meta data, an instance is selected only if it matches all the criteria,
+// the instance without the key of a criteria does not match it
+func FilterByMetadata(old []*registry.MicroServiceInstance, c []*loadbalancer.Criteria) []*registry.MicroServiceInstance {
+	if len(c) == 0 {
+		return old
+	}
+	instances := make([]*registry.MicroServiceInstance, 0, len(old))
+	for _, ins := range old {
+		if ins.Metadata == nil {
 			continue
 		}
-
-		if ins.DataCenterInfo.Region == region && ins.DataCenterInfo.AvailableZone == availableZone {
+		if matchAll(ins.Metadata, c) {
 			instances = append(instances, ins)
 		}
 	}
@@ -51,30 +59,69 @@ func getInstancesZoneWise(providerInstances []*registry.MicroServiceInstance, re
 	return instances
 }
 
-// getAvailableInstancesInSameRegion check for available instances in same region
-func getAvailableInstancesInSameRegion(providerInstances []*registry.MicroServiceInstance, region string) []*registry.MicroServiceInstance {
-	instances := make([]*registry.MicroServiceInstance, 0)
-	for _, ins := range providerInstances {
-		if ins.DataCenterInfo == nil || ins.DataCenterInfo.Region != region {
-			continue
+func matchAll(metadata map[string]string, criteria []*loadbalancer.Criteria) bool {
+	for _, c := range criteria {
+		v, ok := metadata[c.Key]
+		if !ok || !match(v, c) {
+			return false
 		}
-
-		instances = append(instances, ins)
 	}
-
-	return instances
+	return true
 }
 
-// FilterByMetadata filter instances based meta data
-func FilterByMetadata(old []*registry.MicroServiceInstance, c []*loadbalancer.Criteria) []*registry.MicroServiceInstance {
-	var instances []*registry.MicroServiceInstance
+func match(v string, c *loadbalancer.Criteria) bool {
+	switch c.Operator {
+	case loadbalancer.OperatorEqual:
+		return v == c.Value
+	case loadbalancer.OperatorGreater:
+		return compare(v, c.Value) > 0
+	case loadbalancer.OperatorSmaller:
+		return compare(v, c.Value) < 0
+	case loadbalancer.OperatorGreaterOrEqual:
+		return compare(v, c.Value) >= 0
+	case loadbalancer.OperatorSmallerOrEqual:
+		return compare(v, c.Value) <= 0
+	case loadbalancer.OperatorPattern:
+		re := getPattern(c.Value)
+		return re != nil && re.MatchString(v)
+	}
+	return false
+}
 
-	for _, ins := range old {
-		if ins.Metadata == nil {
-			continue
+// compare compares the values as numbers, or versions like 1.2.10, or strings
+func compare(a, b string) int {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
 		}
-		//TODO read tags in router.yaml and filter instances based on properties and tags
+		return 0
+	}
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+	if errA == nil && errB == nil {
+		return va.Compare(vb)
 	}
+	return strings.Compare(a, b)
+}
 
-	return instances
+// patterns caches the compiled patterns, the invalid pattern is cached as nil
+var patterns sync.Map
+
+// getPattern returns the regexp which matches the whole value
+func getPattern(p string) *regexp.Regexp {
+	if re, ok := patterns.Load(p); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile("^(?:" + p + ")$")
+	if err != nil {
+		qlog.Errorf("invalid pattern [%s] of the metadata filter: %s", p, err)
+		re = nil
+	}
+	patterns.Store(p, re)
+	return re
 }
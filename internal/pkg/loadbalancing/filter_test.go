@@ -0,0 +1,69 @@
+package loadbalancing
+
+import (
+	"testing"
+
+	"github.com/leon-yc/ggs/internal/core/loadbalancer"
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2", "10", -1},
+		{"10", "10.0", 0},
+		{"-1.5", "-2", 1},
+		{"1.2.10", "1.2.9", 1},
+		{"1.10.0", "1.9", 1},
+		{"v1.2.0", "1.2.0", 0},
+		{"1.2.0-beta", "1.2.0", -1},
+		{"gray", "green", -1},
+		{"10", "gray", -1},
+		{"b", "a", 1},
+	}
+	for _, tt := range tests {
+		if got := compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFilterByMetadata(t *testing.T) {
+	instances := []*registry.MicroServiceInstance{
+		{InstanceID: "a", Metadata: map[string]string{"env": "gray", "version": "1.2.10", "cluster": "10"}},
+		{InstanceID: "b", Metadata: map[string]string{"env": "prod", "version": "1.2.9", "cluster": "2"}},
+		{InstanceID: "c", Metadata: map[string]string{"env": "gray", "version": "2.0.0"}},
+		{InstanceID: "d"},
+	}
+	tests := []struct {
+		name     string
+		criteria []string
+		want     string
+	}{
+		{"no criteria", nil, "abcd"},
+		{"equal", []string{"env=gray"}, "ac"},
+		{"equal is not a prefix", []string{"env=gra"}, ""},
+		{"number", []string{"cluster>=2"}, "ab"},
+		{"number is not a string", []string{"cluster>9"}, "a"},
+		{"version", []string{"version>1.2.9"}, "ac"},
+		{"version range", []string{"version>=1.2.9", "version<2"}, "ab"},
+		{"string order", []string{"env<green"}, "ac"},
+		{"pattern matches the whole value", []string{`version Pattern 1\.2\..*`}, "ab"},
+		{"pattern with ~", []string{"env~gr.*"}, "ac"},
+		{"pattern is anchored", []string{"env~ra"}, ""},
+		{"invalid pattern", []string{"env~(gray"}, ""},
+		{"all criteria", []string{"env=gray", "cluster>=2"}, "a"},
+	}
+	for _, tt := range tests {
+		_, criteria := loadbalancer.ParseFilters(tt.criteria)
+		got := ""
+		for _, ins := range FilterByMetadata(instances, criteria) {
+			got += ins.InstanceID
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
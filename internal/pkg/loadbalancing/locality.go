@@ -0,0 +1,90 @@
+package loadbalancing
+
+import (
+	"strings"
+
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+// locality is a region and an optional zone, the empty zone matches all zones of the region
+type locality struct {
+	region string
+	zone   string
+}
+
+func parseLocality(s string) locality {
+	kv := strings.SplitN(s, "/", 2)
+	l := locality{region: strings.TrimSpace(kv[0])}
+	if len(kv) == 2 {
+		l.zone = strings.TrimSpace(kv[1])
+	}
+	return l
+}
+
+func (l locality) match(ins *registry.MicroServiceInstance) bool {
+	if ins.DataCenterInfo == nil || ins.DataCenterInfo.Region != l.region {
+		return false
+	}
+	return l.zone == "" || ins.DataCenterInfo.AvailableZone == l.zone
+}
+
+// groupByLocality groups the instances by the failover order, the local zone is the first, the instances which match
+// none of the localities are the last, an instance is in the first locality it matches and the empty groups are dropped
+func groupByLocality(instances []*registry.MicroServiceInstance, region, zone string, failover []string) [][]*registry.MicroServiceInstance {
+	order := []locality{{region: region, zone: zone}}
+	if len(failover) == 0 {
+		order = append(order, locality{region: region})
+	}
+	for _, f := range failover {
+		order = append(order, parseLocality(f))
+	}
+
+	groups := make([][]*registry.MicroServiceInstance, len(order)+1)
+	for _, ins := range instances {
+		n := len(order)
+		for i, l := range order {
+			if l.match(ins) {
+				n = i
+				break
+			}
+		}
+		groups[n] = append(groups[n], ins)
+	}
+
+	levels := make([][]*registry.MicroServiceInstance, 0, len(groups))
+	for _, g := range groups {
+		if len(g) > 0 {
+			levels = append(levels, g)
+		}
+	}
+	return levels
+}
+
+// pickLocality picks a locality by the random number r in [0, 1). A locality with minHealthy instances takes all the
+// remaining traffic, the one with fewer takes the part healthy/minHealthy of it, the traffic left when no locality
+// is healthy enough is shared by the localities in proportion to their parts
+func pickLocality(levels [][]*registry.MicroServiceInstance, minHealthy int, r float64) []*registry.MicroServiceInstance {
+	if len(levels) == 0 {
+		return nil
+	}
+	shares := make([]float64, len(levels))
+	remaining, total := 1.0, 0.0
+	for i, l := range levels {
+		capacity := float64(len(l)) / float64(minHealthy)
+		if capacity > 1 {
+			capacity = 1
+		}
+		shares[i] = remaining * capacity
+		remaining -= shares[i]
+		total += shares[i]
+	}
+
+	r *= total
+	for i, share := range shares {
+		if r < share {
+			return levels[i]
+		}
+		r -= share
+	}
+	return levels[len(levels)-1]
+}
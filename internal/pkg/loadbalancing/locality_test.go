@@ -0,0 +1,93 @@
+package loadbalancing
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/leon-yc/ggs/internal/core/registry"
+)
+
+func instanceIn(id, region, zone string) *registry.MicroServiceInstance {
+	ins := &registry.MicroServiceInstance{InstanceID: id}
+	if region != "" {
+		ins.DataCenterInfo = &registry.DataCenterInfo{Name: region, Region: region, AvailableZone: zone}
+	}
+	return ins
+}
+
+func TestGroupByLocality(t *testing.T) {
+	instances := []*registry.MicroServiceInstance{
+		instanceIn("local", "r1", "z1"),
+		instanceIn("zone2", "r1", "z2"),
+		instanceIn("zone3", "r1", "z3"),
+		instanceIn("remote", "r2", "z1"),
+		instanceIn("unknown", "", ""),
+		instanceIn("local2", "r1", "z1"),
+	}
+	tests := []struct {
+		name     string
+		failover []string
+		want     [][]string
+	}{
+		{"same region by default", nil,
+			[][]string{{"local", "local2"}, {"zone2", "zone3"}, {"remote", "unknown"}}},
+		{"failover order", []string{"r2", "r1/z2"},
+			[][]string{{"local", "local2"}, {"remote"}, {"zone2"}, {"zone3", "unknown"}}},
+		{"empty localities are dropped", []string{"r3", " r1 / z3 "},
+			[][]string{{"local", "local2"}, {"zone3"}, {"zone2", "remote", "unknown"}}},
+	}
+	for _, tt := range tests {
+		var got [][]string
+		for _, level := range groupByLocality(instances, "r1", "z1", tt.failover) {
+			var ids []string
+			for _, ins := range level {
+				ids = append(ids, ins.InstanceID)
+			}
+			got = append(got, ids)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPickLocality(t *testing.T) {
+	tests := []struct {
+		name       string
+		sizes      []int
+		minHealthy int
+		shares     []float64
+	}{
+		{"healthy local takes all", []int{3, 2}, 3, []float64{1, 0}},
+		{"spillover to the next", []int{1, 5}, 2, []float64{0.5, 0.5}},
+		{"spillover in the failover order", []int{1, 1, 4}, 4, []float64{0.25, 0.1875, 0.5625}},
+		{"no locality is healthy enough", []int{1, 1}, 4, []float64{0.25 / 0.4375, 0.1875 / 0.4375}},
+	}
+	const samples = 10000
+	for _, tt := range tests {
+		levels := make([][]*registry.MicroServiceInstance, len(tt.sizes))
+		index := make(map[*registry.MicroServiceInstance]int)
+		for i, n := range tt.sizes {
+			for j := 0; j < n; j++ {
+				ins := &registry.MicroServiceInstance{}
+				levels[i] = append(levels[i], ins)
+				index[ins] = i
+			}
+		}
+		counts := make([]float64, len(levels))
+		for s := 0; s < samples; s++ {
+			picked := pickLocality(levels, tt.minHealthy, (float64(s)+0.5)/samples)
+			counts[index[picked[0]]]++
+		}
+		for i := range counts {
+			if share := counts[i] / samples; math.Abs(share-tt.shares[i]) > 0.001 {
+				t.Errorf("%s: the share of locality %d is %.4f, want %.4f", tt.name, i, share, tt.shares[i])
+			}
+		}
+	}
+
+	if got := pickLocality(nil, 1, 0.5); got != nil {
+		t.Errorf("want nil without localities, got %v", got)
+	}
+}
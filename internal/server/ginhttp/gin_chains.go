@@ -0,0 +1,27 @@
+package ginhttp
+
+import (
+	"net/http"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/gin-gonic/gin"
+)
+
+//ginChains lists the handler chains, with ?type=Provider&name=default it returns the handlers of the chain
+func ginChains(c *gin.Context) {
+	chainType, name := c.Query("type"), c.Query("name")
+	if chainType == "" && name == "" {
+		c.JSON(http.StatusOK, handler.ListChains())
+		return
+	}
+	if name == "" {
+		name = common.DefaultChainName
+	}
+	names, err := handler.DescribeChain(chainType, name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, handler.ChainInfo{ServiceType: chainType, Name: name, Handlers: names})
+}
@@ -29,8 +29,10 @@ const (
 	Name               = "rest"
 	DefaultMetricPath  = "/metrics"
 	DefaultHealthyPath = "/ping"
+	DefaultChainsPath  = "/debug/chains"
 	MimeFile           = "application/octet-stream"
 	MimeMult           = "multipart/form-data"
+	pprofPrefix        = "/debug/pprof/"
 )
 
 func init() {
@@ -44,6 +46,7 @@ type ginServer struct {
 	mux              sync.RWMutex
 	exit             chan chan error
 	server           *http.Server
+	router           *chainRouter
 }
 
 func newGinServer(opts server.Options) server.ProtocolServer {
@@ -52,7 +55,13 @@ func newGinServer(opts server.Options) server.ProtocolServer {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	gs := gin.New()
-	gs.Use(wrapHandlerChain(opts))
+	router := newChainRouter(opts.ChainName, config.GlobalDefinition.Ggs.Handler.Chain.Provider)
+	handler.AddReloadListener(func(chainType string, chainNames map[string]string) {
+		if chainType == common.Provider {
+			router.reload(chainNames)
+		}
+	})
+	gs.Use(wrapHandlerChain(opts, router))
 
 	if archaius.GetBool("ggs.metrics.enabled", false) {
 		metricPath := archaius.GetString("ggs.metrics.apiPath", DefaultMetricPath)
@@ -61,6 +70,7 @@ func newGinServer(opts server.Options) server.ProtocolServer {
 		}
 		qlog.Info("Enabled metrics API on " + metricPath)
 		gs.GET(metricPath, metrics.GinHandleFunc)
+		router.skip(metricPath)
 	}
 
 	if !archaius.GetBool("ggs.healthy.disabled", false) {
@@ -69,6 +79,7 @@ func newGinServer(opts server.Options) server.ProtocolServer {
 			healthzPath = "/" + healthzPath
 		}
 		qlog.Info("Enabled healthy API on " + healthzPath)
+		router.skip(healthzPath)
 		gs.GET(healthzPath, func(c *gin.Context) {
 			msg := fmt.Sprintf("Welcome to [%s]%s:%s!", config.MicroserviceDefinition.ServiceDescription.Environment,
 				config.MicroserviceDefinition.ServiceDescription.Name,
@@ -77,24 +88,36 @@ func newGinServer(opts server.Options) server.ProtocolServer {
 		})
 	}
 
+	if archaius.GetBool("ggs.chains.enabled", false) {
+		chainsPath := archaius.GetString("ggs.chains.apiPath", DefaultChainsPath)
+		if !strings.HasPrefix(chainsPath, "/") {
+			chainsPath = "/" + chainsPath
+		}
+		qlog.Info("Enabled handler chains API on " + chainsPath)
+		router.skip(chainsPath)
+		gs.GET(chainsPath, ginChains)
+	}
+
 	if archaius.GetBool("ggs.pprof.enabled", false) {
 		//add pprof
-		gs.GET("/debug/pprof/", ginIndex)
-		gs.GET("/debug/pprof/cmdline", ginCmdline)
-		gs.GET("/debug/pprof/profile", ginProfile)
-		gs.GET("/debug/pprof/symbol", ginSymbol)
-		gs.GET("/debug/pprof/trace", ginTrace)
+		gs.GET(pprofPrefix, ginIndex)
+		gs.GET(pprofPrefix+"cmdline", ginCmdline)
+		gs.GET(pprofPrefix+"profile", ginProfile)
+		gs.GET(pprofPrefix+"symbol", ginSymbol)
+		gs.GET(pprofPrefix+"trace", ginTrace)
+		router.skipPprof()
 		//http.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	}
 
 	return &ginServer{
-		opts: opts,
-		gs:   gs,
+		opts:   opts,
+		gs:     gs,
+		router: router,
 	}
 }
 
-//wrapHandlerChain wrap business handler with handler chain
-func wrapHandlerChain(opts server.Options) gin.HandlerFunc {
+//wrapHandlerChain wrap business handler with handler chain, the chain is selected by the request path
+func wrapHandlerChain(opts server.Options, router *chainRouter) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -116,7 +139,12 @@ func wrapHandlerChain(opts server.Options) gin.HandlerFunc {
 			}
 		}()
 
-		c, err := handler.GetChain(common.Provider, opts.ChainName)
+		chainName, ok := router.chainOf(ctx.Request.URL.Path)
+		if !ok {
+			ctx.Next()
+			return
+		}
+		c, err := handler.GetChain(common.Provider, chainName)
 		if err != nil {
 			qlog.WithError(err).Error("handler chain init err.")
 			ctx.String(http.StatusInternalServerError, err.Error())
@@ -184,6 +212,16 @@ func (r *ginServer) Register(schema interface{}, opts ...server.RegisterOption)
 	return "", nil
 }
 
+//Group creates a route group, the routes of the group use the chain set by server.WithChain
+func (r *ginServer) Group(relativePath string, opts ...server.RegisterOption) interface{} {
+	regOpts := server.NewRegisterOptions(opts...)
+	g := r.gs.Group(relativePath)
+	if regOpts.ChainName != "" {
+		r.router.bind(g.BasePath(), regOpts.ChainName)
+	}
+	return g
+}
+
 // Invocation2HTTPRequest convert invocation back to http request, set down all meta data
 func Invocation2HTTPRequest(inv *invocation.Invocation, ctx *gin.Context) {
 	for k, v := range inv.Metadata {
@@ -221,7 +259,7 @@ func (r *ginServer) Start() error {
 
 	go func() {
 		err = r.server.Serve(listen)
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			qlog.Error("http server err: " + err.Error())
 			server.ErrRuntime <- err
 		}
@@ -0,0 +1,133 @@
+package ginhttp
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// PathWildcard is the suffix of a prefix path matcher, e.g. /admin/*
+const PathWildcard = "*"
+
+//pathChain binds a path prefix to a provider chain
+type pathChain struct {
+	prefix string
+	chain  string
+}
+
+//chainRouter selects the provider chain of a request by its path,
+//the order is: exact matcher, longest prefix matcher in config,
+//built-in routes which skip the chain, longest group prefix, then the default chain
+type chainRouter struct {
+	mu       sync.RWMutex
+	def      string
+	exact    map[string]string
+	prefixes []pathChain
+	groups   []pathChain
+	builtins map[string]bool
+	pprof    bool
+}
+
+//newChainRouter parses the path matchers in the provider chain names,
+//a chain name starts with "/" is a path matcher, and ends with "*" matches the prefix
+func newChainRouter(def string, chainNames map[string]string) *chainRouter {
+	r := &chainRouter{
+		def:      def,
+		builtins: make(map[string]bool),
+	}
+	r.reload(chainNames)
+	return r
+}
+
+//reload replaces the path matchers by the reloaded provider chain names, the groups are kept
+func (r *chainRouter) reload(chainNames map[string]string) {
+	exact := make(map[string]string)
+	var prefixes []pathChain
+	for name := range chainNames {
+		if !strings.HasPrefix(name, "/") {
+			continue
+		}
+		if strings.HasSuffix(name, PathWildcard) {
+			prefixes = append(prefixes, pathChain{prefix: strings.TrimSuffix(name, PathWildcard), chain: name})
+		} else {
+			exact[name] = name
+		}
+		qlog.Infof("provider chain [%s] is bound to its path matcher", name)
+	}
+	sortByPrefix(prefixes)
+
+	r.mu.Lock()
+	r.exact = exact
+	r.prefixes = prefixes
+	r.mu.Unlock()
+}
+
+//hasPathPrefix reports whether the path is the prefix or under it, the prefix matches whole segments only,
+//so /admin matches /admin and /admin/users, but not /administrator
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+//sortByPrefix sorts the matchers by prefix length desc, so the longest one matches first
+func sortByPrefix(pcs []pathChain) {
+	sort.SliceStable(pcs, func(i, j int) bool {
+		return len(pcs[i].prefix) > len(pcs[j].prefix)
+	})
+}
+
+//skip makes the built-in route skip the chain unless it is matched by config
+func (r *chainRouter) skip(path string) {
+	r.mu.Lock()
+	r.builtins[path] = true
+	r.mu.Unlock()
+}
+
+//skipPprof makes the pprof routes skip the chain
+func (r *chainRouter) skipPprof() {
+	r.mu.Lock()
+	r.pprof = true
+	r.mu.Unlock()
+}
+
+//bind binds the routes under the prefix to the chain
+func (r *chainRouter) bind(prefix, chain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.groups {
+		if r.groups[i].prefix == prefix {
+			r.groups[i].chain = chain
+			return
+		}
+	}
+	r.groups = append(r.groups, pathChain{prefix: prefix, chain: chain})
+	sortByPrefix(r.groups)
+}
+
+//chainOf returns the chain name of the path, returns false if the path skips the chain
+func (r *chainRouter) chainOf(path string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.exact[path]; ok {
+		return c, true
+	}
+	for _, pc := range r.prefixes {
+		if hasPathPrefix(path, pc.prefix) {
+			return pc.chain, true
+		}
+	}
+	if r.builtins[path] || (r.pprof && hasPathPrefix(path, pprofPrefix)) {
+		return "", false
+	}
+	for _, pc := range r.groups {
+		if hasPathPrefix(path, pc.prefix) {
+			return pc.chain, true
+		}
+	}
+	return r.def, true
+}
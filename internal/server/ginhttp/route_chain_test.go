@@ -0,0 +1,56 @@
+package ginhttp
+
+import "testing"
+
+func TestChainOf(t *testing.T) {
+	r := newChainRouter("default", map[string]string{
+		"default":      "",
+		"/admin/*":     "",
+		"/admin/users": "",
+		"/api/v1*":     "",
+	})
+	r.bind("/public", "public")
+	r.skip("/ping")
+	r.skipPprof()
+
+	cases := map[string]string{
+		"/admin":             "/admin/*",
+		"/admin/":            "/admin/*",
+		"/admin/roles":       "/admin/*",
+		"/admin/users":       "/admin/users",
+		"/administrator":     "default",
+		"/api/v1":            "/api/v1*",
+		"/api/v1/users":      "/api/v1*",
+		"/api/v10":           "default",
+		"/public":            "public",
+		"/public/index.html": "public",
+		"/publications":      "default",
+		"/":                  "default",
+	}
+	for path, want := range cases {
+		if got, ok := r.chainOf(path); !ok || got != want {
+			t.Errorf("chain of %s: want %s, got %s", path, want, got)
+		}
+	}
+	for _, path := range []string{"/ping", "/debug/pprof/", "/debug/pprof/profile"} {
+		if c, ok := r.chainOf(path); ok {
+			t.Errorf("%s must skip the chain, got %s", path, c)
+		}
+	}
+}
+
+func TestReloadPathChains(t *testing.T) {
+	r := newChainRouter("default", map[string]string{"/admin/*": ""})
+	r.bind("/public", "public")
+
+	r.reload(map[string]string{"/internal/*": ""})
+	if c, _ := r.chainOf("/admin/users"); c != "default" {
+		t.Errorf("the removed path chain is still used: %s", c)
+	}
+	if c, _ := r.chainOf("/internal/jobs"); c != "/internal/*" {
+		t.Errorf("the added path chain is not used: %s", c)
+	}
+	if c, _ := r.chainOf("/public/a"); c != "public" {
+		t.Errorf("the group chain is lost after reload: %s", c)
+	}
+}
@@ -3,7 +3,10 @@ package grpc
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -16,6 +19,8 @@ import (
 	"github.com/leon-yc/ggs/internal/pkg/util/iputil"
 	"github.com/leon-yc/ggs/pkg/qlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 )
@@ -24,6 +29,7 @@ import (
 var (
 	ErrGRPCSvcDescMissing = errors.New("must use server.WithRPCServiceDesc to set desc")
 	ErrGRPCSvcType        = errors.New("must set *grpc.ServiceDesc")
+	ErrGRPCSvcStarted     = errors.New("grpc server is started, can not register service any more")
 )
 
 //const
@@ -38,7 +44,19 @@ func init() {
 //Server is grpc server holder
 type Server struct {
 	s    *grpc.Server
+	hs   *health.Server
 	opts server.Options
+
+	mu       sync.Mutex
+	services map[string]*service
+}
+
+//service is a registered grpc service with its own interceptors
+type service struct {
+	desc      *grpc.ServiceDesc
+	impl      interface{}
+	unaryInt  grpc.UnaryServerInterceptor
+	streamInt grpc.StreamServerInterceptor
 }
 
 //Request2Invocation convert grpc protocol to invocation
@@ -98,44 +116,88 @@ func Stream2Invocation(stream grpc.ServerStream, info *grpc.StreamServerInfo) *i
 //New create grpc server
 func New(opts server.Options) server.ProtocolServer {
 	return &Server{
-		opts: opts,
+		opts:     opts,
+		services: make(map[string]*service),
 	}
 }
 
-//Register register grpc services
+//Register register grpc services, all of them are served by one grpc server,
+//and the interceptors in options only apply to the registered service
 func (s *Server) Register(schema interface{}, options ...server.RegisterOption) (string, error) {
-	var opts server.RegisterOptions
-	for _, o := range options {
-		o(&opts)
+	opts := server.NewRegisterOptions(options...)
+	if opts.SvcDesc == nil {
+		return "", ErrGRPCSvcDescMissing
 	}
 
-	var unaryInts []grpc.UnaryServerInterceptor
-	unaryInts = append(unaryInts, wrapUnaryInterceptor(s.opts))
-	unaryInts = append(unaryInts, opts.UnaryInts...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.s != nil {
+		return "", ErrGRPCSvcStarted
+	}
+	name := opts.SvcDesc.ServiceName
+	if _, ok := s.services[name]; ok {
+		return "", fmt.Errorf("grpc service %s is registered already", name)
+	}
+	s.services[name] = &service{
+		desc:      opts.SvcDesc,
+		impl:      schema,
+		unaryInt:  grpc_middleware.ChainUnaryServer(opts.UnaryInts...),
+		streamInt: grpc_middleware.ChainStreamServer(opts.StreamInts...),
+	}
+	return name, nil
+}
 
-	var streamInts []grpc.StreamServerInterceptor
-	streamInts = append(streamInts, wrapStreamInterceptor(s.opts))
-	streamInts = append(streamInts, opts.StreamInts...)
+//serviceOf returns the registered service of the full method, like /package.service/method
+func (s *Server) serviceOf(fullMethod string) *service {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if pos := strings.LastIndex(name, "/"); pos >= 0 {
+		name = name[:pos]
+	}
+	return s.services[name]
+}
 
-	var grpcOpts []grpc.ServerOption
-	grpcOpts = append(grpcOpts, grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInts...)))
-	grpcOpts = append(grpcOpts, grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInts...)))
+//unaryDispatcher runs the unary interceptors of the service which the method belongs to
+func (s *Server) unaryDispatcher(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handle grpc.UnaryHandler) (interface{}, error) {
+	svc := s.serviceOf(info.FullMethod)
+	if svc == nil {
+		return handle(ctx, req)
+	}
+	return svc.unaryInt(ctx, req, info, handle)
+}
 
-	// new server
-	s.s = grpc.NewServer(grpcOpts...)
+//streamDispatcher runs the stream interceptors of the service which the method belongs to
+func (s *Server) streamDispatcher(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handle grpc.StreamHandler) error {
+	svc := s.serviceOf(info.FullMethod)
+	if svc == nil {
+		return handle(srv, stream)
+	}
+	return svc.streamInt(srv, stream, info, handle)
+}
 
-	if opts.SvcDesc == nil {
-		return "", ErrGRPCSvcDescMissing
+//newServer creates the grpc server with all registered services
+func (s *Server) newServer() *grpc.Server {
+	var grpcOpts []grpc.ServerOption
+	grpcOpts = append(grpcOpts, grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+		wrapUnaryInterceptor(s.opts), s.unaryDispatcher)))
+	grpcOpts = append(grpcOpts, grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+		wrapStreamInterceptor(s.opts), s.streamDispatcher)))
+
+	gs := grpc.NewServer(grpcOpts...)
+	for _, svc := range s.services {
+		gs.RegisterService(svc.desc, svc.impl)
 	}
 
-	// register service
-	s.s.RegisterService(opts.SvcDesc, schema)
+	// serve grpc.health.v1.Health for the health check of the consumers, unless the application has its own
+	if _, ok := s.services[healthpb.Health_ServiceDesc.ServiceName]; !ok {
+		s.hs = health.NewServer()
+		healthpb.RegisterHealthServer(gs, s.hs)
+	}
 
-	// Register reflection service on gRPC server.
+	// Register reflection service on gRPC server, it lists all registered services.
 	if s.opts.EnableGrpcurl {
-		reflection.Register(s.s)
+		reflection.Register(gs)
 	}
-	return "", nil
+	return gs
 }
 
 //Start launch the server
@@ -151,8 +213,15 @@ func (s *Server) Start() error {
 		listen = l
 	}
 
+	s.mu.Lock()
+	if s.s == nil {
+		s.s = s.newServer()
+	}
+	gs := s.s
+	s.mu.Unlock()
+
 	go func() {
-		if err := s.s.Serve(listen); err != nil {
+		if err := gs.Serve(listen); err != nil {
 			server.ErrRuntime <- err
 		}
 	}()
@@ -162,16 +231,27 @@ func (s *Server) Start() error {
 
 //Stop gracfully shutdown grpc server
 func (s *Server) Stop() error {
+	s.mu.Lock()
+	gs, hs := s.s, s.hs
+	s.mu.Unlock()
+	if gs == nil {
+		return nil
+	}
+	// the consumers checking the health stop calling the server while it drains
+	if hs != nil {
+		hs.Shutdown()
+	}
+
 	stopped := make(chan struct{})
 	go func() {
-		s.s.GracefulStop()
+		gs.GracefulStop()
 		close(stopped)
 	}()
 
 	t := time.NewTimer(10 * time.Second)
 	select {
 	case <-t.C:
-		s.s.Stop()
+		gs.Stop()
 	case <-stopped:
 		t.Stop()
 	}
@@ -0,0 +1,96 @@
+package invoke
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"google.golang.org/grpc"
+)
+
+// GRPCInvoker is the invoker of grpc requests
+type GRPCInvoker struct {
+	abstractInvoker
+}
+
+// NewGRPCInvoker is the function to create a grpc invoker
+func NewGRPCInvoker(opt ...Option) *GRPCInvoker {
+	return &GRPCInvoker{
+		abstractInvoker: abstractInvoker{
+			opts: newOptions(opt...),
+		},
+	}
+}
+
+// Invoke calls the full method, like /helloworld.Greeter/SayHello, of the service through the consumer chain
+func (gi *GRPCInvoker) Invoke(ctx context.Context, service, fullMethod string, req, reply interface{}, options ...InvocationOption) error {
+	schemaID, operationID, err := splitFullMethod(fullMethod)
+	if err != nil {
+		return err
+	}
+
+	opts := getOpts(options...)
+	opts.Protocol = common.ProtocolGrpc
+
+	inv := invocation.New(ctx)
+	wrapInvocationWithOpts(inv, opts)
+	if err := setService(inv, service, opts); err != nil {
+		return err
+	}
+	inv.SchemaID = schemaID
+	inv.OperationID = operationID
+	inv.Args = req
+	inv.Reply = reply
+
+	return gi.invoke(inv)
+}
+
+// splitFullMethod returns the service and method name of /package.service/method
+func splitFullMethod(fullMethod string) (string, string, error) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	pos := strings.LastIndex(name, "/")
+	if pos <= 0 || pos == len(name)-1 {
+		return "", "", fmt.Errorf("invalid grpc method: %s, must be /{service}/{method}", fullMethod)
+	}
+	return name[:pos], name[pos+1:], nil
+}
+
+// ClientConn implements grpc.ClientConnInterface, so the generated stubs can call
+// the service through the consumer chain
+type ClientConn struct {
+	invoker *GRPCInvoker
+	service string
+	opts    []InvocationOption
+}
+
+var _ grpc.ClientConnInterface = (*ClientConn)(nil)
+
+// NewClientConn is the function to create a grpc client conn of the service
+func NewClientConn(service string, options ...InvocationOption) *ClientConn {
+	return &ClientConn{
+		invoker: defaultGRPCInvoker,
+		service: service,
+		opts:    options,
+	}
+}
+
+// WithInvoker returns a copy of the conn using the invoker
+func (cc *ClientConn) WithInvoker(gi *GRPCInvoker) *ClientConn {
+	n := *cc
+	n.invoker = gi
+	return &n
+}
+
+// Invoke implements grpc.ClientConnInterface, the call options are ignored
+func (cc *ClientConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, _ ...grpc.CallOption) error {
+	return cc.invoker.Invoke(ctx, cc.service, method, args, reply, cc.opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface, the call options are ignored
+func (cc *ClientConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+	return cc.invoker.NewStream(ctx, cc.service, desc, method, cc.opts...)
+}
+
+var defaultGRPCInvoker = NewGRPCInvoker()
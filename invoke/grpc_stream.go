@@ -0,0 +1,150 @@
+package invoke
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoStream happens if the transport does not return a stream
+var ErrNoStream = errors.New("grpc transport returns no stream")
+
+// errStreamDropped ends the stream which is dropped by the caller before it ends
+var errStreamDropped = status.Error(codes.Canceled, "grpc stream is dropped before it ends")
+
+// NewStream opens a stream of the full method through the consumer chain,
+// the handlers are notified when the returned stream ends, that is when the response is read to the end,
+// an error is returned, ctx is done, or the stream is dropped by the caller and garbage collected
+func (gi *GRPCInvoker) NewStream(ctx context.Context, service string, desc *grpc.StreamDesc, fullMethod string, options ...InvocationOption) (grpc.ClientStream, error) {
+	schemaID, operationID, err := splitFullMethod(fullMethod)
+	if err != nil {
+		return nil, err
+	}
+	// the stream is canceled when it ends, so grpc releases it even if the caller does not read it to the end
+	ctx, cancel := context.WithCancel(ctx)
+
+	opts := getOpts(options...)
+	opts.Protocol = common.ProtocolGrpc
+
+	inv := invocation.New(ctx)
+	wrapInvocationWithOpts(inv, opts)
+	if err := setService(inv, service, opts); err != nil {
+		cancel()
+		return nil, err
+	}
+	var cs grpc.ClientStream
+	inv.SchemaID = schemaID
+	inv.OperationID = operationID
+	inv.IsStream = true
+	inv.StreamDesc = desc
+	inv.Reply = &cs
+
+	if err := gi.invoke(inv); err != nil {
+		cancel()
+		return nil, err
+	}
+	if cs == nil {
+		cancel()
+		inv.StreamDone(&invocation.Response{Err: ErrNoStream})
+		return nil, ErrNoStream
+	}
+	return newClientStream(ctx, cancel, cs, desc, inv), nil
+}
+
+// clientStream wraps the grpc stream to report its end to the handlers
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	*streamEnd
+}
+
+// streamEnd is the end of the stream, it is not referenced by the goroutine which waits for ctx,
+// so the clientStream dropped by the caller can be garbage collected and finished
+type streamEnd struct {
+	inv      *invocation.Invocation
+	cancel   context.CancelFunc
+	sent     int64
+	recv     int64
+	once     sync.Once
+	finished chan struct{}
+}
+
+func newClientStream(ctx context.Context, cancel context.CancelFunc, cs grpc.ClientStream, desc *grpc.StreamDesc, inv *invocation.Invocation) *clientStream {
+	end := &streamEnd{
+		inv:      inv,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			end.finish(status.FromContextError(ctx.Err()).Err())
+		case <-end.finished:
+		}
+	}()
+	s := &clientStream{
+		ClientStream: cs,
+		desc:         desc,
+		streamEnd:    end,
+	}
+	// the caller stops reading before the end and drops the stream without canceling ctx
+	runtime.SetFinalizer(s, func(s *clientStream) {
+		go s.finish(errStreamDropped)
+	})
+	return s
+}
+
+// SendMsg implements grpc.ClientStream
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	} else if err != io.EOF {
+		// io.EOF means the stream is aborted, the real error comes from RecvMsg
+		s.finish(err)
+	}
+	return err
+}
+
+// RecvMsg implements grpc.ClientStream
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	atomic.AddInt64(&s.recv, 1)
+	if !s.desc.ServerStreams {
+		// the only response of client streaming is received
+		s.finish(nil)
+	}
+	return nil
+}
+
+// finish reports the end of the stream once and cancels it
+func (e *streamEnd) finish(err error) {
+	e.once.Do(func() {
+		close(e.finished)
+		e.cancel()
+		if err == io.EOF {
+			err = nil
+		}
+		e.inv.StreamDone(&invocation.Response{
+			Status: int(status.Code(err)),
+			Err:    err,
+			Result: invocation.StreamResult{
+				SentMsgs: atomic.LoadInt64(&e.sent),
+				RecvMsgs: atomic.LoadInt64(&e.recv),
+			},
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package invoke
+
+import (
+	"strings"
+
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/handler"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+	"github.com/leon-yc/ggs/internal/pkg/runtime"
+	"github.com/leon-yc/ggs/internal/pkg/util"
+	"github.com/leon-yc/ggs/pkg/qlog"
+)
+
+// abstractInvoker is the common base of the protocol invokers
+type abstractInvoker struct {
+	opts Options
+}
+
+// invoke runs the invocation through the consumer chain
+func (ai *abstractInvoker) invoke(i *invocation.Invocation) error {
+	if len(i.Filters) == 0 {
+		i.Filters = ai.opts.Filters
+	}
+
+	c, err := handler.GetChain(common.Consumer, ai.opts.ChainName)
+	if err != nil {
+		qlog.Errorf("handler chain init err [%s]", err.Error())
+		return err
+	}
+
+	i.Ctx = common.WithContext(i.Ctx, common.HeaderSourceName, runtime.ServiceName)
+	_, err = c.Invoke(i)
+	return err
+}
+
+// wrapInvocationWithOpts fills the invocation with the call options
+func wrapInvocationWithOpts(i *invocation.Invocation, opts InvokeOptions) {
+	i.Endpoint = opts.Endpoint
+	i.Protocol = opts.Protocol
+	i.Port = opts.Port
+	i.Strategy = opts.StrategyFunc
+	i.Filters = opts.Filters
+	i.RouteTags = opts.RouteTags
+	for k, v := range opts.Metadata {
+		i.SetMetadata(k, v)
+	}
+}
+
+// setRoute decides how to find the remote of the invocation
+func setRoute(i *invocation.Invocation, host string, opts InvokeOptions) {
+	i.RouteType = opts.RouteType
+	if i.RouteType == common.RouteDefault {
+		if opts.Endpoint != "" || strings.Contains(host, ".") {
+			i.RouteType = common.RouteDirect
+		} else {
+			i.RouteType = common.RouteDiscovery
+		}
+	}
+	i.NoDiscovery = i.RouteType != common.RouteDiscovery
+}
+
+// setService sets the target of the invocation, the host is like <service>[:<port name>]
+// for discovery, and ip:port or domain for the others
+func setService(i *invocation.Invocation, host string, opts InvokeOptions) error {
+	setRoute(i, host, opts)
+	i.MicroServiceName = host
+	if i.NoDiscovery {
+		return nil
+	}
+	service, port, err := util.ParseServiceAndPort(host)
+	if err != nil {
+		return err
+	}
+	i.MicroServiceName = service
+	if i.Port == "" {
+		i.Port = port
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package invoke
+
+import (
+	"github.com/leon-yc/ggs/internal/core/common"
+	utiltags "github.com/leon-yc/ggs/internal/pkg/util/tags"
+)
+
+// Options struct having information about the invoker
+type Options struct {
+	// ChainName is the consumer chain used by the invoker
+	ChainName string
+	// Filters is the default loadbalance filters used by the invoker
+	Filters []string
+}
+
+// Option used by the invoker
+type Option func(*Options)
+
+// ChainName is option to set the consumer chain name.
+func ChainName(name string) Option {
+	return func(o *Options) {
+		o.ChainName = name
+	}
+}
+
+// Filters is option to set the default loadbalance filters.
+func Filters(filters ...string) Option {
+	return func(o *Options) {
+		o.Filters = filters
+	}
+}
+
+// newOptions is to get the invoker options
+func newOptions(options ...Option) Options {
+	opts := Options{
+		ChainName: common.DefaultChainName,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+	return opts
+}
+
+// InvokeOptions struct having information about a single call
+type InvokeOptions struct {
+	// Endpoint is the address of the remote instance, it skips discovery
+	Endpoint string
+	// Protocol is the transport protocol, like rest or grpc
+	Protocol string
+	// Port is the name of the remote port, like admin in rest-admin
+	Port string
+	// StrategyFunc is the loadbalance strategy name
+	StrategyFunc string
+	// Filters is the loadbalance filters
+	Filters []string
+	// RouteType decides how to find the remote, see common.Route*
+	RouteType string
+	// RouteTags is the tags for router
+	RouteTags utiltags.Tags
+	// Metadata is the local scope data
+	Metadata map[string]interface{}
+}
+
+// InvocationOption used by a single call
+type InvocationOption func(*InvokeOptions)
+
+// WithEndpoint is option to call the address directly.
+func WithEndpoint(ep string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.Endpoint = ep
+	}
+}
+
+// WithProtocol is option to set the transport protocol.
+func WithProtocol(p string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.Protocol = p
+	}
+}
+
+// WithPort is option to set the remote port name.
+func WithPort(p string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.Port = p
+	}
+}
+
+// WithStrategy is option to set the loadbalance strategy.
+func WithStrategy(s string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.StrategyFunc = s
+	}
+}
+
+// WithFilters is option to set the loadbalance filters.
+func WithFilters(f ...string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.Filters = f
+	}
+}
+
+// WithRouteTags is option to set the tags for router.
+func WithRouteTags(t map[string]string) InvocationOption {
+	return func(o *InvokeOptions) {
+		if len(t) == 0 {
+			return
+		}
+		o.RouteTags = utiltags.Tags{
+			KV:    t,
+			Label: utiltags.LabelOfTags(t),
+		}
+	}
+}
+
+// WithMetadata is option to set the local scope data.
+func WithMetadata(m map[string]interface{}) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.Metadata = m
+	}
+}
+
+// WithRouteType is option to set the route type.
+func WithRouteType(t string) InvocationOption {
+	return func(o *InvokeOptions) {
+		o.RouteType = t
+	}
+}
+
+// WithDiscovery is option to find the remote by service discovery.
+func WithDiscovery() InvocationOption {
+	return WithRouteType(common.RouteDiscovery)
+}
+
+// WithDirect is option to call the remote by SLB or ip:port.
+func WithDirect() InvocationOption {
+	return WithRouteType(common.RouteDirect)
+}
+
+// WithSidecar is option to call the remote through the sidecar agent.
+func WithSidecar() InvocationOption {
+	return WithRouteType(common.RouteSidecar)
+}
+
+// getOpts is to get the call options
+func getOpts(options ...InvocationOption) InvokeOptions {
+	opts := InvokeOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+	return opts
+}
@@ -0,0 +1,71 @@
+// Package rest provides shortcuts to send restful requests through the consumer chain.
+package rest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/leon-yc/ggs/invoke"
+)
+
+var defaultInvoker = invoke.NewRestInvoker()
+
+// ContextDo sends the request with the default rest invoker
+func ContextDo(ctx context.Context, req *http.Request, opts ...invoke.InvocationOption) (*http.Response, error) {
+	return defaultInvoker.ContextDo(ctx, req, opts...)
+}
+
+// ContextGet issues a GET to the url
+func ContextGet(ctx context.Context, url string, opts ...invoke.InvocationOption) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ContextDo(ctx, req, opts...)
+}
+
+// ContextHead issues a HEAD to the url
+func ContextHead(ctx context.Context, url string, opts ...invoke.InvocationOption) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ContextDo(ctx, req, opts...)
+}
+
+// ContextPost issues a POST to the url with the body
+func ContextPost(ctx context.Context, url, contentType string, body io.Reader, opts ...invoke.InvocationOption) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return ContextDo(ctx, req, opts...)
+}
+
+// ContextPostForm issues a POST to the url with the form data
+func ContextPostForm(ctx context.Context, url string, data url.Values, opts ...invoke.InvocationOption) (*http.Response, error) {
+	return ContextPost(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()), opts...)
+}
+
+// ContextPut issues a PUT to the url with the body
+func ContextPut(ctx context.Context, url, contentType string, body io.Reader, opts ...invoke.InvocationOption) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPut, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return ContextDo(ctx, req, opts...)
+}
+
+// ContextDelete issues a DELETE to the url
+func ContextDelete(ctx context.Context, url string, opts ...invoke.InvocationOption) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ContextDo(ctx, req, opts...)
+}
@@ -0,0 +1,57 @@
+package invoke
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/leon-yc/ggs/internal/client/rest"
+	"github.com/leon-yc/ggs/internal/core/common"
+	"github.com/leon-yc/ggs/internal/core/invocation"
+)
+
+const (
+	// HTTP is the http scheme
+	HTTP = "http"
+	// HTTPS is the https scheme
+	HTTPS = "https"
+)
+
+// RestInvoker is the invoker of restful requests
+type RestInvoker struct {
+	abstractInvoker
+}
+
+// NewRestInvoker is the function to create a rest invoker
+func NewRestInvoker(opt ...Option) *RestInvoker {
+	return &RestInvoker{
+		abstractInvoker: abstractInvoker{
+			opts: newOptions(opt...),
+		},
+	}
+}
+
+// ContextDo sends the request through the consumer chain and returns the response,
+// the url of the request should be like http://<service>[:<port name>]/path
+func (ri *RestInvoker) ContextDo(ctx context.Context, req *http.Request, options ...InvocationOption) (*http.Response, error) {
+	if req.URL.Scheme != HTTP && req.URL.Scheme != HTTPS {
+		return nil, fmt.Errorf("scheme invalid: %s, only support {http|https}://", req.URL.Scheme)
+	}
+
+	opts := getOpts(options...)
+	opts.Protocol = common.ProtocolRest
+
+	resp := rest.NewResponse()
+	inv := invocation.New(ctx)
+	wrapInvocationWithOpts(inv, opts)
+	if err := setService(inv, req.URL.Host, opts); err != nil {
+		return nil, err
+	}
+	inv.SetMetadata(common.RestMethod, req.Method)
+	inv.Args = req
+	inv.Reply = resp
+	inv.URLPathFormat = req.URL.Path
+
+	err := ri.invoke(inv)
+	return resp, err
+}
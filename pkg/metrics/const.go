@@ -29,11 +29,15 @@ const (
 	ClientGrpcReqDuration     = "grpc_client_request_duration_seconds_bucket"
 	ClientGrpcReqDurationHelp = "The GRPC request latencies in seconds on client side."
 
+	ClientGrpcStreamMsgs     = "grpc_client_stream_msgs_total"
+	ClientGrpcStreamMsgsHelp = "Total number of GRPC stream messages on client side."
+
 	ReqProtocolLable = "protocol"
 	RespUriLable     = "uri"
 	RespCodeLable    = "status"
 	RespHandlerLable = "handler"
 	RemoteLable      = "remote"
+	DirectionLable   = "direction"
 
 	//qps, duration for redis
 	RedisReqCount     = "redis_count"
@@ -44,4 +48,30 @@ const (
 
 	RedisReqDurationSecond     = "redis_duration"
 	RedisReqDurationSecondHelp = "Latency of redis duration in second."
+
+	//active health check of the instances
+	HealthCheckCount     = "health_check_total"
+	HealthCheckCountHelp = "Total number of active health checks of the instances."
+
+	HealthCheckEjections     = "health_check_ejections_total"
+	HealthCheckEjectionsHelp = "Total number of the instances ejected or readmitted by health check."
+
+	HealthCheckEjected     = "health_check_ejected_instances"
+	HealthCheckEjectedHelp = "Number of the instances ejected by health check."
+
+	HealthCheckService = "service"
+	HealthCheckProbe   = "probe"
+	HealthCheckResult  = "result"
+	HealthCheckAction  = "action"
+
+	//passive outlier detection of the instances
+	OutlierEjections     = "outlier_detection_ejections_total"
+	OutlierEjectionsHelp = "Total number of the instances ejected or readmitted by outlier detection."
+
+	OutlierEjected     = "outlier_detection_ejected_instances"
+	OutlierEjectedHelp = "Number of the instances ejected by outlier detection."
+
+	OutlierService = "service"
+	OutlierAction  = "action"
+	OutlierReason  = "reason"
 )
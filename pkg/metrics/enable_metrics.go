@@ -109,6 +109,14 @@ func enableConsumerMetrics() error {
 		return err
 	}
 
+	if err := CreateCounter(CounterOpts{
+		Name:   ClientGrpcStreamMsgs,
+		Help:   ClientGrpcStreamMsgsHelp,
+		Labels: []string{RemoteLable, ReqProtocolLable, RespHandlerLable, DirectionLable},
+	}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -287,3 +295,51 @@ func enableRedisMetrics() error {
 
 	return nil
 }
+
+func enableHealthCheckMetrics() error {
+	if err := CreateCounter(CounterOpts{
+		Name:   HealthCheckCount,
+		Help:   HealthCheckCountHelp,
+		Labels: []string{HealthCheckService, HealthCheckProbe, HealthCheckResult},
+	}); err != nil {
+		return err
+	}
+
+	if err := CreateCounter(CounterOpts{
+		Name:   HealthCheckEjections,
+		Help:   HealthCheckEjectionsHelp,
+		Labels: []string{HealthCheckService, HealthCheckAction},
+	}); err != nil {
+		return err
+	}
+
+	if err := CreateGauge(GaugeOpts{
+		Name:   HealthCheckEjected,
+		Help:   HealthCheckEjectedHelp,
+		Labels: []string{HealthCheckService},
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func enableOutlierDetectionMetrics() error {
+	if err := CreateCounter(CounterOpts{
+		Name:   OutlierEjections,
+		Help:   OutlierEjectionsHelp,
+		Labels: []string{OutlierService, OutlierAction, OutlierReason},
+	}); err != nil {
+		return err
+	}
+
+	if err := CreateGauge(GaugeOpts{
+		Name:   OutlierEjected,
+		Help:   OutlierEjectedHelp,
+		Labels: []string{OutlierService},
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -130,6 +130,13 @@ func Init() error {
 		return err
 	}
 
+	if err := enableHealthCheckMetrics(); err != nil {
+		return err
+	}
+	if err := enableOutlierDetectionMetrics(); err != nil {
+		return err
+	}
+
 	if archaius.GetBool("ggs.metrics.autometrics.enabled", false) {
 		if err := enableAutoRegistryMetrics(); err != nil {
 			qlog.Error(err)